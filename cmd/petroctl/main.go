@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	osuser "os/user"
+	"path"
+	"petropavlovsk-budget/internal/auth"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/storage"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/term"
+)
+
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+const passwordEnv = "PETROCTL_PASSWORD"
+
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+const usage = `Использование: petroctl <команда> [аргументы]
+
+Команды:
+  user create --email E --nickname N [--role R] [--department D] [источник пароля]
+  user set-role --email E --role R [--department D]
+  user reset-password --email E [источник пароля]
+  user list
+  user disable --email E [--enable]
+  images rebuild
+  storage migrate --from local|s3 --to local|s3 [--dry-run] [--overwrite]
+  storage gc [--min-age 24h] [--dry-run]
+
+Для роли department обязательно подразделение (--department), от имени
+которого пользователь публикует официальные ответы.
+
+Источники пароля (не более одного):
+  --password P          значение из флага
+  $PETROCTL_PASSWORD    переменная окружения
+  --password-stdin      первая строка стандартного ввода
+  --generate            сгенерировать случайный пароль и вывести его
+  иначе                 запросить пароль в терминале без отображения
+
+Коды завершения: 0 — успех, 1 — ошибка, 2 — неверные аргументы, 3 — пользователь не найден.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	commands := map[string]map[string]func([]string) error{
+		"user": {
+			"create":         userCreate,
+			"set-role":       userSetRole,
+			"reset-password": userResetPassword,
+			"list":           userList,
+			"disable":        userDisable,
+		},
+		"images": {
+			"rebuild": imagesRebuild,
+		},
+		"storage": {
+			"migrate": storageMigrate,
+			"gc":      storageGC,
+		},
+	}
+
+	if len(args) < 2 || commands[args[0]] == nil {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "неизвестная команда: %s %s\n\n%s", args[0], args[1], usage)
+		return exitUsage
+	}
+
+	defer func() {
+		if database != nil {
+			database.Close()
+		}
+	}()
+
+	err := cmd(args[2:])
+	var ue usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ue):
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitUsage
+	case errors.Is(err, flag.ErrHelp), errors.Is(err, errBadFlags):
+		return exitUsage
+	case errors.Is(err, pgx.ErrNoRows):
+		fmt.Fprintln(os.Stderr, "пользователь не найден")
+		return exitNotFound
+	default:
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return exitError
+	}
+}
+
+var database *db.Database
+
+// connect opens the database lazily so that usage errors are reported
+// without needing DATABASE_URL.
+func connect() error {
+	if database != nil {
+		return nil
+	}
+	d, err := db.New()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	database = d
+	return nil
+}
+
+// audit records a change to a user in the audit log, attributed to the
+// operating-system account that ran petroctl. The change has already been
+// made, so a failure is only reported.
+func audit(action string, userID int, before, after interface{}) {
+	actor := "petroctl"
+	if u, err := osuser.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	entry := &models.AuditEntry{
+		ActorName:  actor,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(userID),
+	}
+	if before != nil {
+		entry.Before, _ = json.Marshal(before)
+	}
+	if after != nil {
+		entry.After, _ = json.Marshal(after)
+	}
+	if err := database.AddAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "не удалось записать в журнал аудита: %v\n", err)
+	}
+}
+
+type passwordFlags struct {
+	password  string
+	fromStdin bool
+	generate  bool
+}
+
+func (p *passwordFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.password, "password", "", "пароль (небезопасно: виден в списке процессов)")
+	fs.BoolVar(&p.fromStdin, "password-stdin", false, "прочитать пароль из стандартного ввода")
+	fs.BoolVar(&p.generate, "generate", false, "сгенерировать случайный пароль")
+}
+
+// check refuses more than one password source. Naming two is a usage
+// error rather than one silently winning: a forgotten PETROCTL_PASSWORD
+// must not replace the password asked for with --generate.
+func (p *passwordFlags) check() error {
+	var sources []string
+	if p.password != "" {
+		sources = append(sources, "--password")
+	}
+	if os.Getenv(passwordEnv) != "" {
+		sources = append(sources, "$"+passwordEnv)
+	}
+	if p.fromStdin {
+		sources = append(sources, "--password-stdin")
+	}
+	if p.generate {
+		sources = append(sources, "--generate")
+	}
+	if len(sources) > 1 {
+		return usagef("указано несколько источников пароля: %s", strings.Join(sources, ", "))
+	}
+	return nil
+}
+
+// resolve returns the password and whether it was generated, in which case
+// the caller must show it to the operator exactly once.
+func (p *passwordFlags) resolve() (string, bool, error) {
+	if err := p.check(); err != nil {
+		return "", false, err
+	}
+
+	var password string
+	switch {
+	case p.password != "":
+		password = p.password
+	case os.Getenv(passwordEnv) != "":
+		password = os.Getenv(passwordEnv)
+	case p.fromStdin:
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			return "", false, fmt.Errorf("чтение пароля из stdin: %w", err)
+		}
+		password = line
+	case p.generate:
+		generated, err := auth.GeneratePassword(16)
+		return generated, true, err
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		var err error
+		password, err = promptPassword()
+		if err != nil {
+			return "", false, err
+		}
+	default:
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			return "", false, fmt.Errorf("чтение пароля: %w", err)
+		}
+		password = line
+	}
+
+	if err := auth.ValidatePassword(password); err != nil {
+		return "", false, usageError{err.Error()}
+	}
+	return password, false, nil
+}
+
+// promptPassword asks for the password twice on the terminal without
+// echoing it.
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	fmt.Fprint(os.Stderr, "Пароль: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("чтение пароля: %w", err)
+	}
+	fmt.Fprint(os.Stderr, "Повторите пароль: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("чтение пароля: %w", err)
+	}
+	if string(first) != string(second) {
+		return "", usagef("пароли не совпадают")
+	}
+	return string(first), nil
+}
+
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseFlags parses args into fs and refuses anything left over: a stray
+// word is most likely a password typed where it would end up in the shell
+// history.
+// errBadFlags is returned for flags the flag package rejected; it has
+// already printed the problem and the command's usage.
+var errBadFlags = errors.New("invalid flags")
+
+// parseFlags parses a command's flags and refuses positional arguments.
+// Both kinds of mistakes end with exitUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errBadFlags
+	}
+	if fs.NArg() > 0 {
+		return usagef("лишние аргументы: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of a command; path is the command as
+// typed after petroctl, e.g. "storage gc", and appears in its usage.
+func newFlagSet(path string) *flag.FlagSet {
+	fs := flag.NewFlagSet("petroctl "+path, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func requireRole(role string) error {
+	if !auth.ValidRole(role) {
+		return usagef("недопустимая роль %q, доступны: %s", role, strings.Join(auth.Roles, ", "))
+	}
+	return nil
+}
+
+// departmentFor returns the department a user with role should have:
+// department users need one, everyone else has none. current is kept when
+// the flag is not given.
+func departmentFor(role, flagValue, current string) (string, error) {
+	if role != "department" {
+		if flagValue != "" {
+			return "", usagef("--department задаётся только для роли department")
+		}
+		return "", nil
+	}
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if current == "" {
+		return "", usagef("--department обязателен для роли department")
+	}
+	return current, nil
+}
+
+// setDepartment records the user's department if it changed.
+func setDepartment(u *models.User, department string) error {
+	if u.Department == department {
+		return nil
+	}
+	if err := database.SetUserDepartment(u.Email, department); err != nil {
+		return err
+	}
+	audit("user.department", u.ID, map[string]string{"department": u.Department}, map[string]string{"department": department})
+	fmt.Printf("Подразделение пользователя %s: %q\n", u.Email, department)
+	return nil
+}
+
+func userCreate(args []string) error {
+	fs := newFlagSet("user create")
+	email := fs.String("email", "", "email пользователя")
+	nickname := fs.String("nickname", "", "никнейм")
+	role := fs.String("role", "citizen", "роль: "+strings.Join(auth.Roles, ", "))
+	department := fs.String("department", "", "подразделение акимата для роли department")
+	var pf passwordFlags
+	pf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := pf.check(); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return usagef("--email обязателен")
+	}
+	if err := requireRole(*role); err != nil {
+		return err
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	// Creating an existing user is not an error so provisioning scripts can
+	// be re-run; only the role is brought in line, the password is kept.
+	existing, err := database.GetUserByEmail(*email)
+	if err == nil {
+		dept, err := departmentFor(*role, strings.TrimSpace(*department), existing.Department)
+		if err != nil {
+			return err
+		}
+		if existing.Role != *role {
+			if err := database.SetUserRole(*email, *role); err != nil {
+				return err
+			}
+			audit("user.role", existing.ID, map[string]string{"role": existing.Role}, map[string]string{"role": *role})
+			fmt.Printf("Пользователь %s уже существует, роль изменена: %s → %s\n", *email, existing.Role, *role)
+		} else if existing.Department == dept {
+			fmt.Printf("Пользователь %s уже существует (роль %s), изменений нет\n", *email, existing.Role)
+		}
+		return setDepartment(existing, dept)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	dept, err := departmentFor(*role, strings.TrimSpace(*department), "")
+	if err != nil {
+		return err
+	}
+
+	if *nickname == "" {
+		return usagef("--nickname обязателен при создании пользователя")
+	}
+
+	password, generated, err := pf.resolve()
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	user, err := database.CreateUserWithRole(*email, *nickname, hash, *role)
+	if err != nil {
+		return err
+	}
+	audit("user.create", user.ID, nil, map[string]string{"email": user.Email, "nickname": user.Nickname, "role": user.Role})
+
+	fmt.Printf("Пользователь создан: ID %d, %s, роль %s\n", user.ID, user.Email, user.Role)
+	if err := setDepartment(user, dept); err != nil {
+		return err
+	}
+	if generated {
+		fmt.Printf("Сгенерированный пароль: %s\n", password)
+	}
+	return nil
+}
+
+func userSetRole(args []string) error {
+	fs := newFlagSet("user set-role")
+	email := fs.String("email", "", "email пользователя")
+	role := fs.String("role", "", "новая роль: "+strings.Join(auth.Roles, ", "))
+	department := fs.String("department", "", "подразделение акимата для роли department")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return usagef("--email обязателен")
+	}
+	if err := requireRole(*role); err != nil {
+		return err
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	existing, err := database.GetUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+	dept, err := departmentFor(*role, strings.TrimSpace(*department), existing.Department)
+	if err != nil {
+		return err
+	}
+	if err := database.SetUserRole(*email, *role); err != nil {
+		return err
+	}
+	audit("user.role", existing.ID, map[string]string{"role": existing.Role}, map[string]string{"role": *role})
+
+	fmt.Printf("Роль пользователя %s: %s\n", *email, *role)
+	return setDepartment(existing, dept)
+}
+
+func userResetPassword(args []string) error {
+	fs := newFlagSet("user reset-password")
+	email := fs.String("email", "", "email пользователя")
+	var pf passwordFlags
+	pf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := pf.check(); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return usagef("--email обязателен")
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	existing, err := database.GetUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := pf.resolve()
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	if err := database.SetUserPassword(*email, hash); err != nil {
+		return err
+	}
+	audit("user.password_reset", existing.ID, nil, nil)
+
+	fmt.Printf("Пароль пользователя %s изменён\n", *email)
+	if generated {
+		fmt.Printf("Сгенерированный пароль: %s\n", password)
+	}
+	return nil
+}
+
+func userList(args []string) error {
+	fs := newFlagSet("user list")
+	role := fs.String("role", "", "показать только пользователей с этой ролью")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := connect(); err != nil {
+		return err
+	}
+
+	users, err := database.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNICKNAME\tROLE\tDEPARTMENT\tSTATUS\tCREATED")
+	for _, u := range users {
+		if *role != "" && u.Role != *role {
+			continue
+		}
+		status := "active"
+		if u.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			u.ID, u.Email, u.Nickname, u.Role, u.Department, status, u.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func userDisable(args []string) error {
+	fs := newFlagSet("user disable")
+	email := fs.String("email", "", "email пользователя")
+	enable := fs.Bool("enable", false, "снять блокировку вместо блокировки")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return usagef("--email обязателен")
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	existing, err := database.GetUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+	if err := database.SetUserDisabled(*email, !*enable); err != nil {
+		return err
+	}
+	action := "user.disable"
+	if *enable {
+		action = "user.enable"
+	}
+	audit(action, existing.ID, map[string]bool{"disabled": existing.Disabled}, map[string]bool{"disabled": !*enable})
+
+	if *enable {
+		fmt.Printf("Пользователь %s разблокирован\n", *email)
+	} else {
+		fmt.Printf("Пользователь %s заблокирован\n", *email)
+	}
+	return nil
+}
+
+// imagesRebuild runs every uploaded photo through the image pipeline
+// again: uploads saved before it existed get their metadata stripped and
+// their thumb, card and WebP copies created. It works on the backend
+// chosen by STORAGE_BACKEND and records the variants' real sizes in the
+// database, which srcset needs.
+func imagesRebuild(args []string) error {
+	flags := newFlagSet("images rebuild")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	files, err := storage.New()
+	if err != nil {
+		return err
+	}
+	objects, err := files.List("uploads/")
+	if err != nil {
+		return err
+	}
+
+	var done, failed int
+	for _, obj := range objects {
+		key := obj.Key
+		if models.IsImageVariant(key) || strings.HasPrefix(path.Base(key), storage.TempPrefix) {
+			continue
+		}
+		switch strings.ToLower(path.Ext(key)) {
+		case ".jpg", ".jpeg", ".png":
+		default:
+			continue
+		}
+
+		img, err := storage.RebuildImage(files, key)
+		if err == nil {
+			err = database.UpdateImageVariants(img)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", key, err)
+			failed++
+			continue
+		}
+		done++
+	}
+
+	fmt.Printf("Обработано изображений: %d, с ошибками: %d\n", done, failed)
+	if failed > 0 {
+		return fmt.Errorf("не удалось обработать %d изображений", failed)
+	}
+	return nil
+}
+
+// storageMigrate copies uploads and private files from one backend to
+// another. Files already present at the destination are skipped unless
+// --overwrite is given, so an interrupted run can simply be repeated. The
+// source is left untouched; remove it once the servers use the new backend.
+func storageMigrate(args []string) error {
+	flags := newFlagSet("storage migrate")
+	from := flags.String("from", "", "исходное хранилище: local или s3")
+	to := flags.String("to", "", "целевое хранилище: local или s3")
+	dryRun := flags.Bool("dry-run", false, "только показать, что будет скопировано")
+	overwrite := flags.Bool("overwrite", false, "перезаписывать существующие файлы")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		return usagef("--from и --to обязательны")
+	}
+	if *from == *to {
+		return usagef("--from и --to совпадают")
+	}
+	src, err := storage.Open(*from)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	dst, err := storage.Open(*to)
+	if err != nil {
+		return usagef("%v", err)
+	}
+
+	var copied, skipped int
+	for _, prefix := range []string{"uploads/", storage.PrivateDir + "/"} {
+		objects, err := src.List(prefix)
+		if err != nil {
+			return err
+		}
+		existing := map[string]bool{}
+		if !*overwrite {
+			present, err := dst.List(prefix)
+			if err != nil {
+				return err
+			}
+			for _, obj := range present {
+				existing[obj.Key] = true
+			}
+		}
+
+		for _, obj := range objects {
+			key := obj.Key
+			if existing[key] || strings.HasPrefix(path.Base(key), storage.TempPrefix) {
+				skipped++
+				continue
+			}
+			if *dryRun {
+				fmt.Println(key)
+				copied++
+				continue
+			}
+
+			file, err := src.Open(key)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if err := dst.Put(key, data); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			copied++
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("Будет скопировано файлов: %d, уже на месте: %d\n", copied, skipped)
+	} else {
+		fmt.Printf("Скопировано файлов: %d, уже на месте: %d\n", copied, skipped)
+	}
+	return nil
+}
+
+// storageGC removes uploads whose project no longer exists, along with
+// temporary files left by interrupted writes. --min-age keeps files young
+// enough to belong to a submission still in progress.
+func storageGC(args []string) error {
+	flags := newFlagSet("storage gc")
+	minAge := flags.Duration("min-age", 24*time.Hour, "не трогать файлы моложе этого срока")
+	dryRun := flags.Bool("dry-run", false, "только показать, что будет удалено")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *minAge < time.Hour {
+		return usagef("--min-age не может быть меньше часа")
+	}
+	files, err := storage.New()
+	if err != nil {
+		return err
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+	projects, err := database.GetProjectIDs()
+	if err != nil {
+		return err
+	}
+
+	removed, err := storage.CollectOrphans(files, projects, *minAge, *dryRun)
+	for _, key := range removed {
+		fmt.Println(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("Будет удалено файлов: %d\n", len(removed))
+	} else {
+		fmt.Printf("Удалено файлов: %d\n", len(removed))
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
         "petropavlovsk-budget/internal/db"
         "petropavlovsk-budget/internal/handlers"
         "petropavlovsk-budget/internal/middleware"
+        "petropavlovsk-budget/internal/storage"
 
         "github.com/go-chi/chi/v5"
         chimiddleware "github.com/go-chi/chi/v5/middleware"
@@ -20,6 +21,14 @@ func main() {
         }
         defer database.Close()
 
+        if err := database.BackfillVoteFingerprints(); err != nil {
+                log.Printf("Failed to backfill vote fingerprints: %v", err)
+        }
+
+        if err := database.BackfillVoteLog(); err != nil {
+                log.Printf("Failed to backfill vote log: %v", err)
+        }
+
         sessionSecret := os.Getenv("SESSION_SECRET")
         if sessionSecret == "" {
                 sessionSecret = "default-secret-key-change-in-production"
@@ -36,9 +45,16 @@ func main() {
 
         h := handlers.New(database, store)
 
+        trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+        if err != nil {
+                log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+        }
+
         r := chi.NewRouter()
+        r.Use(middleware.RealIP(trustedProxies))
         r.Use(chimiddleware.Logger)
         r.Use(chimiddleware.Recoverer)
+        r.Use(middleware.LoadUser(store, database))
         r.Use(func(next http.Handler) http.Handler {
                 return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
                         w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -49,7 +65,7 @@ func main() {
         })
 
         r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-        r.Handle("/uploads/*", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
+        r.Handle("/uploads/*", storage.PublicHandler(h.Files))
 
         r.Get("/", h.Home)
         r.Get("/register", h.RegisterPage)
@@ -63,21 +79,84 @@ func main() {
         r.Get("/map", h.MapPage)
         r.Get("/api/map/data", h.MapData)
         r.Get("/api/map/popup/{id}", h.ProjectPopup)
+        r.Get("/receipt", h.ReceiptPage)
+        r.Get("/cycles/{id}/log.json", h.CycleLogExport)
+        r.Get("/results", h.ResultsIndex)
+        r.Get("/results/{id}", h.ResultsPage)
+        r.Get("/results/{id}/results.csv", h.ResultsCSV)
+        r.Get("/results/{id}/results.json", h.ResultsJSON)
 
         r.Group(func(r chi.Router) {
                 r.Use(middleware.RequireAuth(store))
                 r.Get("/profile", h.ProfilePage)
+                r.Get("/notifications/badge", h.NotificationsBadge)
                 r.Get("/submit", h.SubmitPage)
                 r.Post("/submit", h.SubmitProject)
+                r.Post("/drafts", h.DraftSave)
+                r.Post("/drafts/{id}/delete", h.DraftDelete)
+                r.Get("/projects/{id}/edit", h.ProjectEditPage)
+                r.Post("/projects/{id}/edit", h.ProjectEditSave)
+                r.Get("/projects/{id}/photos", h.ProjectPhotosPage)
+                r.Post("/projects/{id}/photos", h.ProjectPhotosAdd)
+                r.Post("/projects/{id}/photos/remove", h.ProjectPhotoRemove)
+                r.Post("/projects/{id}/photos/move", h.ProjectPhotoMove)
                 r.Post("/vote", h.VoteSubmit)
+                r.Post("/vote/withdraw", h.VoteWithdraw)
                 r.Post("/comments", h.CreateComment)
+                r.Post("/comments/{id}/report", h.ReportComment)
+                r.Post("/comments/{id}/useful", h.CommentUseful)
+                r.Get("/verify", h.VerifyPage)
+                r.Post("/verify", h.VerifySubmit)
+                r.Post("/verify/confirm", h.VerifyConfirm)
+                r.Get("/cycles/{id}/ballot", h.BallotPage)
+                r.Post("/cycles/{id}/ballot", h.BallotSubmit)
+                r.Post("/cycles/{id}/ballot/withdraw", h.BallotWithdraw)
+                r.Get("/department", h.DepartmentPage)
+                r.Post("/projects/{id}/response", h.OfficialResponseSave)
+                r.Get("/implementation", h.ImplementationList)
+                r.Get("/implementation/{id}", h.ImplementationPage)
+                r.Post("/implementation/{id}", h.ImplementationSave)
+                r.Post("/implementation/{id}/milestones", h.MilestoneSave)
+                r.Post("/implementation/{id}/milestones/{mid}/delete", h.MilestoneDelete)
+                r.Post("/implementation/{id}/spend", h.SpendCreate)
+                r.Post("/implementation/{id}/photos", h.ProgressPhotoUpload)
+                r.Post("/implementation/{id}/photos/{pid}/delete", h.ProgressPhotoDelete)
         })
 
         r.Group(func(r chi.Router) {
                 r.Use(middleware.RequireAdmin(store))
+                r.Use(h.AuditAdmin)
                 r.Get("/admin", h.AdminDashboard)
                 r.Post("/admin/update-status", h.AdminUpdateProjectStatus)
                 r.Post("/admin/edit-project", h.AdminEditProject)
+                r.Get("/admin/moderation", h.ModerationStats)
+                r.Post("/admin/moderation/{id}/claim", h.ModerationClaim)
+                r.Post("/admin/moderation/{id}/release", h.ModerationRelease)
+                r.Post("/admin/moderation/{id}/assign", h.ModerationAssign)
+                r.Get("/admin/reasons", h.AdminRejectionReasons)
+                r.Post("/admin/reasons", h.AdminSaveRejectionReason)
+                r.Get("/admin/audit", h.AdminAuditLog)
+                r.Get("/admin/audit.csv", h.AdminAuditCSV)
+                r.Get("/admin/comments", h.AdminComments)
+                r.Post("/admin/comments/{id}", h.AdminModerateComment)
+                r.Get("/admin/verifications", h.AdminVerifications)
+                r.Post("/admin/verifications/review", h.AdminReviewVerification)
+                r.Get("/admin/verifications/{id}/document", h.AdminVerificationDocument)
+                r.Get("/admin/fraud", h.AdminFraudReport)
+                r.Post("/admin/fraud/quarantine", h.AdminQuarantineVotes)
+                r.Post("/admin/fraud/release", h.AdminReleaseVote)
+                r.Get("/admin/cycles", h.AdminCycles)
+                r.Post("/admin/cycles", h.AdminSaveCycle)
+                r.Get("/admin/cycles/{id}/tally", h.AdminCycleTally)
+                r.Post("/admin/cycles/{id}/apply", h.AdminApplyTally)
+                r.Post("/admin/cycles/{id}/seal", h.AdminSealCycle)
+                r.Get("/admin/paper", h.AdminPaperBallots)
+                r.Post("/admin/paper/preview", h.AdminPaperPreview)
+                r.Post("/admin/paper/import", h.AdminPaperImport)
+                r.Post("/admin/paper/errors", h.AdminPaperErrors)
+                r.Get("/kiosk", h.KioskPage)
+                r.Get("/kiosk/{id}", h.KioskBallotPage)
+                r.Post("/kiosk/{id}", h.KioskBallotSubmit)
         })
 
         log.Println("Server starting on http://0.0.0.0:5000")
@@ -0,0 +1,162 @@
+// Command verify-tally checks an exported cycle log offline. It verifies
+// the hash chain, compares its end with the published root hash, replays
+// the log and recounts the result with the same rules the server uses,
+// then reports any difference from the result the server published.
+//
+// It needs no database or network access: only the file downloaded from
+// /cycles/{id}/log.json.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"petropavlovsk-budget/internal/votelog"
+	"sort"
+	"text/tabwriter"
+)
+
+const (
+	exitOK       = 0
+	exitMismatch = 1
+	exitUsage    = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("verify-tally", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	root := fs.String("root", "", "корневой хеш, опубликованный независимо от файла (по умолчанию — из файла)")
+	receipt := fs.String("receipt", "", "проверить, что бюллетень с этим кодом квитанции учтён")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Использование: verify-tally [--root HASH] [--receipt CODE] cycle-log.json|-")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nКоды завершения: 0 — итоги подтверждены, 1 — найдено расхождение, 2 — неверные аргументы или файл.")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	export, err := load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка чтения журнала: %v\n", err)
+		return exitUsage
+	}
+
+	expectedRoot := export.RootHash
+	if *root != "" {
+		if expectedRoot != "" && expectedRoot != *root {
+			fmt.Printf("✗ корневой хеш в файле (%s) не совпадает с указанным (%s)\n", expectedRoot, *root)
+			return exitMismatch
+		}
+		expectedRoot = *root
+	}
+
+	fmt.Printf("Цикл %d «%s», метод %s, бюджет %d ₸, записей в журнале: %d\n",
+		export.CycleID, export.Title, export.BallotType, export.Budget, len(export.Entries))
+
+	ok := true
+
+	if err := votelog.Verify(export.Entries, expectedRoot); err != nil {
+		fmt.Printf("✗ цепочка хешей нарушена: %v\n", err)
+		ok = false
+	} else if expectedRoot == "" {
+		fmt.Println("✓ цепочка хешей цела (корневой хеш ещё не опубликован)")
+	} else {
+		fmt.Printf("✓ цепочка хешей цела и заканчивается корневым хешем %s\n", expectedRoot)
+	}
+
+	if *receipt != "" {
+		code := votelog.NormalizeReceipt(*receipt)
+		counted, found := false, false
+		for _, e := range export.Entries {
+			if e.Receipt != code {
+				continue
+			}
+			found = true
+			counted = e.Kind != votelog.KindVoid
+		}
+		switch {
+		case !found:
+			fmt.Printf("✗ квитанция %s в журнале не найдена\n", code)
+			ok = false
+		case counted:
+			fmt.Printf("✓ квитанция %s найдена и учитывается\n", code)
+		default:
+			fmt.Printf("! квитанция %s найдена, но исключена из подсчёта\n", code)
+		}
+	}
+
+	result := export.Recount()
+
+	titles := map[int]string{}
+	var ids []int
+	for _, p := range export.Projects {
+		titles[p.ID] = p.Title
+		ids = append(ids, p.ID)
+	}
+	sort.Ints(ids)
+
+	published := map[int]bool{}
+	for _, id := range export.Result.Winners {
+		published[id] = true
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nID\tПРОЕКТ\tПЕРЕСЧЁТ\tОПУБЛИКОВАНО\tПОБЕДИТЕЛЬ")
+	for _, id := range ids {
+		mark := ""
+		switch {
+		case result.IsWinner(id) && published[id]:
+			mark = "да"
+		case result.IsWinner(id):
+			mark = "да (не опубликован!)"
+		case published[id]:
+			mark = "нет (опубликован!)"
+		}
+		diff := ""
+		if result.Scores[id] != export.Result.Scores[id] {
+			diff = " ✗"
+			ok = false
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d%s\t%s\n", id, titles[id], result.Scores[id], export.Result.Scores[id], diff, mark)
+		if result.IsWinner(id) != published[id] {
+			ok = false
+		}
+	}
+	tw.Flush()
+
+	if !ok {
+		fmt.Println("\n✗ итоги не подтверждены")
+		return exitMismatch
+	}
+	fmt.Println("\n✓ итоги подтверждены: пересчёт по журналу совпадает с опубликованными")
+	return exitOK
+}
+
+func load(path string) (*votelog.Export, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var export votelog.Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
@@ -3,10 +3,12 @@ module petropavlovsk-budget
 go 1.23
 
 require (
+	github.com/chai2010/webp v1.4.0
 	github.com/go-chi/chi/v5 v5.0.11
 	github.com/gorilla/sessions v1.2.2
 	github.com/jackc/pgx/v5 v5.5.1
 	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
 )
 
 require (
@@ -15,5 +17,6 @@ require (
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
 	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,134 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CommentVerdict is the outcome of screening a comment before it is
+// published. A toxic comment is held for a moderator instead.
+type CommentVerdict struct {
+	Toxic  bool
+	Reason string
+	// Source names what decided: "gemini", "rules" or "off".
+	Source string
+}
+
+// CommentScreener decides whether a discussion comment may be published
+// at once.
+type CommentScreener interface {
+	Screen(comment string) CommentVerdict
+}
+
+// NewCommentScreener picks the screener named by COMMENT_SCREENING:
+// "gemini" asks Gemini and falls back to the word rules when it cannot be
+// reached, "rules" uses the word rules only and "off" publishes
+// everything. The default, "auto", is "gemini" when GEMINI_API_KEY is set
+// and "rules" otherwise.
+func NewCommentScreener() (CommentScreener, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	switch mode := os.Getenv("COMMENT_SCREENING"); mode {
+	case "", "auto":
+		if apiKey == "" {
+			return RuleScreener{}, nil
+		}
+		return GeminiScreener{APIKey: apiKey}, nil
+	case "gemini":
+		if apiKey == "" {
+			return nil, errors.New("COMMENT_SCREENING=gemini needs GEMINI_API_KEY")
+		}
+		return GeminiScreener{APIKey: apiKey}, nil
+	case "rules":
+		return RuleScreener{}, nil
+	case "off":
+		return offScreener{}, nil
+	default:
+		return nil, fmt.Errorf("unknown COMMENT_SCREENING %q", mode)
+	}
+}
+
+type offScreener struct{}
+
+func (offScreener) Screen(string) CommentVerdict {
+	return CommentVerdict{Source: "off"}
+}
+
+// RuleScreener holds comments containing obscene or insulting words in
+// Russian or Kazakh. It needs no network, so it also backs up Gemini.
+type RuleScreener struct{}
+
+// toxicStems match at the start of a word, so that "ебу" catches the
+// obscenity but not "требую".
+var toxicStems = []string{
+	"хуй", "хуе", "хуё", "хуя", "пизд", "еба", "ебл", "ебу", "ебн", "ёба", "ёбн",
+	"бля", "сука", "суки", "мудак", "мудил", "пидор", "пидар", "гандон",
+	"долбоеб", "долбоёб", "залуп", "шлюх", "дебил", "идиот", "тварь", "мразь", "ублюд",
+	"шешеңді", "сік", "қотақ", "көтің", "ақымақ",
+}
+
+func (RuleScreener) Screen(comment string) CommentVerdict {
+	words := strings.FieldsFunc(strings.ToLower(comment), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		for _, stem := range toxicStems {
+			if strings.HasPrefix(w, stem) {
+				return CommentVerdict{Toxic: true, Reason: "Комментарий содержит грубые или оскорбительные выражения", Source: "rules"}
+			}
+		}
+	}
+	return CommentVerdict{Source: "rules"}
+}
+
+// GeminiScreener asks Gemini whether a comment is toxic. When Gemini
+// cannot be reached or answers nonsense, RuleScreener decides instead.
+type GeminiScreener struct {
+	APIKey string
+}
+
+// geminiScreeningTimeout bounds how long a comment waits for Gemini before
+// the word rules decide instead.
+const geminiScreeningTimeout = 10 * time.Second
+
+func (s GeminiScreener) Screen(comment string) CommentVerdict {
+	var result struct {
+		Toxic  bool   `json:"toxic"`
+		Reason string `json:"reason"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), geminiScreeningTimeout)
+	defer cancel()
+	if err := generateJSON(ctx, s.APIKey, screeningPrompt(comment), &result); err != nil {
+		log.Printf("comment screening: gemini unavailable, using rules: %v", err)
+		return RuleScreener{}.Screen(comment)
+	}
+	return CommentVerdict{Toxic: result.Toxic, Reason: result.Reason, Source: "gemini"}
+}
+
+// screeningPrompt asks whether a discussion comment is toxic.
+func screeningPrompt(comment string) string {
+	return fmt.Sprintf(`Ты модератор обсуждений на городской платформе партисипаторного бюджета Петропавловска, Казахстан.
+Комментарий может быть на русском или казахском языке.
+%s
+
+%s
+
+Комментарий ТОКСИЧЕН, если содержит:
+- ненормативную лексику
+- оскорбления или унижение людей, в том числе автора проекта
+- угрозы, травлю, разжигание вражды
+- чужие персональные данные (телефоны, адреса, ИИН)
+
+Резкая, но вежливая критика проекта токсичной НЕ является.
+
+Ответь СТРОГО в формате JSON:
+{
+  "toxic": true/false,
+  "reason": "краткое объяснение на русском языке (одно предложение)"
+}`, ignoreCommentInstructions, quoteComment(comment))
+}
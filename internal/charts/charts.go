@@ -0,0 +1,192 @@
+// Package charts renders small SVG charts on the server, so result pages
+// show their figures without JavaScript. Every function returns markup
+// ready to be inlined into an html/template; labels are escaped here.
+package charts
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	width      = 640
+	labelWidth = 200
+	rowHeight  = 28
+	barHeight  = 18
+)
+
+// Palette is used in order for bars and legend entries.
+var Palette = []string{"#2563eb", "#16a34a", "#ea580c", "#9333ea", "#0891b2", "#dc2626", "#ca8a04", "#4b5563"}
+
+// Bar is one labelled value of a horizontal bar chart. Highlight draws the
+// bar in the accent colour, e.g. for winning projects.
+type Bar struct {
+	Label     string
+	Value     int
+	Note      string
+	Highlight bool
+}
+
+// Point is one day of a time series.
+type Point struct {
+	Date  time.Time
+	Value int
+}
+
+func esc(s string) string {
+	return template.HTMLEscapeString(s)
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func open(b *strings.Builder, height int, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s" font-family="sans-serif" font-size="12">`,
+		width, height, esc(title))
+	fmt.Fprintf(b, `<title>%s</title>`, esc(title))
+}
+
+// HBar draws one horizontal bar per item, scaled to the largest value.
+func HBar(title string, bars []Bar, unit string) template.HTML {
+	if len(bars) == 0 {
+		return ""
+	}
+
+	max := 0
+	for _, bar := range bars {
+		if bar.Value > max {
+			max = bar.Value
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	var b strings.Builder
+	height := len(bars)*rowHeight + 8
+	open(&b, height, title)
+
+	track := float64(width - labelWidth - 90)
+	for i, bar := range bars {
+		y := i*rowHeight + 4
+		color := Palette[0]
+		if bar.Highlight {
+			color = Palette[1]
+		}
+		w := track * float64(bar.Value) / float64(max)
+		if bar.Value > 0 && w < 2 {
+			w = 2
+		}
+		label := truncate(bar.Label, 30)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#374151">%s</text>`, labelWidth-8, y+barHeight-5, esc(label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" rx="3" fill="%s"><title>%s: %d %s</title></rect>`,
+			labelWidth, y, w, barHeight, color, esc(bar.Label), bar.Value, esc(unit))
+		value := fmt.Sprintf("%d", bar.Value)
+		if bar.Note != "" {
+			value += " · " + bar.Note
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="#111827">%s</text>`, float64(labelWidth)+w+6, y+barHeight-5, esc(value))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Progress draws a single bar showing used out of total.
+func Progress(title string, used, total int, usedLabel, restLabel string) template.HTML {
+	if total <= 0 {
+		return ""
+	}
+	share := float64(used) / float64(total)
+	if share > 1 {
+		share = 1
+	}
+
+	var b strings.Builder
+	open(&b, 64, title)
+	fmt.Fprintf(&b, `<rect x="0" y="8" width="%d" height="24" rx="4" fill="#e5e7eb"/>`, width)
+	fmt.Fprintf(&b, `<rect x="0" y="8" width="%.1f" height="24" rx="4" fill="%s"/>`, float64(width)*share, Palette[1])
+	fmt.Fprintf(&b, `<text x="0" y="52" fill="#166534">%s</text>`, esc(usedLabel))
+	fmt.Fprintf(&b, `<text x="%d" y="52" text-anchor="end" fill="#4b5563">%s</text>`, width, esc(restLabel))
+	fmt.Fprintf(&b, `<text x="%d" y="25" text-anchor="middle" fill="#111827" font-weight="bold">%.0f%%</text>`, width/2, share*100)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Timeline draws daily values as columns and their running total as a
+// line on its own scale, labelled at the right edge.
+func Timeline(title string, points []Point) template.HTML {
+	if len(points) == 0 {
+		return ""
+	}
+
+	const (
+		height = 220
+		left   = 40
+		right  = 70
+		top    = 12
+		bottom = 28
+	)
+	plotW := float64(width - left - right)
+	plotH := float64(height - top - bottom)
+
+	maxDay, total := 0, 0
+	for _, p := range points {
+		if p.Value > maxDay {
+			maxDay = p.Value
+		}
+		total += p.Value
+	}
+	if maxDay == 0 {
+		maxDay = 1
+	}
+	scaleTotal := total
+	if scaleTotal == 0 {
+		scaleTotal = 1
+	}
+
+	var b strings.Builder
+	open(&b, height, title)
+
+	base := float64(top) + plotH
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#9ca3af"/>`, left, base, float64(left)+plotW, base)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#6b7280">%d</text>`, left-4, top+8, maxDay)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" fill="#6b7280">0</text>`, left-4, base)
+
+	step := plotW / float64(len(points))
+	colW := step * 0.7
+	if colW > 24 {
+		colW = 24
+	}
+	labelEvery := len(points)/8 + 1
+
+	var line []string
+	running := 0
+	for i, p := range points {
+		x := float64(left) + step*float64(i) + (step-colW)/2
+		h := plotH * float64(p.Value) / float64(maxDay)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" opacity="0.35"><title>%s: %d</title></rect>`,
+			x, base-h, colW, h, Palette[0], p.Date.Format("02.01.2006"), p.Value)
+
+		running += p.Value
+		cx := x + colW/2
+		cy := base - plotH*float64(running)/float64(scaleTotal)
+		line = append(line, fmt.Sprintf("%.1f,%.1f", cx, cy))
+
+		if i%labelEvery == 0 || i == len(points)-1 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" fill="#6b7280">%s</text>`, cx, height-10, p.Date.Format("02.01"))
+		}
+	}
+
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(line, " "), Palette[0])
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="%s" font-weight="bold">всего %d</text>`, float64(left)+plotW+6, top+8, Palette[0], total)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
@@ -0,0 +1,42 @@
+// Package csvexport writes CSV files that are meant to be opened in a
+// spreadsheet. Spreadsheet programs run a cell starting with =, +, - or @
+// as a formula, so any text that came from users – project titles, audit
+// details, user agents – could otherwise run in the reader's spreadsheet.
+package csvexport
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// Cell returns s as it is safe to put in a spreadsheet: text starting
+// with a formula character, a tab or a carriage return is prefixed with
+// an apostrophe, which spreadsheets read as "this is text".
+func Cell(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + s
+	}
+	return s
+}
+
+// Writer is a csv.Writer that passes every cell through Cell.
+type Writer struct {
+	*csv.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{csv.NewWriter(w)}
+}
+
+// Write writes one record with every cell escaped.
+func (w *Writer) Write(record []string) error {
+	safe := make([]string, len(record))
+	for i, s := range record {
+		safe[i] = Cell(s)
+	}
+	return w.Writer.Write(safe)
+}
@@ -0,0 +1,42 @@
+package csvexport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCell(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"Сквер на Жамбыла", "Сквер на Жамбыла"},
+		{"1500000", "1500000"},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+7 705 000 00 00", "'+7 705 000 00 00"},
+		{"-1+2", "'-1+2"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+	}
+	for _, tt := range tests {
+		if got := Cell(tt.in); got != tt.want {
+			t.Errorf("Cell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]string{"id", "title"})
+	w.Write([]string{"1", "=cmd|' /C calc'!A0"})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id,title\n1,'=cmd|' /C calc'!A0\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+)
+
+// AddAuditEntry appends an entry to the audit log. The log cannot be
+// changed afterwards.
+func (db *Database) AddAuditEntry(e *models.AuditEntry) error {
+	ctx := context.Background()
+	return db.Pool.QueryRow(ctx,
+		`INSERT INTO audit_log (actor_id, actor_name, action, target_type, target_id, before, after, ip, user_agent)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+		e.ActorID, e.ActorName, e.Action, e.TargetType, e.TargetID, nullJSON(e.Before), nullJSON(e.After), e.IP, e.UserAgent,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// nullJSON stores a missing snapshot as NULL rather than invalid JSON.
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// GetAuditLog returns the entries matching f, newest first. A limit of 0
+// returns them all.
+func (db *Database) GetAuditLog(f models.AuditFilter, limit int) ([]models.AuditEntry, error) {
+	ctx := context.Background()
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Actor != "" {
+		if id, err := strconv.Atoi(f.Actor); err == nil {
+			where = append(where, "actor_id = "+arg(id))
+		} else {
+			where = append(where, "actor_name ILIKE "+arg("%"+f.Actor+"%"))
+		}
+	}
+	if f.Action != "" {
+		where = append(where, "action = "+arg(f.Action))
+	}
+	if f.TargetType != "" {
+		where = append(where, "target_type = "+arg(f.TargetType))
+	}
+	if f.TargetID != "" {
+		where = append(where, "target_id = "+arg(f.TargetID))
+	}
+	if f.From != nil {
+		where = append(where, "created_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "created_at < "+arg(*f.To))
+	}
+
+	query := `SELECT id, actor_id, actor_name, action, target_type, target_id, before, after, ip, user_agent, created_at
+                 FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT " + arg(limit)
+	}
+
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID,
+			&before, &after, &e.IP, &e.UserAgent, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetAuditActions lists the actions present in the log, for filtering.
+func (db *Database) GetAuditActions() ([]string, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, "SELECT DISTINCT action FROM audit_log ORDER BY action")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotInModeration = errors.New("project is not in moderation")
+
+var ErrNoChanges = errors.New("resubmission changes nothing")
+
+// RequestChanges sends a project in moderation back to its author with the
+// list of things to fix, keeps a copy of its fields to compare the
+// resubmission with, and notifies the author.
+func (db *Database) RequestChanges(projectID, adminID int, items []string, comment string) error {
+	ctx := context.Background()
+
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorID int
+	var p models.ProjectSubmission
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, title, description, category, district, budget, lat, lng FROM projects
+                 WHERE id = $1 AND status = 'moderation' FOR UPDATE`,
+		projectID,
+	).Scan(&authorID, &p.Title, &p.Description, &p.Category, &p.District, &p.Budget, &p.Lat, &p.Lng)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotInModeration
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := finishModeration(ctx, tx, projectID, adminID, "changes_requested", nil); err != nil {
+		return err
+	}
+	if err := setModerationStatus(ctx, tx, projectID, adminID, "changes_requested", comment, nil); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO change_requests (project_id, requested_by, items, comment,
+                        title, description, category, district, budget, lat, lng)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		projectID, adminID, itemsJSON, comment,
+		p.Title, p.Description, p.Category, p.District, p.Budget, p.Lat, p.Lng,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = notify(ctx, tx, authorID,
+		fmt.Sprintf("Проект «%s» возвращён на доработку: модератор просит внести изменения", p.Title),
+		fmt.Sprintf("/projects/%d", projectID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ResubmitProject applies the author's corrections to a project sent back
+// for changes and returns it to the moderation queue, where its SLA
+// starts over. A resubmission identical to the returned project is refused
+// with ErrNoChanges; otherwise analysis replaces the AI analysis.
+func (db *Database) ResubmitProject(projectID, authorID int, edited models.ProjectSubmission, reason, analysis string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	changed, err := reviseProject(ctx, tx, projectID, authorID, authorID, reason, func(s *models.ProjectSubmission) {
+		*s = edited
+	})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return ErrNoChanges
+	}
+	if err := setAIAnalysis(ctx, tx, projectID, analysis); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx,
+		"UPDATE projects SET status = 'moderation', moderation_since = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'changes_requested'",
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotEditable
+	}
+
+	comment := "Автор внёс исправления"
+	if reason != "" {
+		comment += ": " + reason
+	}
+	_, err = tx.Exec(ctx,
+		"INSERT INTO project_status_history (project_id, status, comment) VALUES ($1, 'moderation', $2)",
+		projectID, comment,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE change_requests SET resubmitted_at = CURRENT_TIMESTAMP WHERE project_id = $1 AND resubmitted_at IS NULL",
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const changeRequestColumns = `c.id, c.project_id, COALESCE(c.requested_by, 0), c.items, c.comment,
+        c.title, c.description, c.category, c.district, c.budget, c.lat, c.lng, c.created_at, c.resubmitted_at`
+
+func scanChangeRequest(row pgx.Row) (*models.ChangeRequest, error) {
+	var c models.ChangeRequest
+	var itemsJSON []byte
+	err := row.Scan(&c.ID, &c.ProjectID, &c.RequestedBy, &itemsJSON, &c.Comment,
+		&c.Before.Title, &c.Before.Description, &c.Before.Category, &c.Before.District, &c.Before.Budget,
+		&c.Before.Lat, &c.Before.Lng, &c.CreatedAt, &c.ResubmittedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(itemsJSON, &c.Items); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetLatestChangeRequest returns pgx.ErrNoRows if changes were never
+// requested for the project.
+func (db *Database) GetLatestChangeRequest(projectID int) (*models.ChangeRequest, error) {
+	ctx := context.Background()
+	return scanChangeRequest(db.Pool.QueryRow(ctx,
+		"SELECT "+changeRequestColumns+" FROM change_requests c WHERE c.project_id = $1 ORDER BY c.id DESC LIMIT 1",
+		projectID,
+	))
+}
+
+// GetResubmissions returns, for every project back in moderation after
+// changes were requested, the latest of those requests.
+func (db *Database) GetResubmissions() (map[int]*models.ChangeRequest, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT DISTINCT ON (c.project_id) `+changeRequestColumns+`
+                 FROM change_requests c
+                 JOIN projects p ON c.project_id = p.id
+                 WHERE p.status = 'moderation' AND c.resubmitted_at IS NOT NULL
+                 ORDER BY c.project_id, c.id DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := map[int]*models.ChangeRequest{}
+	for rows.Next() {
+		c, err := scanChangeRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests[c.ProjectID] = c
+	}
+	return requests, rows.Err()
+}
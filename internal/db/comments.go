@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxCommentDepth is how deep replies nest: replies to a comment, and
+// replies to those.
+const maxCommentDepth = 2
+
+var (
+	ErrCommentNotFound     = errors.New("comment not found")
+	ErrCommentOwn          = errors.New("cannot report or mark own comment")
+	ErrCommentReported     = errors.New("comment already reported by this user")
+	ErrCommentNotPublished = errors.New("comment is not published")
+	ErrCommentDeleted      = errors.New("comment has been deleted")
+)
+
+// ReportComment records a user's complaint about a published comment.
+// Each user can report a comment once.
+func (db *Database) ReportComment(commentID, userID int, reason string) error {
+	ctx := context.Background()
+
+	var authorID int
+	var status string
+	err := db.Pool.QueryRow(ctx, "SELECT user_id, status FROM comments WHERE id = $1", commentID).Scan(&authorID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if authorID == userID {
+		return ErrCommentOwn
+	}
+	if status != "published" {
+		return ErrCommentNotPublished
+	}
+
+	_, err = db.Pool.Exec(ctx,
+		"INSERT INTO comment_reports (comment_id, user_id, reason) VALUES ($1, $2, $3)",
+		commentID, userID, reason,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrCommentReported
+	}
+	return err
+}
+
+// ToggleCommentUseful marks a published comment as useful for the user,
+// or takes the mark back. It returns whether the comment is now marked
+// and how many users marked it.
+func (db *Database) ToggleCommentUseful(commentID, userID int) (bool, int, error) {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorID int
+	var status string
+	err = tx.QueryRow(ctx, "SELECT user_id, status FROM comments WHERE id = $1 FOR SHARE", commentID).Scan(&authorID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, ErrCommentNotFound
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if authorID == userID {
+		return false, 0, ErrCommentOwn
+	}
+	if status != "published" {
+		return false, 0, ErrCommentNotPublished
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM comment_reactions WHERE comment_id = $1 AND user_id = $2", commentID, userID)
+	if err != nil {
+		return false, 0, err
+	}
+	reacted := tag.RowsAffected() == 0
+	if reacted {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO comment_reactions (comment_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			commentID, userID,
+		)
+		if err != nil {
+			return false, 0, err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM comment_reactions WHERE comment_id = $1", commentID).Scan(&count); err != nil {
+		return false, 0, err
+	}
+	return reacted, count, tx.Commit(ctx)
+}
+
+// GetCommentQueue returns the comments waiting for a moderator: those
+// held by screening and published ones with open reports, most reported
+// first.
+func (db *Database) GetCommentQueue() ([]models.CommentQueueItem, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT c.id, c.project_id, c.user_id, u.email, c.content, c.status,
+                        COALESCE(c.screening_reason, ''), c.created_at, p.title,
+                        COUNT(r.id), COALESCE(array_agg(r.reason ORDER BY r.created_at) FILTER (WHERE r.id IS NOT NULL), '{}')
+                 FROM comments c
+                 JOIN users u ON u.id = c.user_id
+                 JOIN projects p ON p.id = c.project_id
+                 LEFT JOIN comment_reports r ON r.comment_id = c.id AND r.resolved_at IS NULL
+                 WHERE c.status IN ('published', 'held')
+                 GROUP BY c.id, u.email, p.title
+                 HAVING c.status = 'held' OR COUNT(r.id) > 0
+                 ORDER BY COUNT(r.id) DESC, c.created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queue []models.CommentQueueItem
+	for rows.Next() {
+		var item models.CommentQueueItem
+		err := rows.Scan(&item.ID, &item.ProjectID, &item.UserID, &item.UserEmail, &item.Content, &item.Status,
+			&item.ScreeningReason, &item.CreatedAt, &item.ProjectTitle, &item.Reports, &item.ReportReasons)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, item)
+	}
+	return queue, rows.Err()
+}
+
+// ModerateComment sets a comment's status to "published", "hidden" or
+// "deleted" and closes its open reports. Deleting also erases the text,
+// so it cannot be undone. The author is told about any change of status.
+// It returns the comment as it was before.
+func (db *Database) ModerateComment(commentID, moderatorID int, status, reason string) (*models.Comment, error) {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var c models.Comment
+	err = tx.QueryRow(ctx,
+		`SELECT id, project_id, user_id, content, status, COALESCE(screening_reason, ''), COALESCE(moderation_reason, ''), created_at
+                 FROM comments WHERE id = $1 FOR UPDATE`,
+		commentID,
+	).Scan(&c.ID, &c.ProjectID, &c.UserID, &c.Content, &c.Status, &c.ScreeningReason, &c.ModerationReason, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Status == "deleted" {
+		return nil, ErrCommentDeleted
+	}
+
+	if status != c.Status {
+		_, err = tx.Exec(ctx,
+			`UPDATE comments
+                         SET status = $1, moderation_reason = NULLIF($2, ''), moderated_by = $3, moderated_at = CURRENT_TIMESTAMP,
+                             content = CASE WHEN $1 = 'deleted' THEN '' ELSE content END
+                         WHERE id = $4`,
+			status, reason, moderatorID, commentID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var message string
+		switch status {
+		case "published":
+			message = "Ваш комментарий опубликован после проверки модератором"
+		case "hidden":
+			message = "Ваш комментарий скрыт модератором: " + reason
+		case "deleted":
+			message = "Ваш комментарий удалён модератором: " + reason
+		}
+		link := fmt.Sprintf("/projects/%d#comment-%d", c.ProjectID, c.ID)
+		if err := notify(ctx, tx, c.UserID, message, link); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE comment_reports SET resolved_at = CURRENT_TIMESTAMP WHERE comment_id = $1 AND resolved_at IS NULL",
+		commentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, tx.Commit(ctx)
+}
@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/tally"
+	"petropavlovsk-budget/internal/votelog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrCycleLocked       = errors.New("cycle budget and ballot rules cannot change after voting has started")
+	ErrBallotExists      = errors.New("ballot already submitted for this cycle")
+	ErrBallotInvalidItem = errors.New("ballot contains a project that is not open for voting in this cycle")
+	ErrVotingStarted     = errors.New("projects and their budgets cannot change after the cycle's voting has started")
+)
+
+const cycleColumns = `id, title, budget, ballot_type, COALESCE(max_picks, 0), COALESCE(credits, 0), vote_start, vote_end, COALESCE(root_hash, ''), COALESCE(root_seq, 0), sealed_at, created_at,
+        EXISTS (SELECT 1 FROM ballots WHERE ballots.cycle_id = budget_cycles.id)
+                OR EXISTS (SELECT 1 FROM votes JOIN projects ON projects.id = votes.project_id WHERE projects.cycle_id = budget_cycles.id)`
+
+func scanCycle(row pgx.Row) (*models.BudgetCycle, error) {
+	var c models.BudgetCycle
+	if err := row.Scan(&c.ID, &c.Title, &c.Budget, &c.BallotType, &c.MaxPicks, &c.Credits, &c.VoteStart, &c.VoteEnd, &c.RootHash, &c.RootSeq, &c.SealedAt, &c.CreatedAt, &c.HasVotes); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (db *Database) CreateCycle(c *models.BudgetCycle) error {
+	ctx := context.Background()
+
+	return db.Pool.QueryRow(ctx,
+		`INSERT INTO budget_cycles (title, budget, ballot_type, max_picks, credits, vote_start, vote_end)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		c.Title, c.Budget, c.BallotType, c.MaxPicks, c.Credits, c.VoteStart, c.VoteEnd,
+	).Scan(&c.ID, &c.CreatedAt)
+}
+
+// UpdateCycle saves the cycle. Once it has votes only the title and the
+// voting window may change: a different budget or ballot rules would
+// count the ballots already cast by rules their voters never saw.
+func (db *Database) UpdateCycle(c *models.BudgetCycle) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := scanCycle(tx.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1 FOR UPDATE", c.ID))
+	if err != nil {
+		return err
+	}
+	if current.HasVotes && (c.Budget != current.Budget || c.BallotType != current.BallotType ||
+		c.MaxPicks != current.MaxPicks || c.Credits != current.Credits) {
+		return ErrCycleLocked
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE budget_cycles SET title = $1, budget = $2, ballot_type = $3, max_picks = $4, credits = $5, vote_start = $6, vote_end = $7 WHERE id = $8",
+		c.Title, c.Budget, c.BallotType, c.MaxPicks, c.Credits, c.VoteStart, c.VoteEnd, c.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// lockCycleOpen checks, inside tx, that the cycle exists and its voting has
+// not started, and holds it so the window cannot be moved until tx ends.
+func lockCycleOpen(ctx context.Context, tx pgx.Tx, cycleID int) error {
+	cycle, err := scanCycle(tx.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1 FOR SHARE", cycleID))
+	if err != nil {
+		return err
+	}
+	if cycle.VotingStarted(time.Now()) {
+		return ErrVotingStarted
+	}
+	return nil
+}
+
+func (db *Database) GetCycle(id int) (*models.BudgetCycle, error) {
+	ctx := context.Background()
+	return scanCycle(db.Pool.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1", id))
+}
+
+func (db *Database) ListCycles() ([]models.BudgetCycle, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, "SELECT "+cycleColumns+" FROM budget_cycles ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cycles []models.BudgetCycle
+	for rows.Next() {
+		c, err := scanCycle(rows)
+		if err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, *c)
+	}
+
+	return cycles, nil
+}
+
+func (db *Database) GetCycleProjects(cycleID int) ([]models.Project, error) {
+	projects, err := db.GetAllProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []models.Project
+	for _, p := range projects {
+		if p.CycleID != nil && *p.CycleID == cycleID {
+			result = append(result, p)
+		}
+	}
+
+	return result, nil
+}
+
+// CreateBallot stores a ballot and its entries in one transaction. The
+// projects and the cycle's ballot rules are re-checked under the
+// transaction so a ballot is either recorded whole or not at all.
+func (db *Database) CreateBallot(b *models.Ballot) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertBallot(ctx, tx, b); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func insertBallot(ctx context.Context, tx pgx.Tx, b *models.Ballot) error {
+	cycle, err := scanCycle(tx.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1", b.CycleID))
+	if err != nil {
+		return err
+	}
+
+	costs := map[int]int{}
+	for _, e := range b.Entries {
+		var cost int
+		err := tx.QueryRow(ctx,
+			"SELECT budget FROM projects WHERE id = $1 AND cycle_id = $2 AND status = 'voting'",
+			e.ProjectID, b.CycleID,
+		).Scan(&cost)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrBallotInvalidItem
+		}
+		if err != nil {
+			return err
+		}
+		costs[e.ProjectID] = cost
+	}
+	if err := tally.Validate(*cycle, b.Entries, costs); err != nil {
+		return err
+	}
+
+	if b.Channel == "" {
+		b.Channel = models.ChannelOnline
+	}
+
+	receipt, err := votelog.NewReceipt()
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ballots (cycle_id, user_id, ballot_type, ip, user_agent, receipt, channel, recorded_by, batch_id)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+		b.CycleID, b.UserID, b.BallotType, b.IP, b.UserAgent, receipt, b.Channel, b.RecordedBy, b.BatchID,
+	).Scan(&b.ID, &b.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrBallotExists
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range b.Entries {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO ballot_entries (ballot_id, project_id, value) VALUES ($1, $2, $3)",
+			b.ID, e.ProjectID, e.Value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := appendVoteLog(ctx, tx, b.CycleID, votelog.KindCast, receipt, b.Entries); err != nil {
+		return err
+	}
+	b.Receipt = receipt
+
+	return nil
+}
+
+func (db *Database) GetUserBallot(cycleID, userID int) (*models.Ballot, error) {
+	ctx := context.Background()
+	var b models.Ballot
+
+	err := db.Pool.QueryRow(ctx,
+		"SELECT id, cycle_id, user_id, ballot_type, COALESCE(receipt, ''), created_at FROM ballots WHERE cycle_id = $1 AND user_id = $2 AND withdrawn_at IS NULL",
+		cycleID, userID,
+	).Scan(&b.ID, &b.CycleID, &b.UserID, &b.BallotType, &b.Receipt, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Entries, err = db.getBallotEntries(ctx, b.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (db *Database) getBallotEntries(ctx context.Context, ballotID int) ([]models.BallotEntry, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT project_id, value FROM ballot_entries WHERE ballot_id = $1 ORDER BY value, project_id",
+		ballotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.BallotEntry
+	for rows.Next() {
+		var e models.BallotEntry
+		if err := rows.Scan(&e.ProjectID, &e.Value); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// GetCycleBallots loads every counted ballot of the cycle with its entries:
+// withdrawn and quarantined ballots are left out.
+func (db *Database) GetCycleBallots(cycleID int) ([]models.Ballot, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT b.id, b.cycle_id, b.user_id, b.ballot_type, b.created_at, e.project_id, e.value
+                 FROM ballots b
+                 JOIN ballot_entries e ON e.ballot_id = b.id
+                 WHERE b.cycle_id = $1 AND b.withdrawn_at IS NULL AND NOT b.quarantined
+                 ORDER BY b.id, e.value, e.project_id`,
+		cycleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ballots []models.Ballot
+	for rows.Next() {
+		var b models.Ballot
+		var e models.BallotEntry
+		if err := rows.Scan(&b.ID, &b.CycleID, &b.UserID, &b.BallotType, &b.CreatedAt, &e.ProjectID, &e.Value); err != nil {
+			return nil, err
+		}
+		if n := len(ballots); n > 0 && ballots[n-1].ID == b.ID {
+			ballots[n-1].Entries = append(ballots[n-1].Entries, e)
+			continue
+		}
+		b.Entries = []models.BallotEntry{e}
+		ballots = append(ballots, b)
+	}
+
+	return ballots, nil
+}
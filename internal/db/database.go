@@ -3,10 +3,15 @@ package db
 import (
         "context"
         "encoding/json"
+        "errors"
         "fmt"
         "os"
+        "petropavlovsk-budget/internal/achievements"
+        "petropavlovsk-budget/internal/minhash"
         "petropavlovsk-budget/internal/models"
+        "petropavlovsk-budget/internal/votelog"
 
+        "github.com/jackc/pgx/v5"
         "github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -63,6 +68,16 @@ func (db *Database) initSchema() error {
                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
         );
 
+        CREATE TABLE IF NOT EXISTS budget_cycles (
+                id SERIAL PRIMARY KEY,
+                title TEXT NOT NULL,
+                budget INT NOT NULL,
+                ballot_type TEXT NOT NULL DEFAULT 'single',
+                vote_start TIMESTAMP,
+                vote_end TIMESTAMP,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
         CREATE TABLE IF NOT EXISTS votes (
                 id SERIAL PRIMARY KEY,
                 project_id INT REFERENCES projects(id) ON DELETE CASCADE,
@@ -96,55 +111,650 @@ func (db *Database) initSchema() error {
                 PRIMARY KEY (user_id, achievement_id)
         );
 
-        CREATE INDEX IF NOT EXISTS idx_projects_status ON projects(status);
-        CREATE INDEX IF NOT EXISTS idx_votes_project ON votes(project_id);
-        CREATE INDEX IF NOT EXISTS idx_comments_project ON comments(project_id);
-        CREATE INDEX IF NOT EXISTS idx_status_history_project ON project_status_history(project_id);
-        CREATE INDEX IF NOT EXISTS idx_user_achievements_user ON user_achievements(user_id);
-        `
+        CREATE TABLE IF NOT EXISTS identity_verifications (
+                id SERIAL PRIMARY KEY,
+                user_id INT UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+                iin_hash TEXT NOT NULL,
+                method TEXT NOT NULL,
+                status TEXT NOT NULL DEFAULT 'pending',
+                phone TEXT,
+                document_path TEXT,
+                otp_hash TEXT,
+                otp_expires_at TIMESTAMP,
+                otp_attempts INT DEFAULT 0,
+                review_comment TEXT,
+                reviewer_id INT REFERENCES users(id),
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                reviewed_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS vote_quarantine_log (
+                id SERIAL PRIMARY KEY,
+                vote_id INT REFERENCES votes(id) ON DELETE SET NULL,
+                action TEXT NOT NULL,
+                reason TEXT,
+                score INT,
+                admin_id INT REFERENCES users(id),
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS ballots (
+                id SERIAL PRIMARY KEY,
+                cycle_id INT REFERENCES budget_cycles(id) ON DELETE CASCADE,
+                user_id INT REFERENCES users(id) ON DELETE CASCADE,
+                ballot_type TEXT NOT NULL,
+                ip TEXT,
+                user_agent TEXT,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS ballot_entries (
+                ballot_id INT REFERENCES ballots(id) ON DELETE CASCADE,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                value INT NOT NULL DEFAULT 1,
+                PRIMARY KEY (ballot_id, project_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS vote_comment_bands (
+                vote_id INT REFERENCES votes(id) ON DELETE CASCADE,
+                band BIGINT NOT NULL,
+                PRIMARY KEY (vote_id, band)
+        );
+
+        CREATE TABLE IF NOT EXISTS vote_changes (
+                id SERIAL PRIMARY KEY,
+                user_id INT REFERENCES users(id) ON DELETE CASCADE,
+                action VARCHAR(20) NOT NULL,
+                vote_id INT REFERENCES votes(id) ON DELETE CASCADE,
+                ballot_id INT REFERENCES ballots(id) ON DELETE CASCADE,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                to_project_id INT REFERENCES projects(id) ON DELETE SET NULL,
+                cycle_id INT REFERENCES budget_cycles(id) ON DELETE CASCADE,
+                comment TEXT,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS paper_batches (
+                id SERIAL PRIMARY KEY,
+                admin_id INT REFERENCES users(id),
+                filename TEXT NOT NULL,
+                rows INT NOT NULL DEFAULT 0,
+                imported INT NOT NULL DEFAULT 0,
+                skipped INT NOT NULL DEFAULT 0,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS implementations (
+                project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+                implementer_id INT REFERENCES users(id) ON DELETE SET NULL,
+                contractor TEXT NOT NULL DEFAULT '',
+                contract_number TEXT NOT NULL DEFAULT '',
+                contract_amount INT NOT NULL DEFAULT 0,
+                planned_start DATE,
+                planned_end DATE,
+                actual_start DATE,
+                actual_end DATE,
+                percent_complete INT NOT NULL DEFAULT 0,
+                delay_reason TEXT NOT NULL DEFAULT '',
+                updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS milestones (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                title TEXT NOT NULL,
+                planned_date DATE,
+                actual_date DATE,
+                delay_reason TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS spend_entries (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                amount INT NOT NULL,
+                description TEXT NOT NULL,
+                spent_on DATE NOT NULL,
+                recorded_by INT REFERENCES users(id),
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS progress_photos (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                milestone_id INT REFERENCES milestones(id) ON DELETE SET NULL,
+                stage TEXT NOT NULL,
+                path TEXT NOT NULL,
+                caption TEXT DEFAULT '',
+                taken_on DATE NOT NULL,
+                uploaded_by INT REFERENCES users(id),
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS project_drafts (
+                id SERIAL PRIMARY KEY,
+                user_id INT REFERENCES users(id) ON DELETE CASCADE,
+                title TEXT NOT NULL DEFAULT '',
+                description TEXT NOT NULL DEFAULT '',
+                category TEXT NOT NULL DEFAULT '',
+                district TEXT NOT NULL DEFAULT '',
+                budget INT NOT NULL DEFAULT 0,
+                lat FLOAT,
+                lng FLOAT,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS project_revisions (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                editor_id INT REFERENCES users(id),
+                title TEXT NOT NULL,
+                description TEXT NOT NULL,
+                category TEXT NOT NULL,
+                district TEXT NOT NULL,
+                budget INT NOT NULL,
+                lat FLOAT NOT NULL,
+                lng FLOAT NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS change_requests (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                requested_by INT REFERENCES users(id),
+                items JSONB NOT NULL DEFAULT '[]',
+                comment TEXT NOT NULL DEFAULT '',
+                title TEXT NOT NULL,
+                description TEXT NOT NULL,
+                category TEXT NOT NULL,
+                district TEXT NOT NULL,
+                budget INT NOT NULL,
+                lat FLOAT NOT NULL,
+                lng FLOAT NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                resubmitted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS notifications (
+                id SERIAL PRIMARY KEY,
+                user_id INT REFERENCES users(id) ON DELETE CASCADE,
+                message TEXT NOT NULL,
+                link TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                read_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS moderation_claims (
+                project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+                moderator_id INT REFERENCES users(id) ON DELETE CASCADE,
+                claimed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                expires_at TIMESTAMP NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS rejection_proposals (
+                project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+                proposed_by INT REFERENCES users(id) ON DELETE CASCADE,
+                comment TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS moderation_decisions (
+                id SERIAL PRIMARY KEY,
+                project_id INT REFERENCES projects(id) ON DELETE CASCADE,
+                moderator_id INT REFERENCES users(id),
+                decision TEXT NOT NULL,
+                proposed_by INT REFERENCES users(id),
+                queued_at TIMESTAMP NOT NULL,
+                claimed_at TIMESTAMP,
+                decided_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS rejection_reasons (
+                id SERIAL PRIMARY KEY,
+                code TEXT UNIQUE NOT NULL,
+                title_ru TEXT NOT NULL,
+                title_kk TEXT NOT NULL,
+                text_ru TEXT NOT NULL,
+                text_kk TEXT NOT NULL,
+                position INT NOT NULL DEFAULT 0,
+                active BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS audit_log (
+                id BIGSERIAL PRIMARY KEY,
+                actor_id INT,
+                actor_name TEXT NOT NULL DEFAULT '',
+                action TEXT NOT NULL,
+                target_type TEXT NOT NULL DEFAULT '',
+                target_id TEXT NOT NULL DEFAULT '',
+                before JSONB,
+                after JSONB,
+                ip TEXT NOT NULL DEFAULT '',
+                user_agent TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS comment_reports (
+                id SERIAL PRIMARY KEY,
+                comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                reason TEXT NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                resolved_at TIMESTAMP,
+                UNIQUE(comment_id, user_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS comment_reactions (
+                comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                PRIMARY KEY (comment_id, user_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS official_responses (
+                id SERIAL PRIMARY KEY,
+                project_id INT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+                author_id INT REFERENCES users(id) ON DELETE SET NULL,
+                feasibility TEXT NOT NULL,
+                estimated_cost BIGINT NOT NULL DEFAULT 0,
+                department TEXT NOT NULL,
+                body TEXT NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                updated_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS vote_log (
+                id SERIAL PRIMARY KEY,
+                cycle_id INT NOT NULL DEFAULT 0,
+                seq INT NOT NULL,
+                kind VARCHAR(20) NOT NULL,
+                receipt VARCHAR(32) NOT NULL,
+                entries JSONB NOT NULL DEFAULT '[]',
+                prev_hash CHAR(64) NOT NULL,
+                hash CHAR(64) NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                UNIQUE(cycle_id, seq)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_projects_status ON projects(status);
+        CREATE INDEX IF NOT EXISTS idx_votes_project ON votes(project_id);
+        CREATE INDEX IF NOT EXISTS idx_comments_project ON comments(project_id);
+        CREATE INDEX IF NOT EXISTS idx_status_history_project ON project_status_history(project_id);
+        CREATE INDEX IF NOT EXISTS idx_user_achievements_user ON user_achievements(user_id);
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_identity_iin_active ON identity_verifications(iin_hash) WHERE status IN ('pending', 'approved') AND method <> 'offline';
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_identity_iin_offline ON identity_verifications(iin_hash) WHERE status = 'approved' AND method = 'offline';
+        CREATE INDEX IF NOT EXISTS idx_identity_status ON identity_verifications(status);
+        CREATE INDEX IF NOT EXISTS idx_vote_comment_bands_band ON vote_comment_bands(band);
+        CREATE INDEX IF NOT EXISTS idx_ballot_entries_project ON ballot_entries(project_id);
+        CREATE INDEX IF NOT EXISTS idx_vote_log_receipt ON vote_log(receipt);
+        CREATE INDEX IF NOT EXISTS idx_vote_changes_user ON vote_changes(user_id);
+        CREATE INDEX IF NOT EXISTS idx_milestones_project ON milestones(project_id);
+        CREATE INDEX IF NOT EXISTS idx_spend_entries_project ON spend_entries(project_id);
+        CREATE INDEX IF NOT EXISTS idx_progress_photos_project ON progress_photos(project_id);
+        CREATE INDEX IF NOT EXISTS idx_project_drafts_user ON project_drafts(user_id);
+        CREATE INDEX IF NOT EXISTS idx_project_revisions_project ON project_revisions(project_id);
+        CREATE INDEX IF NOT EXISTS idx_change_requests_project ON change_requests(project_id);
+        CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at);
+        CREATE INDEX IF NOT EXISTS idx_moderation_decisions_decided ON moderation_decisions(decided_at);
+        CREATE INDEX IF NOT EXISTS idx_status_history_status ON project_status_history(status, created_at);
+        CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);
+        CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_at);
+        CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+        CREATE INDEX IF NOT EXISTS idx_comment_reports_open ON comment_reports(comment_id) WHERE resolved_at IS NULL;
+        `
+
+        _, err := db.Pool.Exec(ctx, schema)
+        if err != nil {
+                return err
+        }
+
+        // The audit log is append-only: UPDATE, DELETE and TRUNCATE on it
+        // fail, whoever issues them.
+        _, err = db.Pool.Exec(ctx, `
+        CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+        BEGIN
+                RAISE EXCEPTION 'audit_log is append-only';
+        END;
+        $$ LANGUAGE plpgsql;
+
+        DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+        CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+                FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+        DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+        CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+                FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+        `)
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT DEFAULT 'citizen'")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS vote_start TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS vote_end TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS ai_analysis TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN DEFAULT FALSE")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN DEFAULT FALSE")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS registration_ip TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS registration_ua TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS ip TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS user_agent TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS quarantined BOOLEAN DEFAULT FALSE")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS comment_minhash BIGINT[]")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS duplicate_of INT REFERENCES votes(id) ON DELETE SET NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS duplicate_similarity REAL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS cycle_id INT REFERENCES budget_cycles(id) ON DELETE SET NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE budget_cycles ADD COLUMN IF NOT EXISTS max_picks INT DEFAULT 0")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE budget_cycles ADD COLUMN IF NOT EXISTS credits INT DEFAULT 0")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE budget_cycles ADD COLUMN IF NOT EXISTS root_hash VARCHAR(64)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE budget_cycles ADD COLUMN IF NOT EXISTS root_seq INT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE budget_cycles ADD COLUMN IF NOT EXISTS sealed_at TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS receipt VARCHAR(32)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS receipt VARCHAR(32)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS withdrawn_at TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS withdrawn_at TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes DROP CONSTRAINT IF EXISTS votes_project_id_user_id_key")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_votes_active ON votes(project_id, user_id) WHERE withdrawn_at IS NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots DROP CONSTRAINT IF EXISTS ballots_cycle_id_user_id_key")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_ballots_active ON ballots(cycle_id, user_id) WHERE withdrawn_at IS NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'online'")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS recorded_by INT REFERENCES users(id)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE votes ADD COLUMN IF NOT EXISTS batch_id INT REFERENCES paper_batches(id)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'online'")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS recorded_by INT REFERENCES users(id)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS batch_id INT REFERENCES paper_batches(id)")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE ballots ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE vote_quarantine_log ADD COLUMN IF NOT EXISTS ballot_id INT REFERENCES ballots(id) ON DELETE SET NULL")
+        if err != nil {
+                return err
+        }
+
+        // Quarantine decisions outlive the votes they are about: deleting a
+        // vote or ballot clears the reference instead of the log entry.
+        // Databases created before that still cascade.
+        for _, fk := range []struct{ column, table string }{{"vote_id", "votes"}, {"ballot_id", "ballots"}} {
+                name := "vote_quarantine_log_" + fk.column + "_fkey"
+                var cascades bool
+                err = db.Pool.QueryRow(ctx,
+                        "SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = $1 AND confdeltype = 'c')",
+                        name,
+                ).Scan(&cascades)
+                if err != nil {
+                        return err
+                }
+                if !cascades {
+                        continue
+                }
+                _, err = db.Pool.Exec(ctx, fmt.Sprintf(
+                        "ALTER TABLE vote_quarantine_log DROP CONSTRAINT %[1]s, ADD CONSTRAINT %[1]s FOREIGN KEY (%[2]s) REFERENCES %[3]s(id) ON DELETE SET NULL",
+                        name, fk.column, fk.table,
+                ))
+                if err != nil {
+                        return err
+                }
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE progress_photos ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]'")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE project_revisions ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT ''")
+        if err != nil {
+                return err
+        }
+
+        // moderation_since starts the SLA clock; existing projects count
+        // from their creation.
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS moderation_since TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "UPDATE projects SET moderation_since = created_at WHERE moderation_since IS NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ALTER COLUMN moderation_since SET DEFAULT CURRENT_TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE project_status_history ADD COLUMN IF NOT EXISTS reason_codes TEXT[]")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE rejection_proposals ADD COLUMN IF NOT EXISTS reason_codes TEXT[]")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS screening_reason TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderation_reason TEXT")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_by INT REFERENCES users(id) ON DELETE SET NULL")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMP")
+        if err != nil {
+                return err
+        }
+
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES comments(id) ON DELETE CASCADE")
+        if err != nil {
+                return err
+        }
 
-        _, err := db.Pool.Exec(ctx, schema)
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT DEFAULT 'citizen'")
+        _, err = db.Pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id)")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS vote_start TIMESTAMP")
+        // Expired SMS attempts no longer hold their IIN; the index that
+        // covered every non-rejected row is replaced by idx_identity_iin_active.
+        _, err = db.Pool.Exec(ctx, "DROP INDEX IF EXISTS idx_identity_iin_hash")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS vote_end TIMESTAMP")
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS department TEXT NOT NULL DEFAULT ''")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "ALTER TABLE projects ADD COLUMN IF NOT EXISTS ai_analysis TEXT")
+        // Each department answers a project separately.
+        _, err = db.Pool.Exec(ctx, "ALTER TABLE official_responses DROP CONSTRAINT IF EXISTS official_responses_project_id_key")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname TEXT")
+        _, err = db.Pool.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_official_responses_department ON official_responses(project_id, department)")
         if err != nil {
                 return err
         }
 
-        _, err = db.Pool.Exec(ctx, "UPDATE projects SET status = 'moderation' WHERE status = 'voting' AND id NOT IN (SELECT DISTINCT project_id FROM votes)")
-        
+        if err := db.seedRejectionReasons(ctx); err != nil {
+                return err
+        }
+
         return nil
 }
 
-func (db *Database) CreateUser(email, nickname, passwordHash string) (*models.User, error) {
+func (db *Database) CreateUser(email, nickname, passwordHash, ip, userAgent string) (*models.User, error) {
         ctx := context.Background()
         var user models.User
 
         err := db.Pool.QueryRow(ctx,
-                "INSERT INTO users (email, nickname, password_hash, role) VALUES ($1, $2, $3, 'citizen') RETURNING id, email, nickname, role, created_at",
-                email, nickname, passwordHash,
+                "INSERT INTO users (email, nickname, password_hash, role, registration_ip, registration_ua) VALUES ($1, $2, $3, 'citizen', $4, $5) RETURNING id, email, nickname, role, created_at",
+                email, nickname, passwordHash, ip, userAgent,
         ).Scan(&user.ID, &user.Email, &user.Nickname, &user.Role, &user.CreatedAt)
 
         if err != nil {
@@ -154,13 +764,13 @@ func (db *Database) CreateUser(email, nickname, passwordHash string) (*models.Us
         return &user, nil
 }
 
-func (db *Database) CreateAdmin(email, nickname, passwordHash string) (*models.User, error) {
+func (db *Database) CreateUserWithRole(email, nickname, passwordHash, role string) (*models.User, error) {
         ctx := context.Background()
         var user models.User
 
         err := db.Pool.QueryRow(ctx,
-                "INSERT INTO users (email, nickname, password_hash, role) VALUES ($1, $2, $3, 'admin') RETURNING id, email, nickname, role, created_at",
-                email, nickname, passwordHash,
+                "INSERT INTO users (email, nickname, password_hash, role) VALUES ($1, $2, $3, $4) RETURNING id, email, nickname, role, created_at",
+                email, nickname, passwordHash, role,
         ).Scan(&user.ID, &user.Email, &user.Nickname, &user.Role, &user.CreatedAt)
 
         if err != nil {
@@ -174,11 +784,12 @@ func (db *Database) GetUserByEmail(email string) (*models.User, error) {
         ctx := context.Background()
         var user models.User
         var nickname *string
+        var disabled, verified *bool
 
         err := db.Pool.QueryRow(ctx,
-                "SELECT id, email, nickname, password_hash, role, created_at FROM users WHERE email = $1",
+                "SELECT id, email, nickname, password_hash, role, department, disabled, verified, created_at FROM users WHERE email = $1",
                 email,
-        ).Scan(&user.ID, &user.Email, &nickname, &user.PasswordHash, &user.Role, &user.CreatedAt)
+        ).Scan(&user.ID, &user.Email, &nickname, &user.PasswordHash, &user.Role, &user.Department, &disabled, &verified, &user.CreatedAt)
 
         if err != nil {
                 return nil, err
@@ -188,6 +799,14 @@ func (db *Database) GetUserByEmail(email string) (*models.User, error) {
                 user.Nickname = *nickname
         }
 
+        if disabled != nil {
+                user.Disabled = *disabled
+        }
+
+        if verified != nil {
+                user.Verified = *verified
+        }
+
         return &user, nil
 }
 
@@ -197,9 +816,9 @@ func (db *Database) GetUserByID(id int) (*models.User, error) {
         var nickname *string
 
         err := db.Pool.QueryRow(ctx,
-                "SELECT id, email, nickname, role, created_at FROM users WHERE id = $1",
+                "SELECT id, email, nickname, role, department, COALESCE(verified, FALSE), COALESCE(disabled, FALSE), created_at FROM users WHERE id = $1",
                 id,
-        ).Scan(&user.ID, &user.Email, &nickname, &user.Role, &user.CreatedAt)
+        ).Scan(&user.ID, &user.Email, &nickname, &user.Role, &user.Department, &user.Verified, &user.Disabled, &user.CreatedAt)
 
         if err != nil {
                 return nil, err
@@ -212,7 +831,70 @@ func (db *Database) GetUserByID(id int) (*models.User, error) {
         return &user, nil
 }
 
-func (db *Database) CreateProject(p *models.Project) error {
+func (db *Database) ListUsers() ([]models.User, error) {
+        ctx := context.Background()
+        rows, err := db.Pool.Query(ctx,
+                "SELECT id, email, nickname, role, department, COALESCE(disabled, FALSE), created_at FROM users ORDER BY id",
+        )
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+
+        var users []models.User
+        for rows.Next() {
+                var u models.User
+                var nickname *string
+                if err := rows.Scan(&u.ID, &u.Email, &nickname, &u.Role, &u.Department, &u.Disabled, &u.CreatedAt); err != nil {
+                        return nil, err
+                }
+                if nickname != nil {
+                        u.Nickname = *nickname
+                }
+                users = append(users, u)
+        }
+
+        return users, nil
+}
+
+func (db *Database) SetUserRole(email, role string) error {
+        return db.updateUserByEmail("UPDATE users SET role = $1 WHERE email = $2", role, email)
+}
+
+// SetUserDepartment names the city department a user with the department
+// role answers for; official responses are signed with it.
+func (db *Database) SetUserDepartment(email, department string) error {
+        return db.updateUserByEmail("UPDATE users SET department = $1 WHERE email = $2", department, email)
+}
+
+func (db *Database) SetUserPassword(email, passwordHash string) error {
+        return db.updateUserByEmail("UPDATE users SET password_hash = $1 WHERE email = $2", passwordHash, email)
+}
+
+func (db *Database) SetUserDisabled(email string, disabled bool) error {
+        return db.updateUserByEmail("UPDATE users SET disabled = $1 WHERE email = $2", disabled, email)
+}
+
+func (db *Database) updateUserByEmail(query string, value interface{}, email string) error {
+        ctx := context.Background()
+
+        tag, err := db.Pool.Exec(ctx, query, value, email)
+        if err != nil {
+                return err
+        }
+
+        if tag.RowsAffected() == 0 {
+                return pgx.ErrNoRows
+        }
+
+        return nil
+}
+
+// CreateProjectWithImages inserts the project and calls store with its
+// new id to write the photos; the returned images are saved in the same
+// transaction. If store or the commit fails no project is created, and
+// files already written are left for the upload garbage collector.
+func (db *Database) CreateProjectWithImages(p *models.Project, store func(projectID int) ([]models.Image, error)) error {
         ctx := context.Background()
 
         imagesJSON, err := json.Marshal(p.Images)
@@ -220,21 +902,146 @@ func (db *Database) CreateProject(p *models.Project) error {
                 return err
         }
 
-        return db.Pool.QueryRow(ctx,
+        tx, err := db.Pool.Begin(ctx)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback(ctx)
+
+        err = tx.QueryRow(ctx,
                 `INSERT INTO projects (title, description, category, district, budget, lat, lng, images, status, ai_analysis, user_id)
                  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at`,
                 p.Title, p.Description, p.Category, p.District, p.Budget, p.Lat, p.Lng, imagesJSON, p.Status, p.AIAnalysis, p.UserID,
         ).Scan(&p.ID, &p.CreatedAt)
+        if err != nil {
+                return err
+        }
+
+        images, err := store(p.ID)
+        if err != nil {
+                return err
+        }
+        p.Images = append([]models.Image{}, images...)
+
+        imagesJSON, err = json.Marshal(p.Images)
+        if err != nil {
+                return err
+        }
+        _, err = tx.Exec(ctx, "UPDATE projects SET images = $1 WHERE id = $2", imagesJSON, p.ID)
+        if err != nil {
+                return err
+        }
+
+        return tx.Commit(ctx)
+}
+
+var ErrImagesChanged = errors.New("project images changed concurrently")
+
+// UpdateProjectImages replaces the project's photo list, but only if it
+// still equals old; otherwise two tabs editing photos at once could undo
+// each other or exceed the photo limit.
+func (db *Database) UpdateProjectImages(projectID int, old, images []models.Image) error {
+        ctx := context.Background()
+
+        if old == nil {
+                old = []models.Image{}
+        }
+        if images == nil {
+                images = []models.Image{}
+        }
+        oldJSON, err := json.Marshal(old)
+        if err != nil {
+                return err
+        }
+        imagesJSON, err := json.Marshal(images)
+        if err != nil {
+                return err
+        }
+
+        tag, err := db.Pool.Exec(ctx,
+                "UPDATE projects SET images = $1 WHERE id = $2 AND COALESCE(images, '[]'::jsonb) = $3::jsonb",
+                imagesJSON, projectID, oldJSON,
+        )
+        if err != nil {
+                return err
+        }
+        if tag.RowsAffected() == 0 {
+                return ErrImagesChanged
+        }
+        return nil
+}
+
+// UpdateImageVariants records the variants of an image rebuilt by
+// petroctl wherever its path is used: in project photo lists, where older
+// entries are bare paths, and in progress photos.
+func (db *Database) UpdateImageVariants(img models.Image) error {
+        ctx := context.Background()
+
+        imageJSON, err := json.Marshal(img)
+        if err != nil {
+                return err
+        }
+        variantsJSON, err := json.Marshal(img.Variants)
+        if err != nil {
+                return err
+        }
+
+        tx, err := db.Pool.Begin(ctx)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback(ctx)
+
+        _, err = tx.Exec(ctx,
+                `UPDATE projects SET images = (
+                        SELECT jsonb_agg(CASE WHEN e = to_jsonb($1::text) OR e->>'path' = $1 THEN $2::jsonb ELSE e END ORDER BY n)
+                        FROM jsonb_array_elements(images) WITH ORDINALITY AS t(e, n)
+                 )
+                 WHERE images @> jsonb_build_array($1::text) OR images @> jsonb_build_array(jsonb_build_object('path', $1::text))`,
+                img.Path, imageJSON,
+        )
+        if err != nil {
+                return err
+        }
+
+        _, err = tx.Exec(ctx, "UPDATE progress_photos SET variants = $2 WHERE path = $1", img.Path, variantsJSON)
+        if err != nil {
+                return err
+        }
+
+        return tx.Commit(ctx)
+}
+
+// GetProjectIDs returns the ids of all projects, for the upload garbage
+// collector.
+func (db *Database) GetProjectIDs() (map[int]bool, error) {
+        ctx := context.Background()
+        rows, err := db.Pool.Query(ctx, "SELECT id FROM projects")
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+
+        ids := map[int]bool{}
+        for rows.Next() {
+                var id int
+                if err := rows.Scan(&id); err != nil {
+                        return nil, err
+                }
+                ids[id] = true
+        }
+
+        return ids, rows.Err()
 }
 
 func (db *Database) GetAllProjects() ([]models.Project, error) {
         ctx := context.Background()
         rows, err := db.Pool.Query(ctx,
                 `SELECT p.id, p.title, p.description, p.category, p.district, p.budget, 
-                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.user_id, p.created_at,
-                        COUNT(v.id) as vote_count
+                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.user_id, p.cycle_id, p.created_at,
+                        COUNT(v.id) + (SELECT COUNT(*) FROM ballot_entries be JOIN ballots b ON be.ballot_id = b.id WHERE be.project_id = p.id AND b.withdrawn_at IS NULL AND NOT b.quarantined) as vote_count
                  FROM projects p
-                 LEFT JOIN votes v ON p.id = v.project_id
+                 LEFT JOIN votes v ON p.id = v.project_id AND NOT COALESCE(v.quarantined, FALSE) AND v.withdrawn_at IS NULL
                  GROUP BY p.id
                  ORDER BY p.created_at DESC`,
         )
@@ -250,13 +1057,13 @@ func (db *Database) GetAllProjects() ([]models.Project, error) {
                 var aiAnalysis *string
 
                 err := rows.Scan(&p.ID, &p.Title, &p.Description, &p.Category, &p.District,
-                        &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.UserID, &p.CreatedAt, &p.VoteCount)
+                        &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.UserID, &p.CycleID, &p.CreatedAt, &p.VoteCount)
                 if err != nil {
                         return nil, err
                 }
 
                 if err := json.Unmarshal(imagesJSON, &p.Images); err != nil {
-                        p.Images = []string{}
+                        p.Images = []models.Image{}
                 }
 
                 if aiAnalysis != nil {
@@ -277,22 +1084,22 @@ func (db *Database) GetProjectByID(id int) (*models.Project, error) {
 
         err := db.Pool.QueryRow(ctx,
                 `SELECT p.id, p.title, p.description, p.category, p.district, p.budget,
-                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.user_id, p.created_at,
-                        COUNT(v.id) as vote_count
+                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.vote_start, p.vote_end, p.user_id, p.cycle_id, p.created_at,
+                        COUNT(v.id) + (SELECT COUNT(*) FROM ballot_entries be JOIN ballots b ON be.ballot_id = b.id WHERE be.project_id = p.id AND b.withdrawn_at IS NULL AND NOT b.quarantined) as vote_count
                  FROM projects p
-                 LEFT JOIN votes v ON p.id = v.project_id
+                 LEFT JOIN votes v ON p.id = v.project_id AND NOT COALESCE(v.quarantined, FALSE) AND v.withdrawn_at IS NULL
                  WHERE p.id = $1
                  GROUP BY p.id`,
                 id,
         ).Scan(&p.ID, &p.Title, &p.Description, &p.Category, &p.District,
-                &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.UserID, &p.CreatedAt, &p.VoteCount)
+                &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.VoteStart, &p.VoteEnd, &p.UserID, &p.CycleID, &p.CreatedAt, &p.VoteCount)
 
         if err != nil {
                 return nil, err
         }
 
         if err := json.Unmarshal(imagesJSON, &p.Images); err != nil {
-                p.Images = []string{}
+                p.Images = []models.Image{}
         }
 
         if aiAnalysis != nil {
@@ -302,15 +1109,69 @@ func (db *Database) GetProjectByID(id int) (*models.Project, error) {
         return &p, nil
 }
 
-func (db *Database) CreateVote(projectID, userID int, comment string) error {
+func (db *Database) CreateVote(v *models.Vote) error {
         ctx := context.Background()
 
-        _, err := db.Pool.Exec(ctx,
-                "INSERT INTO votes (project_id, user_id, comment) VALUES ($1, $2, $3)",
-                projectID, userID, comment,
-        )
+        tx, err := db.Pool.Begin(ctx)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback(ctx)
 
-        return err
+        if err := insertVote(ctx, tx, v); err != nil {
+                return err
+        }
+
+        return tx.Commit(ctx)
+}
+
+// insertVote stores the vote with its fingerprint and appends it to the
+// vote log of the project's cycle. A near-copy of another user's comment
+// is refused or flagged first, see checkDuplicateComment.
+func insertVote(ctx context.Context, tx pgx.Tx, v *models.Vote) error {
+        sig := minhash.Signature(v.Fingerprint)
+        if len(sig) == 0 {
+                sig = minhash.Compute(v.Comment)
+        }
+
+        if err := checkDuplicateComment(ctx, tx, v, sig); err != nil {
+                return err
+        }
+
+        if v.Channel == "" {
+                v.Channel = models.ChannelOnline
+        }
+
+        receipt, err := votelog.NewReceipt()
+        if err != nil {
+                return err
+        }
+
+        err = tx.QueryRow(ctx,
+                `INSERT INTO votes (project_id, user_id, comment, ip, user_agent, comment_minhash, duplicate_of, duplicate_similarity, receipt, channel, recorded_by, batch_id)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at`,
+                v.ProjectID, v.UserID, v.Comment, v.IP, v.UserAgent, sig.ToInt64s(), v.DuplicateOf, v.DuplicateSimilarity, receipt,
+                v.Channel, v.RecordedBy, v.BatchID,
+        ).Scan(&v.ID, &v.CreatedAt)
+        if err != nil {
+                return err
+        }
+
+        if err := insertVoteBands(ctx, tx, v.ID, sig); err != nil {
+                return err
+        }
+
+        cycleID, err := projectCycleID(ctx, tx, v.ProjectID)
+        if err != nil {
+                return err
+        }
+        entries := []models.BallotEntry{{ProjectID: v.ProjectID, Value: 1}}
+        if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindCast, receipt, entries); err != nil {
+                return err
+        }
+        v.Receipt = receipt
+
+        return nil
 }
 
 func (db *Database) HasUserVoted(projectID, userID int) (bool, error) {
@@ -318,7 +1179,7 @@ func (db *Database) HasUserVoted(projectID, userID int) (bool, error) {
         var count int
 
         err := db.Pool.QueryRow(ctx,
-                "SELECT COUNT(*) FROM votes WHERE project_id = $1 AND user_id = $2",
+                "SELECT COUNT(*) FROM votes WHERE project_id = $1 AND user_id = $2 AND withdrawn_at IS NULL",
                 projectID, userID,
         ).Scan(&count)
 
@@ -328,7 +1189,7 @@ func (db *Database) HasUserVoted(projectID, userID int) (bool, error) {
 func (db *Database) GetProjectVotes(projectID int) ([]models.Vote, error) {
         ctx := context.Background()
         rows, err := db.Pool.Query(ctx,
-                "SELECT id, project_id, user_id, comment, created_at FROM votes WHERE project_id = $1 ORDER BY created_at DESC",
+                "SELECT id, project_id, user_id, comment, created_at FROM votes WHERE project_id = $1 AND NOT COALESCE(quarantined, FALSE) AND withdrawn_at IS NULL ORDER BY created_at DESC",
                 projectID,
         )
         if err != nil {
@@ -348,26 +1209,67 @@ func (db *Database) GetProjectVotes(projectID int) ([]models.Vote, error) {
         return votes, nil
 }
 
-func (db *Database) CreateComment(projectID, userID int, content string) error {
+// CreateComment saves a comment as published, or as held for a moderator
+// when screeningReason says why it was stopped. A parentID other than 0
+// makes it a reply to a published comment on the same project; replies
+// nest at most maxCommentDepth deep, deeper ones join their parent's
+// thread.
+func (db *Database) CreateComment(projectID, userID, parentID int, content, screeningReason string) error {
         ctx := context.Background()
 
+        var parent *int
+        depth := 0
+        if parentID != 0 {
+                var parentProject, parentDepth int
+                var grandparent *int
+                var status string
+                err := db.Pool.QueryRow(ctx,
+                        "SELECT project_id, depth, parent_id, status FROM comments WHERE id = $1",
+                        parentID,
+                ).Scan(&parentProject, &parentDepth, &grandparent, &status)
+                if errors.Is(err, pgx.ErrNoRows) || (err == nil && parentProject != projectID) {
+                        return ErrCommentNotFound
+                }
+                if err != nil {
+                        return err
+                }
+                if status != "published" {
+                        return ErrCommentNotPublished
+                }
+                parent, depth = &parentID, parentDepth+1
+                if parentDepth >= maxCommentDepth {
+                        parent, depth = grandparent, parentDepth
+                }
+        }
+
+        status := "published"
+        if screeningReason != "" {
+                status = "held"
+        }
         _, err := db.Pool.Exec(ctx,
-                "INSERT INTO comments (project_id, user_id, content) VALUES ($1, $2, $3)",
-                projectID, userID, content,
+                `INSERT INTO comments (project_id, user_id, parent_id, depth, content, status, screening_reason)
+                 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+                projectID, userID, parent, depth, content, status, screeningReason,
         )
 
         return err
 }
 
-func (db *Database) GetProjectComments(projectID int) ([]models.Comment, error) {
+// GetProjectComments returns every comment on the project, newest first,
+// with its useful count and whether viewerID (0 for guests) marked it.
+func (db *Database) GetProjectComments(projectID, viewerID int) ([]models.Comment, error) {
         ctx := context.Background()
         rows, err := db.Pool.Query(ctx,
-                `SELECT c.id, c.project_id, c.user_id, u.email, c.content, c.created_at 
+                `SELECT c.id, c.project_id, c.user_id, u.email, COALESCE(u.role, 'citizen'), c.parent_id, c.depth,
+                        c.content, c.status, COALESCE(c.screening_reason, ''), COALESCE(c.moderation_reason, ''),
+                        (SELECT COUNT(*) FROM comment_reactions r WHERE r.comment_id = c.id),
+                        EXISTS (SELECT 1 FROM comment_reactions r WHERE r.comment_id = c.id AND r.user_id = $2),
+                        c.created_at 
                  FROM comments c 
                  JOIN users u ON c.user_id = u.id 
                  WHERE c.project_id = $1 
                  ORDER BY c.created_at DESC`,
-                projectID,
+                projectID, viewerID,
         )
         if err != nil {
                 return nil, err
@@ -377,7 +1279,9 @@ func (db *Database) GetProjectComments(projectID int) ([]models.Comment, error)
         var comments []models.Comment
         for rows.Next() {
                 var c models.Comment
-                if err := rows.Scan(&c.ID, &c.ProjectID, &c.UserID, &c.UserEmail, &c.Content, &c.CreatedAt); err != nil {
+                if err := rows.Scan(&c.ID, &c.ProjectID, &c.UserID, &c.UserEmail, &c.UserRole, &c.ParentID, &c.Depth,
+                        &c.Content, &c.Status, &c.ScreeningReason, &c.ModerationReason,
+                        &c.Useful, &c.Reacted, &c.CreatedAt); err != nil {
                         return nil, err
                 }
                 comments = append(comments, c)
@@ -386,7 +1290,13 @@ func (db *Database) GetProjectComments(projectID int) ([]models.Comment, error)
         return comments, nil
 }
 
-func (db *Database) UpdateProjectStatus(projectID int, status string, adminID int, comment string) error {
+// ErrStatusChanged is returned when a project is no longer in the status
+// a change was made from.
+var ErrStatusChanged = errors.New("project status changed concurrently")
+
+// UpdateProjectStatus moves a project from status from to status and
+// records it in the history.
+func (db *Database) UpdateProjectStatus(projectID int, from, status string, adminID int, comment string) error {
         ctx := context.Background()
 
         tx, err := db.Pool.Begin(ctx)
@@ -395,7 +1305,16 @@ func (db *Database) UpdateProjectStatus(projectID int, status string, adminID in
         }
         defer tx.Rollback(ctx)
 
-        _, err = tx.Exec(ctx, "UPDATE projects SET status = $1 WHERE id = $2", status, projectID)
+        tag, err := tx.Exec(ctx, "UPDATE projects SET status = $1 WHERE id = $2 AND status = $3", status, projectID, from)
+        if err != nil {
+                return err
+        }
+        if tag.RowsAffected() == 0 {
+                return ErrStatusChanged
+        }
+
+        // A rejection proposed while the project was in voting is moot now.
+        _, err = tx.Exec(ctx, "DELETE FROM rejection_proposals WHERE project_id = $1", projectID)
         if err != nil {
                 return err
         }
@@ -411,6 +1330,46 @@ func (db *Database) UpdateProjectStatus(projectID int, status string, adminID in
         return tx.Commit(ctx)
 }
 
+// SetProjectCycle puts the project into the cycle. Both the cycle it leaves
+// and the one it joins must exist and not have started voting, otherwise
+// ErrVotingStarted is returned; a missing cycle gives pgx.ErrNoRows.
+func (db *Database) SetProjectCycle(projectID, cycleID int) error {
+        ctx := context.Background()
+
+        tx, err := db.Pool.Begin(ctx)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback(ctx)
+
+        var current int
+        err = tx.QueryRow(ctx, "SELECT COALESCE(cycle_id, 0) FROM projects WHERE id = $1 FOR UPDATE", projectID).Scan(&current)
+        if err != nil {
+                return err
+        }
+        if current == cycleID {
+                return nil
+        }
+        for _, id := range []int{current, cycleID} {
+                if id == 0 {
+                        continue
+                }
+                if err := lockCycleOpen(ctx, tx, id); err != nil {
+                        return err
+                }
+        }
+
+        _, err = tx.Exec(ctx,
+                "UPDATE projects SET cycle_id = $1 WHERE id = $2",
+                cycleID, projectID,
+        )
+        if err != nil {
+                return err
+        }
+
+        return tx.Commit(ctx)
+}
+
 func (db *Database) SetVotingPeriod(projectID int, voteStart, voteEnd string) error {
         ctx := context.Background()
 
@@ -425,7 +1384,7 @@ func (db *Database) SetVotingPeriod(projectID int, voteStart, voteEnd string) er
 func (db *Database) GetProjectStatusHistory(projectID int) ([]models.ProjectStatusHistory, error) {
         ctx := context.Background()
         rows, err := db.Pool.Query(ctx,
-                `SELECT id, project_id, status, comment, admin_id, created_at 
+                `SELECT id, project_id, status, COALESCE(comment, ''), COALESCE(admin_id, 0), reason_codes, created_at 
                  FROM project_status_history 
                  WHERE project_id = $1 
                  ORDER BY created_at DESC`,
@@ -437,13 +1396,34 @@ func (db *Database) GetProjectStatusHistory(projectID int) ([]models.ProjectStat
         defer rows.Close()
 
         var history []models.ProjectStatusHistory
+        cited := false
         for rows.Next() {
                 var h models.ProjectStatusHistory
-                if err := rows.Scan(&h.ID, &h.ProjectID, &h.Status, &h.Comment, &h.AdminID, &h.CreatedAt); err != nil {
+                if err := rows.Scan(&h.ID, &h.ProjectID, &h.Status, &h.Comment, &h.AdminID, &h.ReasonCodes, &h.CreatedAt); err != nil {
                         return nil, err
                 }
+                cited = cited || len(h.ReasonCodes) > 0
                 history = append(history, h)
         }
+        if err := rows.Err(); err != nil {
+                return nil, err
+        }
+
+        if cited {
+                reasons, err := db.GetRejectionReasons(true)
+                if err != nil {
+                        return nil, err
+                }
+                for i := range history {
+                        for _, code := range history[i].ReasonCodes {
+                                for _, r := range reasons {
+                                        if r.Code == code {
+                                                history[i].Reasons = append(history[i].Reasons, r)
+                                        }
+                                }
+                        }
+                }
+        }
 
         return history, nil
 }
@@ -452,10 +1432,10 @@ func (db *Database) GetProjectsByStatus(status string) ([]models.Project, error)
         ctx := context.Background()
         rows, err := db.Pool.Query(ctx,
                 `SELECT p.id, p.title, p.description, p.category, p.district, p.budget, 
-                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.user_id, p.created_at,
-                        COUNT(v.id) as vote_count
+                        p.lat, p.lng, p.images, p.status, p.ai_analysis, p.user_id, p.cycle_id, p.created_at,
+                        COUNT(v.id) + (SELECT COUNT(*) FROM ballot_entries be JOIN ballots b ON be.ballot_id = b.id WHERE be.project_id = p.id AND b.withdrawn_at IS NULL AND NOT b.quarantined) as vote_count
                  FROM projects p
-                 LEFT JOIN votes v ON p.id = v.project_id
+                 LEFT JOIN votes v ON p.id = v.project_id AND NOT COALESCE(v.quarantined, FALSE) AND v.withdrawn_at IS NULL
                  WHERE p.status = $1
                  GROUP BY p.id
                  ORDER BY p.created_at DESC`,
@@ -473,13 +1453,13 @@ func (db *Database) GetProjectsByStatus(status string) ([]models.Project, error)
                 var aiAnalysis *string
 
                 err := rows.Scan(&p.ID, &p.Title, &p.Description, &p.Category, &p.District,
-                        &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.UserID, &p.CreatedAt, &p.VoteCount)
+                        &p.Budget, &p.Lat, &p.Lng, &imagesJSON, &p.Status, &aiAnalysis, &p.UserID, &p.CycleID, &p.CreatedAt, &p.VoteCount)
                 if err != nil {
                         return nil, err
                 }
 
                 if err := json.Unmarshal(imagesJSON, &p.Images); err != nil {
-                        p.Images = []string{}
+                        p.Images = []models.Image{}
                 }
 
                 if aiAnalysis != nil {
@@ -492,23 +1472,13 @@ func (db *Database) GetProjectsByStatus(status string) ([]models.Project, error)
         return projects, nil
 }
 
-func (db *Database) UpdateProject(projectID int, title, description, category, district string, budget int) error {
-        ctx := context.Background()
-
-        _, err := db.Pool.Exec(ctx,
-                "UPDATE projects SET title = $1, description = $2, category = $3, district = $4, budget = $5 WHERE id = $6",
-                title, description, category, district, budget, projectID,
-        )
-
-        return err
-}
-
 func (db *Database) GetUserStats(userID int) (*models.UserStats, error) {
         ctx := context.Background()
         stats := &models.UserStats{}
 
         err := db.Pool.QueryRow(ctx,
-                "SELECT COUNT(*) FROM votes WHERE user_id = $1",
+                `SELECT (SELECT COUNT(*) FROM votes WHERE user_id = $1 AND NOT COALESCE(quarantined, FALSE) AND withdrawn_at IS NULL)
+                        + (SELECT COUNT(*) FROM ballot_entries e JOIN ballots b ON e.ballot_id = b.id WHERE b.user_id = $1 AND b.withdrawn_at IS NULL AND NOT b.quarantined)`,
                 userID,
         ).Scan(&stats.VotesCount)
         if err != nil {
@@ -540,7 +1510,7 @@ func (db *Database) GetUserStats(userID int) (*models.UserStats, error) {
         }
 
         err = db.Pool.QueryRow(ctx,
-                "SELECT COUNT(*) FROM comments WHERE user_id = $1",
+                "SELECT COUNT(*) FROM comments WHERE user_id = $1 AND status = 'published'",
                 userID,
         ).Scan(&stats.CommentsCount)
         if err != nil {
@@ -642,6 +1612,41 @@ func (db *Database) CheckAndUnlockAchievements(userID int) error {
         return nil
 }
 
+// voteAchievements are earned by the number of votes counted for the user
+// and are the only ones a withdrawal can take away.
+var voteAchievements = []string{"voter", "active_citizen", "opinion_leader"}
+
+// RecomputeAchievements brings the user's achievements in line with their
+// current stats after a vote is withdrawn or moved: missing ones are
+// unlocked and vote achievements no longer earned are revoked.
+func (db *Database) RecomputeAchievements(userID int) error {
+        if err := db.CheckAndUnlockAchievements(userID); err != nil {
+                return err
+        }
+
+        stats, err := db.GetUserStats(userID)
+        if err != nil {
+                return err
+        }
+
+        ctx := context.Background()
+        for _, id := range voteAchievements {
+                a, ok := achievements.GetAchievement(id)
+                if !ok || stats.VotesCount >= a.Requirement {
+                        continue
+                }
+                _, err := db.Pool.Exec(ctx,
+                        "DELETE FROM user_achievements WHERE user_id = $1 AND achievement_id = $2",
+                        userID, id,
+                )
+                if err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
+
 func (db *Database) Close() {
         db.Pool.Close()
 }
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const draftColumns = `id, user_id, title, description, category, district, budget, lat, lng, updated_at`
+
+func scanDraft(row pgx.Row) (*models.ProjectDraft, error) {
+	var d models.ProjectDraft
+	err := row.Scan(&d.ID, &d.UserID, &d.Title, &d.Description, &d.Category, &d.District, &d.Budget,
+		&d.Lat, &d.Lng, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SaveDraft creates the draft when d.ID is zero and otherwise overwrites
+// it. It returns pgx.ErrNoRows if the draft is gone or not d.UserID's.
+func (db *Database) SaveDraft(d *models.ProjectDraft) error {
+	ctx := context.Background()
+
+	if d.ID == 0 {
+		return db.Pool.QueryRow(ctx,
+			`INSERT INTO project_drafts (user_id, title, description, category, district, budget, lat, lng)
+                         VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, updated_at`,
+			d.UserID, d.Title, d.Description, d.Category, d.District, d.Budget, d.Lat, d.Lng,
+		).Scan(&d.ID, &d.UpdatedAt)
+	}
+
+	return db.Pool.QueryRow(ctx,
+		`UPDATE project_drafts SET title = $1, description = $2, category = $3, district = $4, budget = $5,
+                        lat = $6, lng = $7, updated_at = CURRENT_TIMESTAMP
+                 WHERE id = $8 AND user_id = $9 RETURNING updated_at`,
+		d.Title, d.Description, d.Category, d.District, d.Budget, d.Lat, d.Lng, d.ID, d.UserID,
+	).Scan(&d.UpdatedAt)
+}
+
+func (db *Database) GetDraft(id, userID int) (*models.ProjectDraft, error) {
+	ctx := context.Background()
+	return scanDraft(db.Pool.QueryRow(ctx,
+		"SELECT "+draftColumns+" FROM project_drafts WHERE id = $1 AND user_id = $2",
+		id, userID,
+	))
+}
+
+// GetUserDrafts returns the user's drafts, most recently edited first.
+func (db *Database) GetUserDrafts(userID int) ([]models.ProjectDraft, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+draftColumns+" FROM project_drafts WHERE user_id = $1 ORDER BY updated_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drafts []models.ProjectDraft
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, *d)
+	}
+	return drafts, rows.Err()
+}
+
+func (db *Database) CountUserDrafts(userID int) (int, error) {
+	ctx := context.Background()
+	var n int
+	err := db.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM project_drafts WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+func (db *Database) DeleteDraft(id, userID int) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx, "DELETE FROM project_drafts WHERE id = $1 AND user_id = $2", id, userID)
+	return err
+}
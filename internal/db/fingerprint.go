@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/minhash"
+	"petropavlovsk-budget/internal/models"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrDuplicateComment is returned when the vote's comment is a near-copy of
+// another user's comment on the same project.
+var ErrDuplicateComment = errors.New("comment nearly copies another vote on the project")
+
+// checkDuplicateComment compares the comment of v with other users' votes
+// inside the transaction that stores it. A near-copy on the same project
+// is refused with ErrDuplicateComment; the closest one on another project
+// is recorded in v.DuplicateOf for the fraud report.
+func checkDuplicateComment(ctx context.Context, tx pgx.Tx, v *models.Vote, sig minhash.Signature) error {
+	if sig.Empty() {
+		return nil
+	}
+
+	// Serialises commented votes, so two copies sent at once cannot both
+	// miss each other.
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('vote-comments'))"); err != nil {
+		return err
+	}
+
+	similar, err := findSimilarVoteComments(ctx, tx, sig, v.UserID, minhash.DuplicateThreshold)
+	if err != nil {
+		return err
+	}
+	for _, s := range similar {
+		if s.ProjectID == v.ProjectID {
+			return ErrDuplicateComment
+		}
+	}
+	if len(similar) > 0 {
+		v.DuplicateOf = &similar[0].VoteID
+		v.DuplicateSimilarity = similar[0].Similarity
+	}
+	return nil
+}
+
+func insertVoteBands(ctx context.Context, tx pgx.Tx, voteID int, sig minhash.Signature) error {
+	for _, key := range minhash.BandKeys(sig) {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO vote_comment_bands (vote_id, band) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			voteID, key,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSimilarVoteComments returns votes by other users whose comment
+// signature is at least minSimilarity close to sig, most similar first.
+// Candidates come from the LSH band index; similarity is then estimated
+// from the full signatures.
+func findSimilarVoteComments(ctx context.Context, tx pgx.Tx, sig minhash.Signature, excludeUserID int, minSimilarity float64) ([]models.SimilarVote, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT DISTINCT v.id, v.project_id, v.user_id, v.comment_minhash
+                 FROM vote_comment_bands b
+                 JOIN votes v ON v.id = b.vote_id
+                 WHERE b.band = ANY($1) AND v.user_id <> $2 AND v.comment_minhash IS NOT NULL`,
+		minhash.BandKeys(sig), excludeUserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []models.SimilarVote
+	for rows.Next() {
+		var s models.SimilarVote
+		var stored []int64
+		if err := rows.Scan(&s.VoteID, &s.ProjectID, &s.UserID, &stored); err != nil {
+			return nil, err
+		}
+		s.Similarity = minhash.Similarity(sig, minhash.FromInt64s(stored))
+		if s.Similarity >= minSimilarity {
+			similar = append(similar, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(similar, func(i, j int) bool { return similar[i].Similarity > similar[j].Similarity })
+
+	return similar, nil
+}
+
+// BackfillVoteFingerprints computes signatures for votes stored before
+// fingerprinting existed. It is cheap to call on every start.
+func (db *Database) BackfillVoteFingerprints() error {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, "SELECT id, comment FROM votes WHERE comment_minhash IS NULL")
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		id      int
+		comment string
+	}
+	var votes []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.comment); err != nil {
+			rows.Close()
+			return err
+		}
+		votes = append(votes, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range votes {
+		sig := minhash.Compute(p.comment)
+
+		tx, err := db.Pool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE votes SET comment_minhash = $1 WHERE id = $2", sig.ToInt64s(), p.id); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if err := insertVoteBands(ctx, tx, p.id, sig); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/fraud"
+	"petropavlovsk-budget/internal/minhash"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/votelog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetVoteSignals loads every vote and ballot entry with the voter and
+// project details the fraud analyzer needs. Quarantined votes are included
+// so the report can show them alongside the rest; withdrawn votes are not.
+// Paper and kiosk votes are left out: staff checked the voter's ID card and
+// the shared station IP would only produce false alarms.
+func (db *Database) GetVoteSignals() ([]fraud.VoteRecord, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT v.id, 0, v.project_id, p.title, COALESCE(p.user_id, 0), v.user_id, u.email, u.created_at,
+                        COALESCE(u.registration_ip, ''), COALESCE(u.registration_ua, ''),
+                        COALESCE(v.ip, ''), COALESCE(v.user_agent, ''), v.comment, v.comment_minhash,
+                        COALESCE(v.duplicate_of, 0), v.created_at, COALESCE(v.quarantined, FALSE)
+                 FROM votes v
+                 JOIN users u ON v.user_id = u.id
+                 JOIN projects p ON v.project_id = p.id
+                 WHERE v.withdrawn_at IS NULL AND v.channel = 'online'
+                 UNION ALL
+                 SELECT 0, b.id, e.project_id, p.title, COALESCE(p.user_id, 0), b.user_id, u.email, u.created_at,
+                        COALESCE(u.registration_ip, ''), COALESCE(u.registration_ua, ''),
+                        COALESCE(b.ip, ''), COALESCE(b.user_agent, ''), '', NULL::BIGINT[],
+                        0, b.created_at, b.quarantined
+                 FROM ballots b
+                 JOIN ballot_entries e ON e.ballot_id = b.id
+                 JOIN users u ON b.user_id = u.id
+                 JOIN projects p ON e.project_id = p.id
+                 WHERE b.withdrawn_at IS NULL AND b.channel = 'online'
+                 ORDER BY 16, 1, 2, 3`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []fraud.VoteRecord
+	for rows.Next() {
+		var r fraud.VoteRecord
+		var fingerprint []int64
+		if err := rows.Scan(&r.VoteID, &r.BallotID, &r.ProjectID, &r.ProjectTitle, &r.ProjectAuthorID, &r.UserID, &r.UserEmail, &r.UserCreatedAt,
+			&r.RegistrationIP, &r.RegistrationUA, &r.VoteIP, &r.VoteUA, &r.Comment, &fingerprint,
+			&r.DuplicateOf, &r.CreatedAt, &r.Quarantined); err != nil {
+			return nil, err
+		}
+		r.Fingerprint = minhash.FromInt64s(fingerprint)
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// QuarantineVotes excludes the votes and ballots from all tallies and
+// records who did it and why. Already quarantined ones are skipped.
+func (db *Database) QuarantineVotes(refs []fraud.Ref, scores map[fraud.Ref]int, adminID int, reason string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, ref := range refs {
+		query := `UPDATE votes v SET quarantined = TRUE
+                          FROM projects p
+                          WHERE v.id = $1 AND p.id = v.project_id AND NOT COALESCE(v.quarantined, FALSE) AND v.withdrawn_at IS NULL
+                          RETURNING COALESCE(v.receipt, ''), COALESCE(p.cycle_id, 0)`
+		if ref.Ballot {
+			query = `UPDATE ballots SET quarantined = TRUE
+                                 WHERE id = $1 AND NOT quarantined AND withdrawn_at IS NULL
+                                 RETURNING COALESCE(receipt, ''), cycle_id`
+		}
+
+		var receipt string
+		var cycleID int
+		err := tx.QueryRow(ctx, query, ref.ID).Scan(&receipt, &cycleID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if receipt != "" {
+			if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindVoid, receipt, nil); err != nil {
+				return err
+			}
+		}
+
+		voteID, ballotID := quarantineTarget(ref)
+		_, err = tx.Exec(ctx,
+			"INSERT INTO vote_quarantine_log (vote_id, ballot_id, action, reason, score, admin_id) VALUES ($1, $2, 'quarantine', $3, $4, $5)",
+			voteID, ballotID, reason, scores[ref], adminID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReleaseVote returns a quarantined vote or ballot to the count.
+func (db *Database) ReleaseVote(ref fraud.Ref, adminID int, reason string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE votes v SET quarantined = FALSE
+                  FROM projects p
+                  WHERE v.id = $1 AND p.id = v.project_id AND v.quarantined
+                  RETURNING COALESCE(v.receipt, ''), COALESCE(p.cycle_id, 0), v.withdrawn_at IS NOT NULL`
+	if ref.Ballot {
+		query = `UPDATE ballots SET quarantined = FALSE
+                         WHERE id = $1 AND quarantined
+                         RETURNING COALESCE(receipt, ''), cycle_id, withdrawn_at IS NOT NULL`
+	}
+
+	var receipt string
+	var cycleID int
+	var withdrawn bool
+	err = tx.QueryRow(ctx, query, ref.ID).Scan(&receipt, &cycleID, &withdrawn)
+	if err != nil {
+		return err
+	}
+
+	// A vote withdrawn while in quarantine stays out of the count.
+	if receipt != "" && !withdrawn {
+		if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindRestore, receipt, nil); err != nil {
+			return err
+		}
+	}
+
+	voteID, ballotID := quarantineTarget(ref)
+	_, err = tx.Exec(ctx,
+		"INSERT INTO vote_quarantine_log (vote_id, ballot_id, action, reason, admin_id) VALUES ($1, $2, 'release', $3, $4)",
+		voteID, ballotID, reason, adminID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// quarantineTarget splits a ref into the nullable vote_id and ballot_id
+// columns of vote_quarantine_log.
+func quarantineTarget(ref fraud.Ref) (voteID, ballotID *int) {
+	id := ref.ID
+	if ref.Ballot {
+		return nil, &id
+	}
+	return &id, nil
+}
+
+func (db *Database) GetQuarantineLog(limit int) ([]models.VoteQuarantineEntry, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT l.id, COALESCE(l.vote_id, 0), COALESCE(l.ballot_id, 0), l.action, COALESCE(l.reason, ''), COALESCE(l.score, 0), COALESCE(u.email, ''), l.created_at
+                 FROM vote_quarantine_log l
+                 LEFT JOIN users u ON l.admin_id = u.id
+                 ORDER BY l.created_at DESC
+                 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.VoteQuarantineEntry
+	for rows.Next() {
+		var e models.VoteQuarantineEntry
+		if err := rows.Scan(&e.ID, &e.VoteID, &e.BallotID, &e.Action, &e.Reason, &e.Score, &e.AdminEmail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrIINAlreadyUsed is returned when another account already holds a
+// pending or approved verification for the same IIN.
+var ErrIINAlreadyUsed = errors.New("iin already linked to another account")
+
+// ErrAlreadyVerified is returned when the user's identity has already been
+// approved. The approved IIN stays linked to the account; only an admin
+// can reopen it.
+var ErrAlreadyVerified = errors.New("identity already verified")
+
+// ErrPhoneAlreadyUsed is returned when the phone number backs a pending or
+// approved SMS verification of another account.
+var ErrPhoneAlreadyUsed = errors.New("phone already used by another account")
+
+// activeVerification matches the verifications that hold their IIN and
+// phone: approved ones, document reviews and SMS attempts whose code has
+// not expired. $1 is the current time, in the form otp_expires_at is
+// written with.
+const activeVerification = `(v.status = 'approved' OR (v.status = 'pending' AND (v.method <> 'sms' OR v.otp_expires_at > $1)))`
+
+// expireSMSVerifications marks SMS attempts whose code has expired, so an
+// IIN someone typed without confirming it is not held forever.
+func expireSMSVerifications(ctx context.Context, tx pgx.Tx, now time.Time) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE identity_verifications SET status = 'expired', otp_hash = NULL
+                 WHERE method = 'sms' AND status = 'pending' AND (otp_expires_at IS NULL OR otp_expires_at <= $1)`,
+		now,
+	)
+	return err
+}
+
+const identityColumns = `v.id, v.user_id, u.email, v.iin_hash, v.method, v.status,
+        COALESCE(v.phone, ''), COALESCE(v.document_path, ''), COALESCE(v.otp_hash, ''), v.otp_expires_at,
+        COALESCE(v.otp_attempts, 0), COALESCE(v.review_comment, ''), v.reviewer_id, v.created_at, v.reviewed_at`
+
+func scanIdentityVerification(row pgx.Row) (*models.IdentityVerification, error) {
+	var v models.IdentityVerification
+	err := row.Scan(&v.ID, &v.UserID, &v.UserEmail, &v.IINHash, &v.Method, &v.Status,
+		&v.Phone, &v.DocumentPath, &v.OTPHash, &v.OTPExpiresAt,
+		&v.OTPAttempts, &v.ReviewComment, &v.ReviewerID, &v.CreatedAt, &v.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (db *Database) GetIdentityVerification(userID int) (*models.IdentityVerification, error) {
+	ctx := context.Background()
+	return scanIdentityVerification(db.Pool.QueryRow(ctx,
+		`SELECT `+identityColumns+`
+                 FROM identity_verifications v
+                 JOIN users u ON v.user_id = u.id
+                 WHERE v.user_id = $1`,
+		userID,
+	))
+}
+
+func (db *Database) GetIdentityVerificationByID(id int) (*models.IdentityVerification, error) {
+	ctx := context.Background()
+	return scanIdentityVerification(db.Pool.QueryRow(ctx,
+		`SELECT `+identityColumns+`
+                 FROM identity_verifications v
+                 JOIN users u ON v.user_id = u.id
+                 WHERE v.id = $1`,
+		id,
+	))
+}
+
+// StartIdentityVerification records a new attempt for the user, replacing
+// any earlier rejected or unfinished one. An approved verification is never
+// replaced, so its IIN cannot be freed for another account. A phone number
+// verifies one account: it is refused with ErrPhoneAlreadyUsed while
+// another account's SMS attempt with it is pending or approved.
+func (db *Database) StartIdentityVerification(v *models.IdentityVerification) error {
+	ctx := context.Background()
+	now := time.Now()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := expireSMSVerifications(ctx, tx, now); err != nil {
+		return err
+	}
+
+	if v.Method == "sms" {
+		// Serialises attempts with the same number; the check below then
+		// sees every committed one.
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('identity-phone:' || $1))", v.Phone); err != nil {
+			return err
+		}
+		var used bool
+		err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM identity_verifications v
+                         WHERE v.phone = $2 AND v.method = 'sms' AND v.user_id <> $3 AND `+activeVerification+`)`,
+			now, v.Phone, v.UserID,
+		).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return ErrPhoneAlreadyUsed
+		}
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO identity_verifications (user_id, iin_hash, method, status, phone, document_path, otp_hash, otp_expires_at, otp_attempts)
+                 VALUES ($1, $2, $3, 'pending', $4, $5, $6, $7, 0)
+                 ON CONFLICT (user_id) DO UPDATE SET
+                        iin_hash = EXCLUDED.iin_hash, method = EXCLUDED.method, status = 'pending',
+                        phone = EXCLUDED.phone, document_path = EXCLUDED.document_path,
+                        otp_hash = EXCLUDED.otp_hash, otp_expires_at = EXCLUDED.otp_expires_at, otp_attempts = 0,
+                        review_comment = NULL, reviewer_id = NULL, reviewed_at = NULL, created_at = CURRENT_TIMESTAMP
+                 WHERE identity_verifications.status <> 'approved'
+                 RETURNING id, status, created_at`,
+		v.UserID, v.IINHash, v.Method, v.Phone, v.DocumentPath, v.OTPHash, v.OTPExpiresAt,
+	).Scan(&v.ID, &v.Status, &v.CreatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrAlreadyVerified
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrIINAlreadyUsed
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// IsIINHashTaken reports whether another account holds an active
+// verification of the IIN; expired SMS attempts and rejections do not count,
+// nor does an offline voting placeholder, which the account takes over once
+// verified.
+func (db *Database) IsIINHashTaken(iinHash string, userID int) (bool, error) {
+	ctx := context.Background()
+	var count int
+
+	err := db.Pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM identity_verifications v WHERE v.iin_hash = $2 AND v.user_id <> $3 AND v.method <> 'offline' AND "+activeVerification,
+		time.Now(), iinHash, userID,
+	).Scan(&count)
+
+	return count > 0, err
+}
+
+func (db *Database) IncrementOTPAttempts(userID int) error {
+	ctx := context.Background()
+
+	_, err := db.Pool.Exec(ctx,
+		"UPDATE identity_verifications SET otp_attempts = otp_attempts + 1 WHERE user_id = $1",
+		userID,
+	)
+
+	return err
+}
+
+// ApproveIdentityVerification marks the verification approved and the user
+// verified in one transaction. reviewerID is nil for automatic (SMS) approval.
+func (db *Database) ApproveIdentityVerification(id int, reviewerID *int, comment string) error {
+	return db.finishIdentityVerification(id, "approved", reviewerID, comment)
+}
+
+func (db *Database) RejectIdentityVerification(id int, reviewerID *int, comment string) error {
+	return db.finishIdentityVerification(id, "rejected", reviewerID, comment)
+}
+
+func (db *Database) finishIdentityVerification(id int, status string, reviewerID *int, comment string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	var iinHash string
+	err = tx.QueryRow(ctx,
+		`UPDATE identity_verifications
+                 SET status = $1, reviewer_id = $2, review_comment = $3, reviewed_at = $4, otp_hash = NULL
+                 WHERE id = $5 AND status = 'pending'
+                 RETURNING user_id, iin_hash`,
+		status, reviewerID, comment, time.Now(), id,
+	).Scan(&userID, &iinHash)
+	if err != nil {
+		return err
+	}
+
+	if status == "approved" {
+		if err := takeOverOfflineVoter(ctx, tx, iinHash, userID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE users SET verified = $1 WHERE id = $2", status == "approved", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// takeOverOfflineVoter moves the votes of the IIN's offline placeholder
+// account, if there is one, to the citizen's own account and retires the
+// placeholder, so the citizen keeps one account and one vote.
+func takeOverOfflineVoter(ctx context.Context, tx pgx.Tx, iinHash string, userID int) error {
+	var placeholderID int
+	err := tx.QueryRow(ctx,
+		`UPDATE identity_verifications SET status = 'merged', review_comment = 'Передано учётной записи ' || $2::text
+                 WHERE iin_hash = $1 AND method = 'offline' AND status = 'approved'
+                 RETURNING user_id`,
+		iinHash, userID,
+	).Scan(&placeholderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// An account votes only once verified, so userID has no votes of its
+	// own to clash with the placeholder's.
+	for _, table := range []string{"votes", "ballots", "vote_changes"} {
+		if _, err := tx.Exec(ctx, "UPDATE "+table+" SET user_id = $1 WHERE user_id = $2", userID, placeholderID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE users SET verified = FALSE WHERE id = $1", placeholderID)
+	return err
+}
+
+func (db *Database) GetPendingDocumentVerifications() ([]models.IdentityVerification, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT `+identityColumns+`
+                 FROM identity_verifications v
+                 JOIN users u ON v.user_id = u.id
+                 WHERE v.method = 'document' AND v.status = 'pending'
+                 ORDER BY v.created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var verifications []models.IdentityVerification
+	for rows.Next() {
+		v, err := scanIdentityVerification(rows)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, *v)
+	}
+
+	return verifications, nil
+}
+
+func (db *Database) IsUserVerified(userID int) (bool, error) {
+	ctx := context.Background()
+	var verified bool
+
+	err := db.Pool.QueryRow(ctx,
+		"SELECT COALESCE(verified, FALSE) FROM users WHERE id = $1",
+		userID,
+	).Scan(&verified)
+
+	return verified, err
+}
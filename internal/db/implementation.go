@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"petropavlovsk-budget/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const implementationColumns = `i.project_id, i.implementer_id, COALESCE(u.email, ''), i.contractor, i.contract_number,
+        i.contract_amount, p.budget, i.planned_start, i.planned_end, i.actual_start, i.actual_end,
+        i.percent_complete, i.delay_reason,
+        (SELECT COALESCE(SUM(amount), 0) FROM spend_entries s WHERE s.project_id = i.project_id),
+        i.updated_at`
+
+func scanImplementation(row pgx.Row) (*models.Implementation, error) {
+	var i models.Implementation
+	err := row.Scan(&i.ProjectID, &i.ImplementerID, &i.ImplementerEmail, &i.Contractor, &i.ContractNumber,
+		&i.ContractAmount, &i.ApprovedBudget, &i.PlannedStart, &i.PlannedEnd, &i.ActualStart, &i.ActualEnd,
+		&i.PercentComplete, &i.DelayReason, &i.Spent, &i.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// GetImplementation returns pgx.ErrNoRows until someone has started
+// tracking the project.
+func (db *Database) GetImplementation(projectID int) (*models.Implementation, error) {
+	ctx := context.Background()
+	return scanImplementation(db.Pool.QueryRow(ctx,
+		`SELECT `+implementationColumns+`
+                 FROM implementations i
+                 JOIN projects p ON i.project_id = p.id
+                 LEFT JOIN users u ON i.implementer_id = u.id
+                 WHERE i.project_id = $1`,
+		projectID,
+	))
+}
+
+func (db *Database) SaveImplementation(i *models.Implementation) error {
+	ctx := context.Background()
+	i.UpdatedAt = time.Now()
+
+	_, err := db.Pool.Exec(ctx,
+		`INSERT INTO implementations (project_id, implementer_id, contractor, contract_number, contract_amount,
+                        planned_start, planned_end, actual_start, actual_end, percent_complete, delay_reason, updated_at)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+                 ON CONFLICT (project_id) DO UPDATE SET
+                        implementer_id = EXCLUDED.implementer_id, contractor = EXCLUDED.contractor,
+                        contract_number = EXCLUDED.contract_number, contract_amount = EXCLUDED.contract_amount,
+                        planned_start = EXCLUDED.planned_start, planned_end = EXCLUDED.planned_end,
+                        actual_start = EXCLUDED.actual_start, actual_end = EXCLUDED.actual_end,
+                        percent_complete = EXCLUDED.percent_complete, delay_reason = EXCLUDED.delay_reason,
+                        updated_at = EXCLUDED.updated_at`,
+		i.ProjectID, i.ImplementerID, i.Contractor, i.ContractNumber, i.ContractAmount,
+		i.PlannedStart, i.PlannedEnd, i.ActualStart, i.ActualEnd, i.PercentComplete, i.DelayReason, i.UpdatedAt,
+	)
+
+	return err
+}
+
+// GetImplementerProjectIDs returns the projects assigned to the implementer.
+func (db *Database) GetImplementerProjectIDs(userID int) ([]int, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		"SELECT project_id FROM implementations WHERE implementer_id = $1 ORDER BY project_id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (db *Database) GetMilestones(projectID int) ([]models.Milestone, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, project_id, title, planned_date, actual_date, delay_reason, created_at
+                 FROM milestones
+                 WHERE project_id = $1
+                 ORDER BY planned_date NULLS LAST, id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var milestones []models.Milestone
+	for rows.Next() {
+		var m models.Milestone
+		if err := rows.Scan(&m.ID, &m.ProjectID, &m.Title, &m.PlannedDate, &m.ActualDate, &m.DelayReason, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		milestones = append(milestones, m)
+	}
+
+	return milestones, nil
+}
+
+// SaveMilestone creates the milestone when m.ID is 0 and otherwise updates
+// it; the project id guards against editing another project's milestone.
+func (db *Database) SaveMilestone(m *models.Milestone) error {
+	ctx := context.Background()
+
+	if m.ID == 0 {
+		return db.Pool.QueryRow(ctx,
+			`INSERT INTO milestones (project_id, title, planned_date, actual_date, delay_reason)
+                         VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+			m.ProjectID, m.Title, m.PlannedDate, m.ActualDate, m.DelayReason,
+		).Scan(&m.ID, &m.CreatedAt)
+	}
+
+	tag, err := db.Pool.Exec(ctx,
+		`UPDATE milestones SET title = $1, planned_date = $2, actual_date = $3, delay_reason = $4
+                 WHERE id = $5 AND project_id = $6`,
+		m.Title, m.PlannedDate, m.ActualDate, m.DelayReason, m.ID, m.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) DeleteMilestone(projectID, milestoneID int) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx, "DELETE FROM milestones WHERE id = $1 AND project_id = $2", milestoneID, projectID)
+	return err
+}
+
+func (db *Database) GetSpendEntries(projectID int) ([]models.SpendEntry, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, project_id, amount, description, spent_on, COALESCE(recorded_by, 0), created_at
+                 FROM spend_entries
+                 WHERE project_id = $1
+                 ORDER BY spent_on, id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.SpendEntry
+	for rows.Next() {
+		var e models.SpendEntry
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.Amount, &e.Description, &e.SpentOn, &e.RecordedBy, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// CreateSpendEntry records a payment. Entries are never edited or deleted;
+// a mistake is corrected with a negative entry so the public history stays
+// complete.
+func (db *Database) CreateSpendEntry(e *models.SpendEntry) error {
+	ctx := context.Background()
+	return db.Pool.QueryRow(ctx,
+		`INSERT INTO spend_entries (project_id, amount, description, spent_on, recorded_by)
+                 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		e.ProjectID, e.Amount, e.Description, e.SpentOn, e.RecordedBy,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// CreateProgressPhotos stores one uploaded set of photos; all share the
+// project, milestone, stage and date.
+func (db *Database) CreateProgressPhotos(photos []models.ProgressPhoto) error {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range photos {
+		p := &photos[i]
+		err := tx.QueryRow(ctx,
+			`INSERT INTO progress_photos (project_id, milestone_id, stage, path, variants, caption, taken_on, uploaded_by)
+                         VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+			p.ProjectID, p.MilestoneID, p.Stage, p.Path.Path, variantsJSON(p.Path), p.Caption, p.TakenOn, p.UploadedBy,
+		).Scan(&p.ID, &p.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// variantsJSON encodes the image's variants for the variants column.
+func variantsJSON(img models.Image) []byte {
+	if len(img.Variants) == 0 {
+		return []byte("[]")
+	}
+	data, err := json.Marshal(img.Variants)
+	if err != nil {
+		return []byte("[]")
+	}
+	return data
+}
+
+func (db *Database) GetProgressPhotos(projectID int) ([]models.ProgressPhoto, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT ph.id, ph.project_id, ph.milestone_id, COALESCE(m.title, ''), ph.stage, ph.path, ph.variants,
+                        COALESCE(ph.caption, ''), ph.taken_on, COALESCE(ph.uploaded_by, 0), ph.created_at
+                 FROM progress_photos ph
+                 LEFT JOIN milestones m ON ph.milestone_id = m.id
+                 WHERE ph.project_id = $1
+                 ORDER BY ph.taken_on, ph.id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []models.ProgressPhoto
+	for rows.Next() {
+		var p models.ProgressPhoto
+		var variants []byte
+		if err := rows.Scan(&p.ID, &p.ProjectID, &p.MilestoneID, &p.MilestoneTitle, &p.Stage, &p.Path.Path, &variants,
+			&p.Caption, &p.TakenOn, &p.UploadedBy, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(variants, &p.Path.Variants); err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+
+	return photos, nil
+}
+
+// DeleteProgressPhoto removes the photo's row and returns its path so the
+// caller can delete the file.
+func (db *Database) DeleteProgressPhoto(projectID, photoID int) (string, error) {
+	ctx := context.Background()
+	var path string
+	err := db.Pool.QueryRow(ctx,
+		"DELETE FROM progress_photos WHERE id = $1 AND project_id = $2 RETURNING path",
+		photoID, projectID,
+	).Scan(&path)
+	return path, err
+}
@@ -0,0 +1,467 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrClaimed       = errors.New("project is claimed by another moderator")
+	ErrSameModerator = errors.New("rejection must be confirmed by a second moderator")
+	ErrNotRejectable = errors.New("project cannot be rejected in its current status")
+	ErrNotModerator  = errors.New("user is not an active moderator")
+)
+
+// GetModerationQueue returns the projects awaiting moderation, longest
+// waiting first, with any claim still in force and any pending rejection.
+func (db *Database) GetModerationQueue() ([]models.ModerationItem, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT p.id, p.title, p.description, p.category, p.district, p.budget, p.lat, p.lng, p.images,
+                        p.status, p.ai_analysis, p.user_id, p.created_at, COALESCE(p.moderation_since, p.created_at),
+                        c.moderator_id, COALESCE(cu.nickname, cu.email, ''), c.expires_at,
+                        rp.proposed_by, COALESCE(ru.nickname, ru.email, ''), rp.comment, rp.created_at, rp.reason_codes,
+                        ARRAY(SELECT r.title_ru FROM rejection_reasons r WHERE r.code = ANY(rp.reason_codes) ORDER BY r.position, r.id)
+                 FROM projects p
+                 LEFT JOIN moderation_claims c ON c.project_id = p.id AND c.expires_at > CURRENT_TIMESTAMP
+                 LEFT JOIN users cu ON c.moderator_id = cu.id
+                 LEFT JOIN rejection_proposals rp ON rp.project_id = p.id
+                 LEFT JOIN users ru ON rp.proposed_by = ru.id
+                 WHERE p.status = 'moderation'
+                 ORDER BY COALESCE(p.moderation_since, p.created_at), p.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.ModerationItem
+	for rows.Next() {
+		var item models.ModerationItem
+		var imagesJSON []byte
+		var aiAnalysis, proposalComment *string
+		var proposedBy *int
+		var proposedByName string
+		var proposedAt *time.Time
+		var reasonCodes, reasonTitles []string
+		p := &item.Project
+		err := rows.Scan(&p.ID, &p.Title, &p.Description, &p.Category, &p.District, &p.Budget, &p.Lat, &p.Lng, &imagesJSON,
+			&p.Status, &aiAnalysis, &p.UserID, &p.CreatedAt, &item.QueuedAt,
+			&item.ClaimedBy, &item.ClaimedByName, &item.ClaimExpiresAt,
+			&proposedBy, &proposedByName, &proposalComment, &proposedAt, &reasonCodes, &reasonTitles)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(imagesJSON, &p.Images); err != nil {
+			p.Images = []models.Image{}
+		}
+		if aiAnalysis != nil {
+			p.AIAnalysis = *aiAnalysis
+		}
+		if proposedBy != nil {
+			item.RejectProposal = &models.RejectionProposal{
+				ProposedBy:     *proposedBy,
+				ProposedByName: proposedByName,
+				ReasonCodes:    reasonCodes,
+				ReasonTitles:   reasonTitles,
+				Comment:        *proposalComment,
+				CreatedAt:      *proposedAt,
+			}
+		}
+
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ClaimProject reserves a project in moderation for the moderator until
+// ttl from now. Claiming one's own claim again extends it; a claim held by
+// someone else is refused with ErrClaimed until it expires.
+func (db *Database) ClaimProject(projectID, moderatorID int, ttl time.Duration) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockModeration(ctx, tx, projectID); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO moderation_claims (project_id, moderator_id, claimed_at, expires_at)
+                 VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second')
+                 ON CONFLICT (project_id) DO UPDATE SET
+                        moderator_id = EXCLUDED.moderator_id,
+                        claimed_at = CASE WHEN moderation_claims.moderator_id = EXCLUDED.moderator_id
+                                AND moderation_claims.expires_at > CURRENT_TIMESTAMP
+                                THEN moderation_claims.claimed_at ELSE EXCLUDED.claimed_at END,
+                        expires_at = EXCLUDED.expires_at
+                 WHERE moderation_claims.moderator_id = EXCLUDED.moderator_id
+                        OR moderation_claims.expires_at <= CURRENT_TIMESTAMP`,
+		projectID, moderatorID, ttl.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrClaimed
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AssignProject claims a project in moderation on behalf of another
+// moderator for ttl from now and notifies them. Like ClaimProject it is
+// refused with ErrClaimed while a third moderator's claim holds the
+// project; the assigner's own claim passes to the assignee.
+func (db *Database) AssignProject(projectID, assignerID, assigneeID int, ttl time.Duration) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var active bool
+	err = tx.QueryRow(ctx,
+		"SELECT role = 'admin' AND NOT COALESCE(disabled, FALSE) FROM users WHERE id = $1",
+		assigneeID,
+	).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !active) {
+		return ErrNotModerator
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := lockModeration(ctx, tx, projectID); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO moderation_claims (project_id, moderator_id, claimed_at, expires_at)
+                 VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $4::float8 * INTERVAL '1 second')
+                 ON CONFLICT (project_id) DO UPDATE SET
+                        moderator_id = EXCLUDED.moderator_id,
+                        claimed_at = CASE WHEN moderation_claims.moderator_id = EXCLUDED.moderator_id
+                                AND moderation_claims.expires_at > CURRENT_TIMESTAMP
+                                THEN moderation_claims.claimed_at ELSE EXCLUDED.claimed_at END,
+                        expires_at = EXCLUDED.expires_at
+                 WHERE moderation_claims.moderator_id IN (EXCLUDED.moderator_id, $3)
+                        OR moderation_claims.expires_at <= CURRENT_TIMESTAMP`,
+		projectID, assigneeID, assignerID, ttl.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrClaimed
+	}
+
+	if assigneeID != assignerID {
+		var title string
+		if err := tx.QueryRow(ctx, "SELECT title FROM projects WHERE id = $1", projectID).Scan(&title); err != nil {
+			return err
+		}
+		if err := notify(ctx, tx, assigneeID, "Вам назначен проект на модерацию: "+title, "/admin"); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReleaseClaim gives the project back to the queue.
+func (db *Database) ReleaseClaim(projectID, moderatorID int) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx,
+		"DELETE FROM moderation_claims WHERE project_id = $1 AND moderator_id = $2",
+		projectID, moderatorID,
+	)
+	return err
+}
+
+// lockModeration locks a project that is still in moderation, so that two
+// moderators deciding at once cannot both succeed.
+func lockModeration(ctx context.Context, tx pgx.Tx, projectID int) error {
+	var id int
+	err := tx.QueryRow(ctx,
+		"SELECT id FROM projects WHERE id = $1 AND status = 'moderation' FOR UPDATE",
+		projectID,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotInModeration
+	}
+	return err
+}
+
+// checkClaim refuses with ErrClaimed a project that another moderator's
+// claim still holds. It returns when the moderator claimed it, or nil if
+// it is unclaimed.
+func checkClaim(ctx context.Context, tx pgx.Tx, projectID, moderatorID int) (*time.Time, error) {
+	var claimedBy int
+	var claimedAt time.Time
+	err := tx.QueryRow(ctx,
+		"SELECT moderator_id, claimed_at FROM moderation_claims WHERE project_id = $1 AND expires_at > CURRENT_TIMESTAMP",
+		projectID,
+	).Scan(&claimedBy, &claimedAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case claimedBy != moderatorID:
+		return nil, ErrClaimed
+	}
+	return &claimedAt, nil
+}
+
+// finishModeration records the moderator's decision on a locked project
+// and clears its claim and any pending rejection. An unclaimed project may
+// be decided directly.
+func finishModeration(ctx context.Context, tx pgx.Tx, projectID, moderatorID int, decision string, proposedBy *int) error {
+	claimedAt, err := checkClaim(ctx, tx, projectID, moderatorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO moderation_decisions (project_id, moderator_id, decision, proposed_by, queued_at, claimed_at)
+                 SELECT id, $2, $3, $4, COALESCE(moderation_since, created_at), $5 FROM projects WHERE id = $1`,
+		projectID, moderatorID, decision, proposedBy, claimedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM moderation_claims WHERE project_id = $1", projectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM rejection_proposals WHERE project_id = $1", projectID)
+	return err
+}
+
+func setModerationStatus(ctx context.Context, tx pgx.Tx, projectID, moderatorID int, status, comment string, reasonCodes []string) error {
+	_, err := tx.Exec(ctx, "UPDATE projects SET status = $1 WHERE id = $2", status, projectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		"INSERT INTO project_status_history (project_id, status, comment, admin_id, reason_codes) VALUES ($1, $2, $3, $4, $5)",
+		projectID, status, comment, moderatorID, reasonCodes,
+	)
+	return err
+}
+
+// ApproveProject moves a project from moderation to voting.
+func (db *Database) ApproveProject(projectID, moderatorID int, comment string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockModeration(ctx, tx, projectID); err != nil {
+		return err
+	}
+	if err := finishModeration(ctx, tx, projectID, moderatorID, "voting", nil); err != nil {
+		return err
+	}
+	if err := setModerationStatus(ctx, tx, projectID, moderatorID, "voting", comment, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RejectProject rejects a project in moderation or in voting, citing the
+// codes of rejection_reasons; it is the only way a project is rejected.
+// With twoPerson the first moderator's rejection is only a proposal, which
+// returns the project to the queue; a different moderator rejecting it
+// then confirms it, and the reasons and comments of both are kept. It
+// reports whether the project was actually rejected.
+func (db *Database) RejectProject(projectID, moderatorID int, reasonCodes []string, comment string, twoPerson bool) (bool, error) {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx,
+		"SELECT status FROM projects WHERE id = $1 AND status IN ('moderation', 'voting') FOR UPDATE",
+		projectID,
+	).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotRejectable
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var proposedBy *int
+	if twoPerson {
+		var proposer int
+		var proposal string
+		var proposedCodes []string
+		err := tx.QueryRow(ctx,
+			"SELECT proposed_by, comment, reason_codes FROM rejection_proposals WHERE project_id = $1",
+			projectID,
+		).Scan(&proposer, &proposal, &proposedCodes)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			if err := proposeRejection(ctx, tx, projectID, moderatorID, reasonCodes, comment); err != nil {
+				return false, err
+			}
+			return false, tx.Commit(ctx)
+		case err != nil:
+			return false, err
+		case proposer == moderatorID:
+			return false, ErrSameModerator
+		}
+		proposedBy = &proposer
+		reasonCodes = mergeCodes(proposedCodes, reasonCodes)
+		if comment == "" {
+			comment = proposal
+		} else if proposal != "" {
+			comment = proposal + "\n" + comment
+		}
+	}
+
+	// Only decisions on the queue count towards the moderation statistics;
+	// a project leaving voting just drops its proposal.
+	if status == "moderation" {
+		err = finishModeration(ctx, tx, projectID, moderatorID, "rejected", proposedBy)
+	} else {
+		_, err = tx.Exec(ctx, "DELETE FROM rejection_proposals WHERE project_id = $1", projectID)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := setModerationStatus(ctx, tx, projectID, moderatorID, "rejected", comment, reasonCodes); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// GetVotingRejectionProposals returns the pending rejections of projects
+// in voting, by project.
+func (db *Database) GetVotingRejectionProposals() (map[int]*models.RejectionProposal, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT rp.project_id, rp.proposed_by, COALESCE(ru.nickname, ru.email, ''), rp.comment, rp.created_at, rp.reason_codes,
+                        ARRAY(SELECT r.title_ru FROM rejection_reasons r WHERE r.code = ANY(rp.reason_codes) ORDER BY r.position, r.id)
+                 FROM rejection_proposals rp
+                 JOIN projects p ON p.id = rp.project_id
+                 LEFT JOIN users ru ON rp.proposed_by = ru.id
+                 WHERE p.status = 'voting'`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	proposals := map[int]*models.RejectionProposal{}
+	for rows.Next() {
+		var projectID int
+		var p models.RejectionProposal
+		if err := rows.Scan(&projectID, &p.ProposedBy, &p.ProposedByName, &p.Comment, &p.CreatedAt, &p.ReasonCodes, &p.ReasonTitles); err != nil {
+			return nil, err
+		}
+		proposals[projectID] = &p
+	}
+	return proposals, rows.Err()
+}
+
+// proposeRejection records the first half of a two-person rejection and
+// releases the project so that another moderator can pick it up.
+func proposeRejection(ctx context.Context, tx pgx.Tx, projectID, moderatorID int, reasonCodes []string, comment string) error {
+	if _, err := checkClaim(ctx, tx, projectID, moderatorID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx,
+		"INSERT INTO rejection_proposals (project_id, proposed_by, comment, reason_codes) VALUES ($1, $2, $3, $4)",
+		projectID, moderatorID, comment, reasonCodes,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM moderation_claims WHERE project_id = $1", projectID)
+	return err
+}
+
+// mergeCodes appends the codes of b missing from a.
+func mergeCodes(a, b []string) []string {
+	merged := append([]string(nil), a...)
+	for _, code := range b {
+		found := false
+		for _, c := range merged {
+			if c == code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, code)
+		}
+	}
+	return merged
+}
+
+// GetModeratorStats sums up the decisions made since the given time per
+// moderator, busiest first. sla is used to count decisions made in time.
+func (db *Database) GetModeratorStats(since time.Time, sla time.Duration) ([]models.ModeratorStats, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT d.moderator_id, COALESCE(u.nickname, u.email, ''),
+                        COUNT(*) FILTER (WHERE d.decision = 'voting'),
+                        COUNT(*) FILTER (WHERE d.decision = 'changes_requested'),
+                        COUNT(*) FILTER (WHERE d.decision = 'rejected'),
+                        COUNT(*),
+                        COUNT(*) FILTER (WHERE d.decided_at - d.queued_at <= $2::float8 * INTERVAL '1 second'),
+                        COALESCE(AVG(EXTRACT(EPOCH FROM d.decided_at - d.queued_at)), 0)::float8,
+                        COALESCE(AVG(EXTRACT(EPOCH FROM d.decided_at - d.claimed_at)), 0)::float8
+                 FROM moderation_decisions d
+                 LEFT JOIN users u ON d.moderator_id = u.id
+                 WHERE d.decided_at >= $1
+                 GROUP BY d.moderator_id, u.nickname, u.email
+                 ORDER BY COUNT(*) DESC`,
+		since, sla.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.ModeratorStats
+	for rows.Next() {
+		var s models.ModeratorStats
+		var avgDecision, avgHandling float64
+		err := rows.Scan(&s.ModeratorID, &s.Nickname, &s.Approved, &s.ChangesRequested, &s.Rejected, &s.Total,
+			&s.WithinSLA, &avgDecision, &avgHandling)
+		if err != nil {
+			return nil, err
+		}
+		s.AvgDecision = time.Duration(avgDecision * float64(time.Second))
+		s.AvgHandling = time.Duration(avgHandling * float64(time.Second))
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
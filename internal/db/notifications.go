@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// notify leaves a message for the user, shown on their profile, as part
+// of the transaction that caused it.
+func notify(ctx context.Context, tx pgx.Tx, userID int, message, link string) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO notifications (user_id, message, link) VALUES ($1, $2, $3)",
+		userID, message, link,
+	)
+	return err
+}
+
+// GetNotifications returns the user's latest notifications, newest first.
+func (db *Database) GetNotifications(userID, limit int) ([]models.Notification, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, user_id, message, link, created_at, read_at FROM notifications
+                 WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Message, &n.Link, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (db *Database) CountUnreadNotifications(userID int) (int, error) {
+	ctx := context.Background()
+	var n int
+	err := db.Pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL",
+		userID,
+	).Scan(&n)
+	return n, err
+}
+
+func (db *Database) MarkNotificationsRead(userID int) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx,
+		"UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND read_at IS NULL",
+		userID,
+	)
+	return err
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/tally"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrOfflineVerificationPending = errors.New("iin has a pending online verification")
+	ErrOfflineAlreadyVoted        = errors.New("voter has already voted")
+	ErrOfflineVotingClosed        = errors.New("voting is not open")
+	ErrOfflineEmptyBallot         = errors.New("ballot has no entries")
+)
+
+// offlineVoter returns the account that votes for the IIN. A citizen who
+// passed online verification votes with their own account, so paper and
+// online ballots share the one-vote rule. Otherwise the IIN's placeholder
+// account is used, created on the first offline ballot: it is verified by
+// the staff who saw the ID card and cannot log in. A citizen who verifies
+// online later takes the placeholder's votes over, see
+// takeOverOfflineVoter.
+func offlineVoter(ctx context.Context, tx pgx.Tx, iinHash string, staffID int) (int, error) {
+	var userID int
+	var status string
+	// The citizen's own verification comes before the placeholder's.
+	err := tx.QueryRow(ctx,
+		`SELECT v.user_id, v.status FROM identity_verifications v
+                 WHERE v.iin_hash = $2 AND `+activeVerification+`
+                 ORDER BY v.method = 'offline' LIMIT 1`,
+		time.Now(), iinHash,
+	).Scan(&userID, &status)
+	if err == nil {
+		if status != "approved" {
+			return 0, ErrOfflineVerificationPending
+		}
+		return userID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	err = tx.QueryRow(ctx,
+		"INSERT INTO users (email, nickname, password_hash, role, verified) VALUES ($1, $2, '!', 'citizen', TRUE) RETURNING id",
+		fmt.Sprintf("offline-%s@offline.invalid", iinHash[:16]), "Участник офлайн-голосования",
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO identity_verifications (user_id, iin_hash, method, status, reviewer_id, reviewed_at)
+                 VALUES ($1, $2, 'offline', 'approved', $3, $4)`,
+		userID, iinHash, staffID, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// recordOfflineBallot stores b inside tx. Projects outside any cycle and
+// single-vote cycles get one vote per entry; other cycles get a ballot
+// checked by the same rules as an online one. Kiosk ballots need an open
+// voting window; paper ballots may be imported after it closes, until the
+// cycle's log is sealed.
+func recordOfflineBallot(ctx context.Context, tx pgx.Tx, b *models.OfflineBallot) ([]string, error) {
+	if len(b.Entries) == 0 {
+		return nil, ErrOfflineEmptyBallot
+	}
+
+	userID, err := offlineVoter(ctx, tx, b.IINHash, b.StaffID)
+	if err != nil {
+		return nil, err
+	}
+
+	var cycle *models.BudgetCycle
+	if b.CycleID != 0 {
+		cycle, err = scanCycle(tx.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1", b.CycleID))
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if b.Channel == models.ChannelKiosk && !cycle.VotingOpen(now) {
+			return nil, ErrOfflineVotingClosed
+		}
+		if cycle.VoteStart != nil && now.Before(*cycle.VoteStart) {
+			return nil, ErrOfflineVotingClosed
+		}
+	}
+
+	if cycle != nil && cycle.BallotType != models.BallotSingle {
+		var exists bool
+		err := tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM ballots WHERE cycle_id = $1 AND user_id = $2 AND withdrawn_at IS NULL)",
+			cycle.ID, userID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrOfflineAlreadyVoted
+		}
+
+		ballot := &models.Ballot{
+			CycleID:    cycle.ID,
+			UserID:     userID,
+			BallotType: cycle.BallotType,
+			Entries:    b.Entries,
+			Channel:    b.Channel,
+			RecordedBy: &b.StaffID,
+			BatchID:    b.BatchID,
+		}
+		if err := insertBallot(ctx, tx, ballot); err != nil {
+			return nil, err
+		}
+		return []string{ballot.Receipt}, nil
+	}
+
+	var receipts []string
+	for _, e := range b.Entries {
+		if e.Value != 1 {
+			return nil, tally.ErrInvalidEntry
+		}
+
+		var projectCycle int
+		project := models.Project{ID: e.ProjectID, Status: "voting"}
+		err := tx.QueryRow(ctx,
+			"SELECT COALESCE(cycle_id, 0), vote_start, vote_end FROM projects WHERE id = $1 AND status = 'voting'",
+			e.ProjectID,
+		).Scan(&projectCycle, &project.VoteStart, &project.VoteEnd)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && projectCycle != b.CycleID) {
+			return nil, ErrBallotInvalidItem
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Outside a cycle there is no log to seal, so paper ballots are held
+		// to the project's window like online votes.
+		if projectCycle == 0 && !project.VotingOpen(time.Now()) {
+			return nil, ErrOfflineVotingClosed
+		}
+
+		var exists bool
+		err = tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM votes WHERE project_id = $1 AND user_id = $2 AND withdrawn_at IS NULL)",
+			e.ProjectID, userID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrOfflineAlreadyVoted
+		}
+
+		vote := &models.Vote{
+			ProjectID:  e.ProjectID,
+			UserID:     userID,
+			Channel:    b.Channel,
+			RecordedBy: &b.StaffID,
+			BatchID:    b.BatchID,
+		}
+		if err := insertVote(ctx, tx, vote); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, vote.Receipt)
+	}
+
+	return receipts, nil
+}
+
+// RecordOfflineBallot stores a ballot entered at a kiosk and returns its
+// receipt codes, one per stored vote or ballot.
+func (db *Database) RecordOfflineBallot(b *models.OfflineBallot) ([]string, error) {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	receipts, err := recordOfflineBallot(ctx, tx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, tx.Commit(ctx)
+}
+
+// ImportPaperBallots records a batch of paper ballots. Each ballot runs in
+// its own savepoint, so an invalid one is reported in errs at its index and
+// skipped while the rest go in. With dryRun everything is rolled back at
+// the end: the preview runs exactly the checks the import will.
+func (db *Database) ImportPaperBallots(batch *models.PaperBatch, ballots []*models.OfflineBallot, dryRun bool) ([]error, error) {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if !dryRun {
+		err := tx.QueryRow(ctx,
+			"INSERT INTO paper_batches (admin_id, filename, rows) VALUES ($1, $2, $3) RETURNING id, created_at",
+			batch.AdminID, batch.Filename, batch.Rows,
+		).Scan(&batch.ID, &batch.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	errs := make([]error, len(ballots))
+	batch.Imported, batch.Skipped = 0, 0
+	for i, b := range ballots {
+		b.Channel = models.ChannelPaper
+		b.StaffID = batch.AdminID
+		if !dryRun {
+			b.BatchID = &batch.ID
+		}
+
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := recordOfflineBallot(ctx, sp, b); err != nil {
+			if rbErr := sp.Rollback(ctx); rbErr != nil {
+				return nil, rbErr
+			}
+			errs[i] = err
+			batch.Skipped++
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return nil, err
+		}
+		batch.Imported++
+	}
+
+	if dryRun {
+		return errs, nil
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE paper_batches SET imported = $1, skipped = $2 WHERE id = $3",
+		batch.Imported, batch.Skipped, batch.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, tx.Commit(ctx)
+}
+
+func (db *Database) GetPaperBatches() ([]models.PaperBatch, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT b.id, COALESCE(b.admin_id, 0), COALESCE(u.email, ''), b.filename, b.rows, b.imported, b.skipped, b.created_at
+                 FROM paper_batches b
+                 LEFT JOIN users u ON b.admin_id = u.id
+                 ORDER BY b.created_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []models.PaperBatch
+	for rows.Next() {
+		var b models.PaperBatch
+		if err := rows.Scan(&b.ID, &b.AdminID, &b.AdminEmail, &b.Filename, &b.Rows, &b.Imported, &b.Skipped, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+
+	return batches, nil
+}
+
+// GetChannelBreakdown counts active, unquarantined votes and ballots per
+// channel. cycleID 0 covers projects outside any cycle.
+func (db *Database) GetChannelBreakdown(cycleID int) (map[string]int, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT v.channel, COUNT(*)
+                 FROM votes v JOIN projects p ON v.project_id = p.id
+                 WHERE COALESCE(p.cycle_id, 0) = $1 AND v.withdrawn_at IS NULL AND NOT COALESCE(v.quarantined, FALSE)
+                 GROUP BY v.channel
+                 UNION ALL
+                 SELECT channel, COUNT(*)
+                 FROM ballots
+                 WHERE cycle_id = $1 AND withdrawn_at IS NULL AND NOT quarantined
+                 GROUP BY channel`,
+		cycleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var channel string
+		var n int
+		if err := rows.Scan(&channel, &n); err != nil {
+			return nil, err
+		}
+		counts[channel] += n
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var ErrReasonCodeTaken = errors.New("rejection reason code already exists")
+
+// defaultRejectionReasons are the reasons every installation starts with.
+// Seeding skips codes that already exist, so admins' edits are kept.
+var defaultRejectionReasons = []models.RejectionReason{
+	{
+		Code:    "budget_out_of_range",
+		TitleRu: "Бюджет вне допустимых пределов",
+		TitleKk: "Бюджет рұқсат етілген шектен тыс",
+		TextRu:  "Стоимость проекта выходит за пределы бюджета, допустимого для одного проекта (от 300 000 до 2 000 000 ₸).",
+		TextKk:  "Жобаның құны бір жобаға рұқсат етілген бюджет шегінен (300 000-нан 2 000 000 ₸-ге дейін) тыс.",
+	},
+	{
+		Code:    "personal_use",
+		TitleRu: "Личное использование",
+		TitleKk: "Жеке пайдалану",
+		TextRu:  "Проект служит интересам отдельных лиц или организаций, а не жителей города.",
+		TextKk:  "Жоба қала тұрғындарының емес, жекелеген адамдардың немесе ұйымдардың мүддесіне қызмет етеді.",
+	},
+	{
+		Code:    "outside_city",
+		TitleRu: "За пределами города",
+		TitleKk: "Қала шегінен тыс",
+		TextRu:  "Место реализации проекта находится за пределами Петропавловска.",
+		TextKk:  "Жобаны іске асыру орны Петропавл қаласының шегінен тыс орналасқан.",
+	},
+	{
+		Code:    "duplicate",
+		TitleRu: "Повтор другого проекта",
+		TitleKk: "Басқа жобаның қайталануы",
+		TextRu:  "Такой проект уже подан другим автором или уже реализуется.",
+		TextKk:  "Мұндай жобаны басқа автор ұсынып қойған немесе ол іске асырылуда.",
+	},
+	{
+		Code:    "not_municipal",
+		TitleRu: "Не в компетенции города",
+		TitleKk: "Қала құзыретіне жатпайды",
+		TextRu:  "Решение этого вопроса не входит в полномочия городского акимата.",
+		TextKk:  "Бұл мәселені шешу қала әкімдігінің өкілеттігіне жатпайды.",
+	},
+}
+
+func (db *Database) seedRejectionReasons(ctx context.Context) error {
+	for i, r := range defaultRejectionReasons {
+		_, err := db.Pool.Exec(ctx,
+			`INSERT INTO rejection_reasons (code, title_ru, title_kk, text_ru, text_kk, position)
+                         VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (code) DO NOTHING`,
+			r.Code, r.TitleRu, r.TitleKk, r.TextRu, r.TextKk, (i+1)*10,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRejectionReasons returns the reasons in the order moderators see
+// them, only the active ones unless all is set.
+func (db *Database) GetRejectionReasons(all bool) ([]models.RejectionReason, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, code, title_ru, title_kk, text_ru, text_kk, position, active
+                 FROM rejection_reasons
+                 WHERE active OR $1
+                 ORDER BY position, id`,
+		all,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reasons []models.RejectionReason
+	for rows.Next() {
+		var r models.RejectionReason
+		if err := rows.Scan(&r.ID, &r.Code, &r.TitleRu, &r.TitleKk, &r.TextRu, &r.TextKk, &r.Position, &r.Active); err != nil {
+			return nil, err
+		}
+		reasons = append(reasons, r)
+	}
+	return reasons, rows.Err()
+}
+
+func (db *Database) CreateRejectionReason(r *models.RejectionReason) error {
+	ctx := context.Background()
+	err := db.Pool.QueryRow(ctx,
+		`INSERT INTO rejection_reasons (code, title_ru, title_kk, text_ru, text_kk, position, active)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		r.Code, r.TitleRu, r.TitleKk, r.TextRu, r.TextKk, r.Position, r.Active,
+	).Scan(&r.ID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrReasonCodeTaken
+	}
+	return err
+}
+
+// UpdateRejectionReason changes a reason's texts, order and availability.
+// The code stays, since status history refers to it.
+func (db *Database) UpdateRejectionReason(r *models.RejectionReason) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx,
+		`UPDATE rejection_reasons SET title_ru = $1, title_kk = $2, text_ru = $3, text_kk = $4, position = $5, active = $6
+                 WHERE id = $7`,
+		r.TitleRu, r.TitleKk, r.TextRu, r.TextKk, r.Position, r.Active, r.ID,
+	)
+	return err
+}
+
+// GetRejectionStats counts the reasons cited by rejections made since the
+// given time, most frequent first, and returns the number of rejections.
+// A rejection citing several reasons counts towards each of them.
+func (db *Database) GetRejectionStats(since time.Time) ([]models.RejectionReasonCount, int, error) {
+	ctx := context.Background()
+
+	var total int
+	err := db.Pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM project_status_history WHERE status = 'rejected' AND created_at >= $1",
+		since,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		`SELECT COALESCE(c.code, ''), COALESCE(r.title_ru, c.code, ''), COUNT(*)
+                 FROM project_status_history h
+                 LEFT JOIN LATERAL unnest(h.reason_codes) AS c(code) ON TRUE
+                 LEFT JOIN rejection_reasons r ON r.code = c.code
+                 WHERE h.status = 'rejected' AND h.created_at >= $1
+                 GROUP BY c.code, r.title_ru, r.position
+                 ORDER BY COUNT(*) DESC, r.position`,
+		since,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var counts []models.RejectionReasonCount
+	for rows.Next() {
+		var c models.RejectionReasonCount
+		if err := rows.Scan(&c.Code, &c.TitleRu, &c.Count); err != nil {
+			return nil, 0, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, total, rows.Err()
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotRespondable = errors.New("project is not open for official responses")
+
+const officialResponseColumns = `r.id, r.project_id, r.author_id, COALESCE(u.nickname, u.email, ''), r.feasibility,
+                r.estimated_cost, r.department, r.body, r.created_at, r.updated_at`
+
+func scanOfficialResponse(row pgx.Row) (*models.OfficialResponse, error) {
+	var r models.OfficialResponse
+	err := row.Scan(&r.ID, &r.ProjectID, &r.AuthorID, &r.AuthorName, &r.Feasibility,
+		&r.EstimatedCost, &r.Department, &r.Body, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetOfficialResponses returns the departments' responses on the project,
+// oldest first.
+func (db *Database) GetOfficialResponses(projectID int) ([]models.OfficialResponse, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT `+officialResponseColumns+`
+                 FROM official_responses r
+                 LEFT JOIN users u ON u.id = r.author_id
+                 WHERE r.project_id = $1
+                 ORDER BY r.created_at, r.id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var responses []models.OfficialResponse
+	for rows.Next() {
+		r, err := scanOfficialResponse(rows)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, *r)
+	}
+	return responses, rows.Err()
+}
+
+// SaveOfficialResponse publishes the response on its project, replacing
+// the earlier response of the same department, and tells the project's
+// author. The project must be in one of models.RespondableStatuses, or
+// ErrNotRespondable is returned. It returns the response it replaced, or
+// nil.
+func (db *Database) SaveOfficialResponse(r *models.OfficialResponse) (*models.OfficialResponse, error) {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var projectAuthor int
+	err = tx.QueryRow(ctx,
+		"SELECT user_id FROM projects WHERE id = $1 AND status = ANY($2) FOR UPDATE",
+		r.ProjectID, models.RespondableStatuses,
+	).Scan(&projectAuthor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotRespondable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := scanOfficialResponse(tx.QueryRow(ctx,
+		`SELECT `+officialResponseColumns+`
+                 FROM official_responses r
+                 LEFT JOIN users u ON u.id = r.author_id
+                 WHERE r.project_id = $1 AND r.department = $2`,
+		r.ProjectID, r.Department,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		previous = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO official_responses (project_id, author_id, feasibility, estimated_cost, department, body)
+                 VALUES ($1, $2, $3, $4, $5, $6)
+                 ON CONFLICT (project_id, department) DO UPDATE SET
+                        author_id = EXCLUDED.author_id, feasibility = EXCLUDED.feasibility,
+                        estimated_cost = EXCLUDED.estimated_cost,
+                        body = EXCLUDED.body, updated_at = CURRENT_TIMESTAMP
+                 RETURNING id, created_at, updated_at`,
+		r.ProjectID, r.AuthorID, r.Feasibility, r.EstimatedCost, r.Department, r.Body,
+	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("%s ответил на ваш проект: %s", r.Department, models.FeasibilityLabel(r.Feasibility))
+	if previous != nil {
+		message = fmt.Sprintf("%s обновил ответ на ваш проект: %s", r.Department, models.FeasibilityLabel(r.Feasibility))
+	}
+	if err := notify(ctx, tx, projectAuthor, message, fmt.Sprintf("/projects/%d#official-response", r.ProjectID)); err != nil {
+		return nil, err
+	}
+
+	return previous, tx.Commit(ctx)
+}
+
+// GetProjectsAwaitingResponse returns the projects in one of
+// models.RespondableStatuses that the department has not answered yet,
+// oldest first.
+func (db *Database) GetProjectsAwaitingResponse(department string) ([]models.Project, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT p.id FROM projects p
+                 WHERE p.status = ANY($1)
+                   AND NOT EXISTS (SELECT 1 FROM official_responses r WHERE r.project_id = p.id AND r.department = $2)
+                 ORDER BY p.created_at`,
+		models.RespondableStatuses, department,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var projects []models.Project
+	for _, id := range ids {
+		p, err := db.GetProjectByID(id)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, *p)
+	}
+	return projects, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"petropavlovsk-budget/internal/models"
+)
+
+// GetCycleVoters returns, for each project of the cycle, the users whose
+// counted vote or ballot supports it, and the number of distinct voters.
+func (db *Database) GetCycleVoters(cycleID int) (map[int][]int, int, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT v.project_id, v.user_id
+                 FROM votes v JOIN projects p ON v.project_id = p.id
+                 WHERE p.cycle_id = $1 AND v.withdrawn_at IS NULL AND NOT COALESCE(v.quarantined, FALSE)
+                 UNION
+                 SELECT e.project_id, b.user_id
+                 FROM ballot_entries e JOIN ballots b ON e.ballot_id = b.id
+                 WHERE b.cycle_id = $1 AND b.withdrawn_at IS NULL AND NOT b.quarantined`,
+		cycleID,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	voters := map[int][]int{}
+	distinct := map[int]bool{}
+	for rows.Next() {
+		var projectID, userID int
+		if err := rows.Scan(&projectID, &userID); err != nil {
+			return nil, 0, err
+		}
+		voters[projectID] = append(voters[projectID], userID)
+		distinct[userID] = true
+	}
+
+	return voters, len(distinct), rows.Err()
+}
+
+// GetCycleDailyParticipation counts the votes and ballots of the cycle that
+// still count, by the day they were cast.
+func (db *Database) GetCycleDailyParticipation(cycleID int) ([]models.DayCount, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT day, SUM(n)::int FROM (
+                        SELECT date_trunc('day', v.created_at) AS day, COUNT(*) AS n
+                        FROM votes v JOIN projects p ON v.project_id = p.id
+                        WHERE p.cycle_id = $1 AND v.withdrawn_at IS NULL AND NOT COALESCE(v.quarantined, FALSE)
+                        GROUP BY 1
+                        UNION ALL
+                        SELECT date_trunc('day', created_at), COUNT(*)
+                        FROM ballots
+                        WHERE cycle_id = $1 AND withdrawn_at IS NULL AND NOT quarantined
+                        GROUP BY 1
+                 ) t
+                 GROUP BY day
+                 ORDER BY day`,
+		cycleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []models.DayCount
+	for rows.Next() {
+		var d models.DayCount
+		if err := rows.Scan(&d.Date, &d.Count); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+
+	return days, nil
+}
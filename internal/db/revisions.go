@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotEditable = errors.New("project can no longer be edited by its author")
+
+// UpdateProject is an admin's edit of the project's text, category and
+// budget; the location is kept. Like every edit it is recorded as a
+// revision. The budget is refused with ErrVotingStarted once the project
+// is voted on.
+func (db *Database) UpdateProject(projectID, editorID int, title, description, category, district string, budget int, reason string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = reviseProject(ctx, tx, projectID, editorID, 0, reason, func(s *models.ProjectSubmission) {
+		s.Title, s.Description, s.Category, s.District, s.Budget = title, description, category, district, budget
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateProjectByAuthor applies the author's edit. The project must still
+// belong to authorID and be in one of models.AuthorEditableStatuses; the
+// check and the update run in one transaction, so an edit racing a
+// moderator's decision is refused with ErrNotEditable. If the edit changes
+// anything, analysis replaces the AI analysis of the old text.
+func (db *Database) UpdateProjectByAuthor(projectID, authorID int, edited models.ProjectSubmission, reason, analysis string) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	changed, err := reviseProject(ctx, tx, projectID, authorID, authorID, reason, func(s *models.ProjectSubmission) {
+		*s = edited
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := setAIAnalysis(ctx, tx, projectID, analysis); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// reviseProject locks the project, stores its current fields as a revision
+// by editorID and writes the fields as changed by edit. With a non-zero
+// authorID only that author's project in an editable status qualifies. An
+// edit that changes nothing stores no revision and reports false. The
+// budget is fixed once the project's cycle has started voting, or, for a
+// project outside any cycle, once it has reached voting.
+func reviseProject(ctx context.Context, tx pgx.Tx, projectID, editorID, authorID int, reason string, edit func(*models.ProjectSubmission)) (bool, error) {
+	var old models.ProjectSubmission
+	var status string
+	var cycleID int
+	err := tx.QueryRow(ctx,
+		`SELECT title, description, category, district, budget, lat, lng, status, COALESCE(cycle_id, 0) FROM projects
+                 WHERE id = $1 AND ($2 = 0 OR (user_id = $2 AND status = ANY($3))) FOR UPDATE`,
+		projectID, authorID, models.AuthorEditableStatuses,
+	).Scan(&old.Title, &old.Description, &old.Category, &old.District, &old.Budget, &old.Lat, &old.Lng, &status, &cycleID)
+	if errors.Is(err, pgx.ErrNoRows) && authorID != 0 {
+		return false, ErrNotEditable
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s := old
+	edit(&s)
+	if s == old {
+		return false, nil
+	}
+	if s.Budget != old.Budget {
+		if cycleID != 0 {
+			if err := lockCycleOpen(ctx, tx, cycleID); err != nil {
+				return false, err
+			}
+		} else if (&models.Project{Status: status}).Tallied() {
+			return false, ErrVotingStarted
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO project_revisions (project_id, editor_id, reason, title, description, category, district, budget, lat, lng)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		projectID, editorID, reason, old.Title, old.Description, old.Category, old.District, old.Budget, old.Lat, old.Lng,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE projects SET title = $1, description = $2, category = $3, district = $4, budget = $5, lat = $6, lng = $7
+                 WHERE id = $8`,
+		s.Title, s.Description, s.Category, s.District, s.Budget, s.Lat, s.Lng, projectID,
+	)
+	return err == nil, err
+}
+
+// setAIAnalysis replaces the project's AI analysis after its author changed
+// the text it was made for. An empty analysis clears it.
+func setAIAnalysis(ctx context.Context, tx pgx.Tx, projectID int, analysis string) error {
+	_, err := tx.Exec(ctx, "UPDATE projects SET ai_analysis = NULLIF($1, '') WHERE id = $2", analysis, projectID)
+	return err
+}
+
+// GetProjectRevisions returns the project's edits, oldest first.
+func (db *Database) GetProjectRevisions(projectID int) ([]models.ProjectRevision, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT r.id, r.project_id, COALESCE(r.editor_id, 0), COALESCE(u.nickname, ''), r.reason,
+                        r.title, r.description, r.category, r.district, r.budget, r.lat, r.lng, r.created_at
+                 FROM project_revisions r
+                 LEFT JOIN users u ON r.editor_id = u.id
+                 WHERE r.project_id = $1
+                 ORDER BY r.id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.ProjectRevision
+	for rows.Next() {
+		var r models.ProjectRevision
+		err := rows.Scan(&r.ID, &r.ProjectID, &r.EditorID, &r.EditorNickname, &r.Reason,
+			&r.Title, &r.Description, &r.Category, &r.District, &r.Budget, &r.Lat, &r.Lng, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/votelog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrVoteQuarantined is returned when the vote or ballot to withdraw, move
+// or replace is quarantined: it stays as it is until a moderator releases it.
+var ErrVoteQuarantined = errors.New("vote is quarantined")
+
+// WithdrawVote retracts the user's vote for the project. The row is kept
+// with its comment and marked withdrawn, so it stops counting but stays in
+// the user's history. Returns pgx.ErrNoRows if there is no active vote and
+// ErrVoteQuarantined if it is quarantined.
+func (db *Database) WithdrawVote(projectID, userID int) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := withdrawVote(ctx, tx, projectID, userID, models.VoteChangeWithdraw, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// MoveVote withdraws the user's vote for fromProjectID and casts v in its
+// place, in one transaction.
+func (db *Database) MoveVote(fromProjectID int, v *models.Vote) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := withdrawVote(ctx, tx, fromProjectID, v.UserID, models.VoteChangeMove, &v.ProjectID); err != nil {
+		return err
+	}
+	if err := insertVote(ctx, tx, v); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func withdrawVote(ctx context.Context, tx pgx.Tx, projectID, userID int, action string, toProjectID *int) error {
+	var voteID, cycleID int
+	var comment, receipt string
+	var quarantined bool
+	err := tx.QueryRow(ctx,
+		`SELECT v.id, v.comment, COALESCE(v.receipt, ''), COALESCE(v.quarantined, FALSE), COALESCE(p.cycle_id, 0)
+                 FROM votes v JOIN projects p ON p.id = v.project_id
+                 WHERE v.project_id = $1 AND v.user_id = $2 AND v.withdrawn_at IS NULL
+                 FOR UPDATE OF v`,
+		projectID, userID,
+	).Scan(&voteID, &comment, &receipt, &quarantined, &cycleID)
+	if err != nil {
+		return err
+	}
+	// Withdrawing and casting again would clear the quarantine.
+	if quarantined {
+		return ErrVoteQuarantined
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE votes SET withdrawn_at = $2 WHERE id = $1", voteID, time.Now()); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO vote_changes (user_id, action, vote_id, project_id, to_project_id, cycle_id, comment)
+                 VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)`,
+		userID, action, voteID, projectID, toProjectID, cycleID, comment,
+	)
+	if err != nil {
+		return err
+	}
+
+	if receipt != "" {
+		if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindVoid, receipt, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WithdrawBallot retracts the user's ballot in the cycle. Returns
+// pgx.ErrNoRows if there is no active ballot and ErrVoteQuarantined if it
+// is quarantined.
+func (db *Database) WithdrawBallot(cycleID, userID int) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := withdrawBallot(ctx, tx, cycleID, userID, models.VoteChangeBallotWithdraw); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReplaceBallot withdraws the user's current ballot in the cycle and
+// stores b instead, in one transaction.
+func (db *Database) ReplaceBallot(b *models.Ballot) error {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := withdrawBallot(ctx, tx, b.CycleID, b.UserID, models.VoteChangeBallotReplace); err != nil {
+		return err
+	}
+	if err := insertBallot(ctx, tx, b); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func withdrawBallot(ctx context.Context, tx pgx.Tx, cycleID, userID int, action string) error {
+	var ballotID int
+	var receipt string
+	var quarantined bool
+	err := tx.QueryRow(ctx,
+		`SELECT id, COALESCE(receipt, ''), quarantined FROM ballots
+                 WHERE cycle_id = $1 AND user_id = $2 AND withdrawn_at IS NULL
+                 FOR UPDATE`,
+		cycleID, userID,
+	).Scan(&ballotID, &receipt, &quarantined)
+	if err != nil {
+		return err
+	}
+	if quarantined {
+		return ErrVoteQuarantined
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE ballots SET withdrawn_at = $2 WHERE id = $1", ballotID, time.Now()); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO vote_changes (user_id, action, ballot_id, cycle_id) VALUES ($1, $2, $3, $4)",
+		userID, action, ballotID, cycleID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if receipt != "" {
+		if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindVoid, receipt, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) GetUserVoteChanges(userID int) ([]models.VoteChange, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT c.id, c.user_id, c.action, c.vote_id, c.ballot_id,
+                        c.project_id, COALESCE(p.title, ''), c.to_project_id, COALESCE(tp.title, ''),
+                        c.cycle_id, COALESCE(bc.title, ''), COALESCE(c.comment, ''), c.created_at
+                 FROM vote_changes c
+                 LEFT JOIN projects p ON c.project_id = p.id
+                 LEFT JOIN projects tp ON c.to_project_id = tp.id
+                 LEFT JOIN budget_cycles bc ON c.cycle_id = bc.id
+                 WHERE c.user_id = $1
+                 ORDER BY c.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.VoteChange
+	for rows.Next() {
+		var c models.VoteChange
+		err := rows.Scan(&c.ID, &c.UserID, &c.Action, &c.VoteID, &c.BallotID,
+			&c.ProjectID, &c.ProjectTitle, &c.ToProjectID, &c.ToProjectTitle,
+			&c.CycleID, &c.CycleTitle, &c.Comment, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
+
+// GetUserVotedProjects lists the projects still in voting that the user has
+// an active vote for, i.e. the ones a vote can be moved from. Only ID,
+// Title and CycleID are filled in.
+func (db *Database) GetUserVotedProjects(userID int) ([]models.Project, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT p.id, p.title, p.cycle_id
+                 FROM votes v
+                 JOIN projects p ON v.project_id = p.id
+                 WHERE v.user_id = $1 AND v.withdrawn_at IS NULL AND p.status = 'voting'
+                 ORDER BY p.title`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		var p models.Project
+		if err := rows.Scan(&p.ID, &p.Title, &p.CycleID); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, nil
+}
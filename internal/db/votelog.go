@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/votelog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrCycleSealed    = errors.New("cycle log is sealed")
+	ErrCycleNotClosed = errors.New("cycle voting has not ended")
+)
+
+// appendVoteLog adds an entry to the cycle's chain inside tx. The table
+// lock serialises writers so two entries can never claim the same seq or
+// previous hash; it is held only until tx ends.
+func appendVoteLog(ctx context.Context, tx pgx.Tx, cycleID int, kind, receipt string, entries []models.BallotEntry) (*votelog.Entry, error) {
+	if _, err := tx.Exec(ctx, "LOCK TABLE vote_log IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return nil, err
+	}
+
+	if cycleID != 0 {
+		var sealed bool
+		err := tx.QueryRow(ctx, "SELECT sealed_at IS NOT NULL FROM budget_cycles WHERE id = $1", cycleID).Scan(&sealed)
+		if err != nil {
+			return nil, err
+		}
+		if sealed {
+			return nil, ErrCycleSealed
+		}
+	}
+
+	prev, err := scanVoteLogEntry(tx.QueryRow(ctx,
+		"SELECT "+voteLogColumns+" FROM vote_log WHERE cycle_id = $1 ORDER BY seq DESC LIMIT 1",
+		cycleID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		prev = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = []models.BallotEntry{}
+	}
+	e := votelog.Next(prev, cycleID, kind, receipt, entries)
+	encoded, err := json.Marshal(e.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO vote_log (cycle_id, seq, kind, receipt, entries, prev_hash, hash)
+                 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.CycleID, e.Seq, e.Kind, e.Receipt, encoded, e.PrevHash, e.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// projectCycleID returns the cycle whose chain records votes for the
+// project; projects outside any cycle share chain 0.
+func projectCycleID(ctx context.Context, tx pgx.Tx, projectID int) (int, error) {
+	var cycleID int
+	err := tx.QueryRow(ctx, "SELECT COALESCE(cycle_id, 0) FROM projects WHERE id = $1", projectID).Scan(&cycleID)
+	return cycleID, err
+}
+
+const voteLogColumns = "seq, cycle_id, kind, receipt, entries, prev_hash, hash"
+
+func scanVoteLogEntry(row pgx.Row) (*votelog.Entry, error) {
+	var e votelog.Entry
+	var entries []byte
+	if err := row.Scan(&e.Seq, &e.CycleID, &e.Kind, &e.Receipt, &entries, &e.PrevHash, &e.Hash); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(entries, &e.Entries); err != nil {
+		return nil, err
+	}
+	if len(e.Entries) == 0 {
+		e.Entries = nil
+	}
+	return &e, nil
+}
+
+func (db *Database) GetVoteLog(cycleID int) ([]votelog.Entry, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+voteLogColumns+" FROM vote_log WHERE cycle_id = $1 ORDER BY seq",
+		cycleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []votelog.Entry
+	for rows.Next() {
+		e, err := scanVoteLogEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+
+	return entries, nil
+}
+
+// GetVoteLogByReceipt returns every entry carrying the receipt: the cast
+// entry and any later void or restore.
+func (db *Database) GetVoteLogByReceipt(receipt string) ([]votelog.Entry, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+voteLogColumns+" FROM vote_log WHERE receipt = $1 ORDER BY cycle_id, seq",
+		receipt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []votelog.Entry
+	for rows.Next() {
+		e, err := scanVoteLogEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+
+	return entries, nil
+}
+
+func (db *Database) GetUserVoteReceipt(projectID, userID int) (string, error) {
+	ctx := context.Background()
+	var receipt string
+
+	err := db.Pool.QueryRow(ctx,
+		"SELECT COALESCE(receipt, '') FROM votes WHERE project_id = $1 AND user_id = $2 AND withdrawn_at IS NULL",
+		projectID, userID,
+	).Scan(&receipt)
+
+	return receipt, err
+}
+
+// SealCycle publishes the cycle's root hash: the hash of the last log
+// entry. After sealing no entry can be appended to the chain.
+func (db *Database) SealCycle(cycleID int) (*models.BudgetCycle, error) {
+	ctx := context.Background()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "LOCK TABLE vote_log IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return nil, err
+	}
+
+	cycle, err := scanCycle(tx.QueryRow(ctx, "SELECT "+cycleColumns+" FROM budget_cycles WHERE id = $1 FOR UPDATE", cycleID))
+	if err != nil {
+		return nil, err
+	}
+	if cycle.SealedAt != nil {
+		return nil, ErrCycleSealed
+	}
+	now := time.Now()
+	if cycle.VoteEnd == nil || now.Before(*cycle.VoteEnd) {
+		return nil, ErrCycleNotClosed
+	}
+
+	root := votelog.GenesisHash
+	seq := 0
+	err = tx.QueryRow(ctx,
+		"SELECT seq, hash FROM vote_log WHERE cycle_id = $1 ORDER BY seq DESC LIMIT 1",
+		cycleID,
+	).Scan(&seq, &root)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE budget_cycles SET root_hash = $1, root_seq = $2, sealed_at = $3 WHERE id = $4",
+		root, seq, now, cycleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cycle.RootHash, cycle.RootSeq, cycle.SealedAt = root, seq, &now
+	return cycle, tx.Commit(ctx)
+}
+
+// BackfillVoteLog gives receipts to votes and ballots stored before the
+// log existed and appends them in the order they were cast. Withdrawn and
+// quarantined votes and ballots are appended and voided straight away so
+// the log replays to the same count as the tables.
+func (db *Database) BackfillVoteLog() error {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		`SELECT 'vote', v.id, COALESCE(p.cycle_id, 0), COALESCE(v.quarantined, FALSE) OR v.withdrawn_at IS NOT NULL, v.created_at
+                 FROM votes v JOIN projects p ON v.project_id = p.id
+                 WHERE v.receipt IS NULL
+                 UNION ALL
+                 SELECT 'ballot', b.id, b.cycle_id, b.quarantined OR b.withdrawn_at IS NOT NULL, b.created_at
+                 FROM ballots b
+                 WHERE b.receipt IS NULL
+                 ORDER BY 5, 2`,
+	)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		table     string
+		id        int
+		cycleID   int
+		void      bool
+		createdAt time.Time
+	}
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.table, &p.id, &p.cycleID, &p.void, &p.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range items {
+		if err := db.backfillVoteLogItem(ctx, p.table, p.id, p.cycleID, p.void); err != nil {
+			if errors.Is(err, ErrCycleSealed) {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) backfillVoteLogItem(ctx context.Context, table string, id, cycleID int, void bool) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	receipt, err := votelog.NewReceipt()
+	if err != nil {
+		return err
+	}
+
+	var entries []models.BallotEntry
+	if table == "vote" {
+		var projectID int
+		if err := tx.QueryRow(ctx, "UPDATE votes SET receipt = $1 WHERE id = $2 RETURNING project_id", receipt, id).Scan(&projectID); err != nil {
+			return err
+		}
+		entries = []models.BallotEntry{{ProjectID: projectID, Value: 1}}
+	} else {
+		if _, err := tx.Exec(ctx, "UPDATE ballots SET receipt = $1 WHERE id = $2", receipt, id); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, "SELECT project_id, value FROM ballot_entries WHERE ballot_id = $1 ORDER BY project_id", id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var e models.BallotEntry
+			if err := rows.Scan(&e.ProjectID, &e.Value); err != nil {
+				rows.Close()
+				return err
+			}
+			entries = append(entries, e)
+		}
+		rows.Close()
+	}
+
+	if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindCast, receipt, entries); err != nil {
+		return err
+	}
+	if void {
+		if _, err := appendVoteLog(ctx, tx, cycleID, votelog.KindVoid, receipt, nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,365 @@
+// Package fraud scores votes, ballots and accounts for signs of vote
+// stuffing. It works on plain data loaded by the db package and has no side
+// effects; quarantining is left to administrators.
+package fraud
+
+import (
+	"petropavlovsk-budget/internal/minhash"
+	"sort"
+	"time"
+)
+
+// Ref identifies what a quarantine acts on: a single vote or a whole
+// ballot.
+type Ref struct {
+	Ballot bool
+	ID     int
+}
+
+// VoteRecord is one vote together with what we know about the voter. A
+// ballot is passed as one record per entry, all sharing BallotID and with
+// VoteID zero, so the per-project checks see each project it supports.
+type VoteRecord struct {
+	VoteID          int
+	BallotID        int
+	ProjectID       int
+	ProjectTitle    string
+	ProjectAuthorID int
+	UserID          int
+	UserEmail       string
+	UserCreatedAt   time.Time
+	RegistrationIP  string
+	RegistrationUA  string
+	VoteIP          string
+	VoteUA          string
+	Comment         string
+	Fingerprint     minhash.Signature
+	DuplicateOf     int
+	CreatedAt       time.Time
+	Quarantined     bool
+}
+
+// Ref returns the vote or ballot the record belongs to.
+func (r VoteRecord) Ref() Ref {
+	if r.BallotID != 0 {
+		return Ref{Ballot: true, ID: r.BallotID}
+	}
+	return Ref{ID: r.VoteID}
+}
+
+type Signal struct {
+	Code   string
+	Label  string
+	Points int
+}
+
+var (
+	SignalRegistrationBurst = Signal{"registration_burst", "Массовая регистрация с одного IP", 25}
+	SignalSharedIP          = Signal{"shared_ip", "Несколько голосующих за проект с одного IP", 25}
+	SignalSharedDevice      = Signal{"shared_device", "Совпадают IP и браузер с другим голосующим", 20}
+	SignalSimilarComment    = Signal{"similar_comment", "Почти одинаковый комментарий у другого пользователя", 35}
+	SignalSingleAuthor      = Signal{"single_author", "Голосует только за проекты одного автора", 15}
+	SignalFastVote          = Signal{"fast_vote", "Голос отдан сразу после регистрации", 20}
+)
+
+// Thresholds are exported so the admin report can explain them.
+const (
+	BurstWindow        = time.Hour
+	BurstMinAccounts   = 3
+	SharedIPMinVoters  = 3
+	FastVoteWindow     = 5 * time.Minute
+	SingleAuthorMin    = 3
+	SuspiciousMinScore = 30
+)
+
+// VoteScore is the score of one vote or ballot. Vote is its first record;
+// Projects lists every project it supports.
+type VoteScore struct {
+	Vote     VoteRecord
+	Projects []Project
+	Score    int
+	Signals  []Signal
+}
+
+type Project struct {
+	ID    int
+	Title string
+}
+
+type AccountScore struct {
+	UserID    int
+	UserEmail string
+	Score     int
+	Signals   []Signal
+	Refs      []Ref
+}
+
+type Report struct {
+	Votes    []VoteScore
+	Accounts []AccountScore
+}
+
+// Analyze scores every vote and ballot and rolls the scores up per account.
+// A ballot gets one score however many projects it supports. Only entries
+// reaching SuspiciousMinScore are returned, highest score first.
+func Analyze(records []VoteRecord) Report {
+	signals := make(map[Ref]map[string]Signal, len(records))
+	add := func(ref Ref, s Signal) {
+		if signals[ref] == nil {
+			signals[ref] = map[string]Signal{}
+		}
+		signals[ref][s.Code] = s
+	}
+
+	burstUsers := registrationBursts(records)
+	for _, r := range records {
+		if burstUsers[r.UserID] {
+			add(r.Ref(), SignalRegistrationBurst)
+		}
+		if !r.UserCreatedAt.IsZero() && r.CreatedAt.Sub(r.UserCreatedAt) < FastVoteWindow {
+			add(r.Ref(), SignalFastVote)
+		}
+	}
+
+	byProject := map[int][]VoteRecord{}
+	for _, r := range records {
+		byProject[r.ProjectID] = append(byProject[r.ProjectID], r)
+	}
+	for _, votes := range byProject {
+		byIP := map[string]map[int]bool{}
+		for _, v := range votes {
+			if v.VoteIP == "" {
+				continue
+			}
+			if byIP[v.VoteIP] == nil {
+				byIP[v.VoteIP] = map[int]bool{}
+			}
+			byIP[v.VoteIP][v.UserID] = true
+		}
+		for i, a := range votes {
+			if a.VoteIP != "" && len(byIP[a.VoteIP]) >= SharedIPMinVoters {
+				add(a.Ref(), SignalSharedIP)
+			}
+			for _, b := range votes[i+1:] {
+				if a.UserID == b.UserID {
+					continue
+				}
+				if a.VoteIP != "" && a.VoteIP == b.VoteIP && a.VoteUA != "" && a.VoteUA == b.VoteUA {
+					add(a.Ref(), SignalSharedDevice)
+					add(b.Ref(), SignalSharedDevice)
+				}
+			}
+		}
+	}
+
+	for _, pair := range similarComments(records) {
+		add(Ref{ID: pair[0]}, SignalSimilarComment)
+		add(Ref{ID: pair[1]}, SignalSimilarComment)
+	}
+
+	for _, refs := range singleAuthorVoters(records) {
+		for _, ref := range refs {
+			add(ref, SignalSingleAuthor)
+		}
+	}
+
+	var order []Ref
+	scores := map[Ref]*VoteScore{}
+	for _, r := range records {
+		ref := r.Ref()
+		if vs := scores[ref]; vs != nil {
+			vs.Projects = append(vs.Projects, Project{r.ProjectID, r.ProjectTitle})
+			continue
+		}
+		vs := &VoteScore{Vote: r, Projects: []Project{{r.ProjectID, r.ProjectTitle}}}
+		for _, s := range signals[ref] {
+			vs.Score += s.Points
+			vs.Signals = append(vs.Signals, s)
+		}
+		sortSignals(vs.Signals)
+		scores[ref] = vs
+		order = append(order, ref)
+	}
+
+	var report Report
+	accounts := map[int]*AccountScore{}
+	for _, ref := range order {
+		vs := scores[ref]
+		if vs.Score < SuspiciousMinScore {
+			continue
+		}
+		report.Votes = append(report.Votes, *vs)
+
+		r := vs.Vote
+		acc := accounts[r.UserID]
+		if acc == nil {
+			acc = &AccountScore{UserID: r.UserID, UserEmail: r.UserEmail}
+			accounts[r.UserID] = acc
+		}
+		acc.Refs = append(acc.Refs, ref)
+		acc.Score += vs.Score
+		for _, s := range vs.Signals {
+			if !hasSignal(acc.Signals, s.Code) {
+				acc.Signals = append(acc.Signals, s)
+			}
+		}
+	}
+
+	for _, acc := range accounts {
+		sortSignals(acc.Signals)
+		report.Accounts = append(report.Accounts, *acc)
+	}
+
+	sort.Slice(report.Votes, func(i, j int) bool {
+		if report.Votes[i].Score != report.Votes[j].Score {
+			return report.Votes[i].Score > report.Votes[j].Score
+		}
+		return report.Votes[i].Vote.CreatedAt.Before(report.Votes[j].Vote.CreatedAt)
+	})
+	sort.Slice(report.Accounts, func(i, j int) bool {
+		if report.Accounts[i].Score != report.Accounts[j].Score {
+			return report.Accounts[i].Score > report.Accounts[j].Score
+		}
+		return report.Accounts[i].UserID < report.Accounts[j].UserID
+	})
+
+	return report
+}
+
+// registrationBursts returns users registered from an IP that produced at
+// least BurstMinAccounts accounts within BurstWindow.
+func registrationBursts(records []VoteRecord) map[int]bool {
+	type account struct {
+		id      int
+		created time.Time
+	}
+	byIP := map[string][]account{}
+	seen := map[int]bool{}
+	for _, r := range records {
+		if r.RegistrationIP == "" || seen[r.UserID] {
+			continue
+		}
+		seen[r.UserID] = true
+		byIP[r.RegistrationIP] = append(byIP[r.RegistrationIP], account{r.UserID, r.UserCreatedAt})
+	}
+
+	result := map[int]bool{}
+	for _, accounts := range byIP {
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i].created.Before(accounts[j].created) })
+		start := 0
+		for end := range accounts {
+			for accounts[end].created.Sub(accounts[start].created) > BurstWindow {
+				start++
+			}
+			if end-start+1 >= BurstMinAccounts {
+				for _, a := range accounts[start : end+1] {
+					result[a.id] = true
+				}
+			}
+		}
+	}
+	return result
+}
+
+// similarComments returns pairs of vote IDs from different users whose
+// comment fingerprints are at least minhash.DuplicateThreshold similar.
+// Candidates are found through shared LSH bands rather than comparing every
+// pair. Votes flagged as duplicates on insert are paired with their source.
+// Ballots carry no comment and are skipped.
+func similarComments(records []VoteRecord) [][2]int {
+	sigs := make([]minhash.Signature, len(records))
+	buckets := map[int64][]int{}
+	for i, r := range records {
+		if r.BallotID != 0 {
+			continue
+		}
+		sigs[i] = r.Fingerprint
+		if len(sigs[i]) != minhash.NumHashes {
+			sigs[i] = minhash.Compute(r.Comment)
+		}
+		for _, key := range minhash.BandKeys(sigs[i]) {
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	seen := map[[2]int]bool{}
+	var pairs [][2]int
+	addPair := func(a, b int) {
+		if a > b {
+			a, b = b, a
+		}
+		if !seen[[2]int{a, b}] {
+			seen[[2]int{a, b}] = true
+			pairs = append(pairs, [2]int{a, b})
+		}
+	}
+
+	for _, idx := range buckets {
+		for x := 0; x < len(idx); x++ {
+			for y := x + 1; y < len(idx); y++ {
+				a, b := records[idx[x]], records[idx[y]]
+				if a.UserID == b.UserID {
+					continue
+				}
+				if minhash.Similarity(sigs[idx[x]], sigs[idx[y]]) >= minhash.DuplicateThreshold {
+					addPair(a.VoteID, b.VoteID)
+				}
+			}
+		}
+	}
+
+	for _, r := range records {
+		if r.DuplicateOf != 0 {
+			addPair(r.VoteID, r.DuplicateOf)
+		}
+	}
+
+	return pairs
+}
+
+// singleAuthorVoters returns, per user, the votes and ballots of users who
+// supported at least SingleAuthorMin projects, all of the same author. Each
+// ballot entry counts as one supported project.
+func singleAuthorVoters(records []VoteRecord) map[int][]Ref {
+	authors := map[int]map[int]bool{}
+	supported := map[int]int{}
+	refs := map[int][]Ref{}
+	seen := map[Ref]bool{}
+	for _, r := range records {
+		if authors[r.UserID] == nil {
+			authors[r.UserID] = map[int]bool{}
+		}
+		authors[r.UserID][r.ProjectAuthorID] = true
+		supported[r.UserID]++
+		if !seen[r.Ref()] {
+			seen[r.Ref()] = true
+			refs[r.UserID] = append(refs[r.UserID], r.Ref())
+		}
+	}
+
+	result := map[int][]Ref{}
+	for userID, a := range authors {
+		if len(a) == 1 && supported[userID] >= SingleAuthorMin {
+			result[userID] = refs[userID]
+		}
+	}
+	return result
+}
+
+func hasSignal(signals []Signal, code string) bool {
+	for _, s := range signals {
+		if s.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func sortSignals(signals []Signal) {
+	sort.Slice(signals, func(i, j int) bool {
+		if signals[i].Points != signals[j].Points {
+			return signals[i].Points > signals[j].Points
+		}
+		return signals[i].Code < signals[j].Code
+	})
+}
@@ -0,0 +1,84 @@
+package fraud
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyzeScoresBallotsOnce(t *testing.T) {
+	registered := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	cast := registered.Add(time.Minute)
+
+	var records []VoteRecord
+	// Three accounts registered together from one IP each cast a ballot
+	// for the same two projects. Ballot 1 shares its number with vote 1 of
+	// an unrelated voter, which must not pick up the ballot's signals.
+	for user := 1; user <= 3; user++ {
+		for _, project := range []int{10, 11} {
+			records = append(records, VoteRecord{
+				BallotID:       user,
+				ProjectID:      project,
+				ProjectTitle:   "Проект",
+				UserID:         user,
+				UserCreatedAt:  registered.Add(time.Duration(user) * time.Minute),
+				RegistrationIP: "198.51.100.1",
+				VoteIP:         "198.51.100.1",
+				CreatedAt:      cast.Add(time.Duration(user) * time.Minute),
+			})
+		}
+	}
+	records = append(records, VoteRecord{
+		VoteID:        1,
+		ProjectID:     12,
+		UserID:        4,
+		UserCreatedAt: registered.Add(-30 * 24 * time.Hour),
+		VoteIP:        "203.0.113.9",
+		Comment:       "Нужна новая площадка во дворе",
+		CreatedAt:     cast,
+	})
+
+	report := Analyze(records)
+
+	if len(report.Votes) != 3 {
+		t.Fatalf("got %d scored entries, want one per ballot: %+v", len(report.Votes), report.Votes)
+	}
+	for _, vs := range report.Votes {
+		if vs.Vote.BallotID == 0 {
+			t.Errorf("vote %d flagged", vs.Vote.VoteID)
+			continue
+		}
+		if len(vs.Projects) != 2 {
+			t.Errorf("ballot %d lists %d projects, want 2", vs.Vote.BallotID, len(vs.Projects))
+		}
+		for _, code := range []string{SignalRegistrationBurst.Code, SignalSharedIP.Code, SignalFastVote.Code} {
+			if !hasSignal(vs.Signals, code) {
+				t.Errorf("ballot %d lacks %s", vs.Vote.BallotID, code)
+			}
+		}
+		want := SignalRegistrationBurst.Points + SignalSharedIP.Points + SignalFastVote.Points
+		if vs.Score != want {
+			t.Errorf("ballot %d scored %d, want %d: signals counted per entry?", vs.Vote.BallotID, vs.Score, want)
+		}
+	}
+
+	if len(report.Accounts) != 3 {
+		t.Fatalf("got %d accounts, want 3", len(report.Accounts))
+	}
+	for _, acc := range report.Accounts {
+		if len(acc.Refs) != 1 || !acc.Refs[0].Ballot {
+			t.Errorf("account %d refs = %+v, want its one ballot", acc.UserID, acc.Refs)
+		}
+	}
+}
+
+func TestSingleAuthorCountsBallotEntries(t *testing.T) {
+	var records []VoteRecord
+	for _, project := range []int{1, 2, 3} {
+		records = append(records, VoteRecord{BallotID: 7, ProjectID: project, ProjectAuthorID: 42, UserID: 5})
+	}
+
+	got := singleAuthorVoters(records)
+	if refs := got[5]; len(refs) != 1 || refs[0] != (Ref{Ballot: true, ID: 7}) {
+		t.Errorf("singleAuthorVoters = %+v, want ballot 7 once", got)
+	}
+}
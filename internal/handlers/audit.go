@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"petropavlovsk-budget/internal/csvexport"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	auditPageSize = 200
+	// maxAuditExport bounds one CSV download; narrow the filter for more.
+	maxAuditExport = 100000
+	// maxAuditValue bounds each form value kept by AuditAdmin.
+	maxAuditValue = 500
+)
+
+type auditedKey struct{}
+
+// auditSecretFields are the form fields AuditAdmin never stores, matched
+// as substrings of the field name: paper ballot CSVs carry IINs too.
+var auditSecretFields = []string{"password", "iin", "token", "csv"}
+
+// audit records an action by the signed-in user on the target. before and
+// after are snapshots marshalled to JSON, or nil. A failure to write the
+// entry is logged rather than shown: the action itself has happened.
+func (h *Handler) audit(r *http.Request, action, targetType string, targetID int, before, after interface{}) {
+	session, _ := h.Store.Get(r, "session")
+	var actorID *int
+	if id, ok := session.Values["user_id"].(int); ok {
+		actorID = &id
+	}
+	actorName, _ := session.Values["email"].(string)
+	h.auditAs(r, actorID, actorName, action, targetType, targetID, before, after)
+}
+
+// auditAs is audit for requests whose actor is not (yet) in the session,
+// such as logins.
+func (h *Handler) auditAs(r *http.Request, actorID *int, actorName, action, targetType string, targetID int, before, after interface{}) {
+	if done, ok := r.Context().Value(auditedKey{}).(*bool); ok {
+		*done = true
+	}
+
+	entry := &models.AuditEntry{
+		ActorID:    actorID,
+		ActorName:  actorName,
+		Action:     action,
+		TargetType: targetType,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	if targetID != 0 {
+		entry.TargetID = strconv.Itoa(targetID)
+	}
+	if err := h.DB.AddAuditEntry(entry); err != nil {
+		log.Printf("audit: %s by %q: %v", action, actorName, err)
+	}
+}
+
+func auditJSON(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// AuditAdmin is middleware for the admin routes: every POST whose handler
+// did not record its own, more detailed entry is logged with its route,
+// form values (secrets removed) and outcome.
+func (h *Handler) AuditAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		done := false
+		r = r.WithContext(context.WithValue(r.Context(), auditedKey{}, &done))
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if done {
+			return
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		after := map[string]interface{}{
+			"form":    auditForm(r),
+			"status":  status,
+			"refused": status >= 400 || ww.Header().Get("HX-Retarget") != "",
+		}
+
+		targetID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		if targetID == 0 {
+			targetID, _ = strconv.Atoi(r.FormValue("project_id"))
+		}
+		action := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+		h.audit(r, action, "", targetID, nil, after)
+	})
+}
+
+// auditForm returns the request's form values as parsed by the handler,
+// shortened and without secrets. Uploaded files are not form values.
+func auditForm(r *http.Request) map[string][]string {
+	form := map[string][]string{}
+values:
+	for key, values := range r.Form {
+		for _, secret := range auditSecretFields {
+			if strings.Contains(strings.ToLower(key), secret) {
+				form[key] = []string{"[скрыто]"}
+				continue values
+			}
+		}
+		for _, v := range values {
+			if len(v) > maxAuditValue {
+				cut := maxAuditValue
+				for cut > 0 && !utf8.RuneStart(v[cut]) {
+					cut--
+				}
+				v = v[:cut] + "…"
+			}
+			form[key] = append(form[key], v)
+		}
+	}
+	return form
+}
+
+// auditTargetTypes are the kinds of object entries refer to.
+var auditTargetTypes = []string{"project", "user", "verification", "draft", "comment"}
+
+// auditFilter reads the filter from the query string; dates are whole
+// days, both ends included.
+func auditFilter(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Actor:      strings.TrimSpace(q.Get("actor")),
+		Action:     q.Get("action"),
+		TargetType: strings.TrimSpace(q.Get("target_type")),
+		TargetID:   strings.TrimSpace(q.Get("target_id")),
+	}
+	if from, err := time.ParseInLocation("2006-01-02", q.Get("from"), time.Local); err == nil {
+		f.From = &from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", q.Get("to"), time.Local); err == nil {
+		to = to.AddDate(0, 0, 1)
+		f.To = &to
+	}
+	return f
+}
+
+// AdminAuditLog shows the latest audit entries matching the filter.
+func (h *Handler) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	f := auditFilter(r)
+	entries, err := h.DB.GetAuditLog(f, auditPageSize)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки журнала", http.StatusInternalServerError)
+		return
+	}
+	actions, _ := h.DB.GetAuditActions()
+
+	data := map[string]interface{}{
+		"LoggedIn":    true,
+		"IsAdmin":     true,
+		"Entries":     entries,
+		"Actions":     actions,
+		"Filter":      f,
+		"From":        r.URL.Query().Get("from"),
+		"To":          r.URL.Query().Get("to"),
+		"CSVURL":      template.URL("/admin/audit.csv?" + r.URL.Query().Encode()),
+		"PageSize":    auditPageSize,
+		"TargetTypes": auditTargetTypes,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_audit.html", data)
+}
+
+// AdminAuditCSV exports the entries matching the filter. Downloading the
+// log is itself recorded. Names, details and user agents are written by
+// users, so cells are escaped against formula injection.
+func (h *Handler) AdminAuditCSV(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.DB.GetAuditLog(auditFilter(r), maxAuditExport)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки журнала", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "audit.export", "", 0, nil, map[string]interface{}{"filter": r.URL.RawQuery, "rows": len(entries)})
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("2006-01-02")+`.csv"`)
+	out := csvexport.NewWriter(w)
+	out.Write([]string{"id", "created_at", "actor_id", "actor_name", "action", "target_type", "target_id", "before", "after", "ip", "user_agent"})
+	for _, e := range entries {
+		actorID := ""
+		if e.ActorID != nil {
+			actorID = strconv.Itoa(*e.ActorID)
+		}
+		out.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			actorID,
+			e.ActorName,
+			e.Action,
+			e.TargetType,
+			e.TargetID,
+			string(e.Before),
+			string(e.After),
+			e.IP,
+			e.UserAgent,
+		})
+	}
+	out.Flush()
+}
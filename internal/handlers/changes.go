@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"petropavlovsk-budget/internal/models"
+	"strings"
+)
+
+const maxChangeItems = 20
+
+// changeItems turns the moderator's list, one change per line, into items;
+// list markers typed or pasted in front of a line are dropped.
+func changeItems(text string) []string {
+	var items []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-•*"))
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// requestChanges handles the "return to author" outcome of moderation.
+func (h *Handler) requestChanges(w http.ResponseWriter, r *http.Request, projectID, adminID int) {
+	items := changeItems(r.FormValue("changes"))
+	if len(items) == 0 {
+		writeError(w, "#error", "Перечислите, что автору нужно изменить")
+		return
+	}
+	if len(items) > maxChangeItems {
+		writeError(w, "#error", "Слишком много пунктов, объедините похожие")
+		return
+	}
+
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	err := h.DB.RequestChanges(projectID, adminID, items, comment)
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	h.audit(r, "project.status", "project", projectID,
+		map[string]string{"status": "moderation"},
+		map[string]interface{}{"status": "changes_requested", "changes": items, "comment": comment})
+
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+// resubmission is a project back in the moderation queue after its author
+// answered a change request, with what the author changed since.
+type resubmission struct {
+	Request *models.ChangeRequest
+	Diff    projectDiff
+}
+
+func (h *Handler) resubmissions(queue []models.ModerationItem) map[int]resubmission {
+	requests, err := h.DB.GetResubmissions()
+	if err != nil {
+		return nil
+	}
+	result := map[int]resubmission{}
+	for i := range queue {
+		if req, ok := requests[queue[i].ID]; ok {
+			result[queue[i].ID] = resubmission{
+				Request: req,
+				Diff:    diffProject(req.Before, projectFields(&queue[i].Project)),
+			}
+		}
+	}
+	return result
+}
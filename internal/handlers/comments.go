@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	minCommentLength = 50
+	minReplyLength   = 10
+)
+
+// commentReportReason is a reason a user can give for reporting a
+// comment; the code is stored, the label shown.
+type commentReportReason struct {
+	Code  string
+	Label string
+}
+
+var commentReportReasons = []commentReportReason{
+	{"insult", "Оскорбления или грубость"},
+	{"spam", "Спам или реклама"},
+	{"offtopic", "Не по теме проекта"},
+	{"personal", "Чужие персональные данные"},
+	{"other", "Другое"},
+}
+
+func commentReportLabel(code string) string {
+	for _, r := range commentReportReasons {
+		if r.Code == code {
+			return r.Label
+		}
+	}
+	return code
+}
+
+// visibleComments prepares a discussion for the viewer. Comments held by
+// screening are shown only to their author and admins; the text of
+// hidden comments only to them as well, everyone else sees a placeholder.
+func visibleComments(comments []models.Comment, userID interface{}, isAdmin bool) []models.Comment {
+	viewerID, _ := userID.(int)
+	var visible []models.Comment
+	for _, c := range comments {
+		own := viewerID != 0 && c.UserID == viewerID
+		switch c.Status {
+		case "held":
+			if !own && !isAdmin {
+				continue
+			}
+		case "hidden":
+			if !own && !isAdmin {
+				c.Content = ""
+			}
+		}
+		visible = append(visible, c)
+	}
+	return visible
+}
+
+// commentRoleBadges mark comments by city staff in a discussion.
+var commentRoleBadges = map[string]string{
+	"admin":       "Администрация",
+	"implementer": "Исполнитель",
+	"department":  "Акимат",
+}
+
+// commentNode is a comment as shown in a discussion thread, with what the
+// viewer may do with it.
+type commentNode struct {
+	models.Comment
+	Badges        []string
+	Replies       []*commentNode
+	CanReply      bool
+	CanReport     bool
+	CanReact      bool
+	CanModerate   bool
+	ReportReasons []commentReportReason
+}
+
+// commentThreads arranges the visible comments into threads. Top-level
+// comments are sorted newest first or, with sortBy "useful", most useful
+// first; replies always read oldest first.
+func commentThreads(comments []models.Comment, project *models.Project, userID interface{}, isAdmin bool, sortBy string) []*commentNode {
+	viewerID, _ := userID.(int)
+	nodes := map[int]*commentNode{}
+	for _, c := range comments {
+		published := c.Status == "published"
+		node := &commentNode{
+			Comment:       c,
+			CanReply:      viewerID != 0 && published,
+			CanReport:     viewerID != 0 && published && c.UserID != viewerID,
+			CanReact:      viewerID != 0 && published && c.UserID != viewerID,
+			CanModerate:   isAdmin,
+			ReportReasons: commentReportReasons,
+		}
+		if c.UserID == project.UserID {
+			node.Badges = append(node.Badges, "Автор проекта")
+		}
+		if badge, ok := commentRoleBadges[c.UserRole]; ok {
+			node.Badges = append(node.Badges, badge)
+		}
+		nodes[c.ID] = node
+	}
+
+	// comments come newest first; walking them backwards appends replies
+	// in the order they were written.
+	var threads []*commentNode
+	for i := len(comments) - 1; i >= 0; i-- {
+		node := nodes[comments[i].ID]
+		if node.ParentID != nil {
+			if parent, ok := nodes[*node.ParentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		threads = append(threads, node)
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		if sortBy == "useful" && threads[i].Useful != threads[j].Useful {
+			return threads[i].Useful > threads[j].Useful
+		}
+		return threads[i].CreatedAt.After(threads[j].CreatedAt)
+	})
+	return threads
+}
+
+// CommentUseful marks a comment as useful for the signed-in user, or
+// takes the mark back, and returns the updated button.
+func (h *Handler) CommentUseful(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+	commentID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	reacted, count, err := h.DB.ToggleCommentUseful(commentID, userID)
+	if err != nil {
+		http.Error(w, "Не удалось отметить комментарий", http.StatusBadRequest)
+		return
+	}
+
+	node := &commentNode{CanReact: true}
+	node.ID, node.Useful, node.Reacted = commentID, count, reacted
+	h.Templates.ExecuteTemplate(w, "comment-useful", node)
+}
+
+// ReportComment lets a signed-in user flag a comment for the moderators.
+func (h *Handler) ReportComment(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+	commentID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	target := fmt.Sprintf("#report-%d", commentID)
+
+	reason := r.FormValue("reason")
+	if commentReportLabel(reason) == reason {
+		writeError(w, target, "Выберите причину жалобы")
+		return
+	}
+
+	err := h.DB.ReportComment(commentID, userID, reason)
+	switch {
+	case errors.Is(err, db.ErrCommentReported):
+		writeError(w, target, "Вы уже пожаловались на этот комментарий")
+		return
+	case errors.Is(err, db.ErrCommentOwn):
+		writeError(w, target, "Нельзя пожаловаться на свой комментарий")
+		return
+	case errors.Is(err, db.ErrCommentNotFound), errors.Is(err, db.ErrCommentNotPublished):
+		writeError(w, target, "Комментарий уже проверяется модератором")
+		return
+	case err != nil:
+		writeError(w, target, "Ошибка отправки жалобы")
+		return
+	}
+
+	w.Header().Set("HX-Retarget", target)
+	w.Header().Set("HX-Reswap", "innerHTML")
+	w.Write([]byte(`<div class="text-green-700 text-sm">Спасибо, жалоба передана модераторам</div>`))
+}
+
+// AdminComments lists comments held by screening or reported by users.
+func (h *Handler) AdminComments(w http.ResponseWriter, r *http.Request) {
+	queue, err := h.DB.GetCommentQueue()
+	if err != nil {
+		http.Error(w, "Ошибка загрузки комментариев", http.StatusInternalServerError)
+		return
+	}
+	for i := range queue {
+		for j, code := range queue[i].ReportReasons {
+			queue[i].ReportReasons[j] = commentReportLabel(code)
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Queue":    queue,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_comments.html", data)
+}
+
+// commentActions maps the moderator's buttons to the status they set.
+var commentActions = map[string]string{
+	"publish": "published",
+	"hide":    "hidden",
+	"delete":  "deleted",
+}
+
+// AdminModerateComment publishes, hides or deletes a comment. Hiding and
+// deleting need a reason, which the author is sent. Publishing a reported
+// comment dismisses its reports.
+func (h *Handler) AdminModerateComment(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+	commentID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	target := fmt.Sprintf("#comment-error-%d", commentID)
+
+	action := r.FormValue("action")
+	status, ok := commentActions[action]
+	if !ok {
+		writeError(w, target, "Неизвестное действие")
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if status != "published" && reason == "" {
+		writeError(w, target, "Укажите причину: её увидит автор комментария")
+		return
+	}
+
+	before, err := h.DB.ModerateComment(commentID, adminID, status, reason)
+	switch {
+	case errors.Is(err, db.ErrCommentNotFound):
+		writeError(w, target, "Комментарий не найден")
+		return
+	case errors.Is(err, db.ErrCommentDeleted):
+		writeError(w, target, "Комментарий уже удалён")
+		return
+	case err != nil:
+		writeError(w, target, "Ошибка модерации комментария")
+		return
+	}
+
+	// Deleted text stays out of the audit log too.
+	h.audit(r, "comment."+action, "comment", commentID,
+		map[string]interface{}{"status": before.Status, "project_id": before.ProjectID},
+		map[string]interface{}{"status": status, "reason": reason})
+	if status == "published" {
+		h.DB.CheckAndUnlockAchievements(before.UserID)
+	}
+
+	// Moderators act from the queue or the project page; either reloads.
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/tally"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+func parseDatetimeLocal(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(datetimeLocalLayout, value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (h *Handler) AdminCycles(w http.ResponseWriter, r *http.Request) {
+	cycles, _ := h.DB.ListCycles()
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Cycles":   cycles,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_cycles.html", data)
+}
+
+func (h *Handler) AdminSaveCycle(w http.ResponseWriter, r *http.Request) {
+	cycleID, _ := strconv.Atoi(r.FormValue("cycle_id"))
+	title := strings.TrimSpace(r.FormValue("title"))
+	ballotType := r.FormValue("ballot_type")
+
+	budget, err := strconv.Atoi(r.FormValue("budget"))
+	if err != nil || budget <= 0 {
+		writeError(w, "#error", "Некорректный бюджет цикла")
+		return
+	}
+	if title == "" {
+		writeError(w, "#error", "Укажите название цикла")
+		return
+	}
+	maxPicks, _ := strconv.Atoi(r.FormValue("max_picks"))
+	credits, _ := strconv.Atoi(r.FormValue("credits"))
+	switch ballotType {
+	case models.BallotSingle, models.BallotKnapsack:
+		maxPicks, credits = 0, 0
+	case models.BallotApproval, models.BallotRanked:
+		if maxPicks < 0 {
+			writeError(w, "#error", "Некорректное число проектов в бюллетене")
+			return
+		}
+		credits = 0
+	case models.BallotQuadratic:
+		if credits <= 0 {
+			writeError(w, "#error", "Укажите число кредитов для квадратичного голосования")
+			return
+		}
+		maxPicks = 0
+	default:
+		writeError(w, "#error", "Неизвестный тип бюллетеня")
+		return
+	}
+
+	voteStart, err1 := parseDatetimeLocal(r.FormValue("vote_start"))
+	voteEnd, err2 := parseDatetimeLocal(r.FormValue("vote_end"))
+	if err1 != nil || err2 != nil || (voteStart != nil && voteEnd != nil && !voteEnd.After(*voteStart)) {
+		writeError(w, "#error", "Некорректные даты голосования")
+		return
+	}
+
+	cycle := &models.BudgetCycle{
+		ID:         cycleID,
+		Title:      title,
+		Budget:     budget,
+		BallotType: ballotType,
+		MaxPicks:   maxPicks,
+		Credits:    credits,
+		VoteStart:  voteStart,
+		VoteEnd:    voteEnd,
+	}
+
+	if cycleID == 0 {
+		err = h.DB.CreateCycle(cycle)
+	} else {
+		err = h.DB.UpdateCycle(cycle)
+	}
+	if errors.Is(err, db.ErrCycleLocked) {
+		writeError(w, "#error", "После начала голосования бюджет и правила бюллетеня изменить нельзя")
+		return
+	}
+	if err != nil {
+		writeError(w, "#error", "Ошибка сохранения цикла")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/cycles")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) countCycle(cycleID int) (*models.BudgetCycle, []models.Project, tally.Result, error) {
+	cycle, err := h.DB.GetCycle(cycleID)
+	if err != nil {
+		return nil, nil, tally.Result{}, err
+	}
+
+	projects, err := h.DB.GetCycleProjects(cycleID)
+	if err != nil {
+		return nil, nil, tally.Result{}, err
+	}
+
+	var ballots []models.Ballot
+	if cycle.BallotType != models.BallotSingle {
+		ballots, err = h.DB.GetCycleBallots(cycleID)
+		if err != nil {
+			return nil, nil, tally.Result{}, err
+		}
+	}
+
+	return cycle, projects, tally.Count(*cycle, projects, ballots), nil
+}
+
+func (h *Handler) AdminCycleTally(w http.ResponseWriter, r *http.Request) {
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	cycle, projects, result, err := h.countCycle(cycleID)
+	if err != nil {
+		http.Error(w, "Цикл не найден", http.StatusNotFound)
+		return
+	}
+
+	channels, _ := h.DB.GetChannelBreakdown(cycleID)
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Cycle":    cycle,
+		"Projects": projects,
+		"Result":   result,
+		"Channels": channels,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_cycle_tally.html", data)
+}
+
+// AdminApplyTally marks the computed winners as selected once the cycle's
+// voting has ended. Projects that did not win stay in voting so the admin
+// can close them explicitly.
+func (h *Handler) AdminApplyTally(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	cycle, projects, result, err := h.countCycle(cycleID)
+	if err != nil {
+		writeError(w, "#error", "Цикл не найден")
+		return
+	}
+	if !cycle.VotingClosed(time.Now()) {
+		writeError(w, "#error", "Итоги можно применить только после окончания голосования")
+		return
+	}
+
+	var selected []int
+	for _, p := range projects {
+		if p.Status != "voting" || !result.IsWinner(p.ID) {
+			continue
+		}
+		comment := fmt.Sprintf("Победитель цикла «%s» по итогам подсчёта, поддержка: %d", cycle.Title, result.Scores[p.ID])
+		if err := h.DB.UpdateProjectStatus(p.ID, "voting", "selected", adminID, comment); err != nil {
+			writeError(w, "#error", "Ошибка обновления статуса")
+			return
+		}
+		selected = append(selected, p.ID)
+		h.DB.CheckAndUnlockAchievements(p.UserID)
+	}
+	h.audit(r, "cycle.apply_tally", "cycle", cycleID, nil, map[string][]int{"selected": selected})
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/admin/cycles/%d/tally", cycleID))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) BallotPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+	userRole := session.Values["role"]
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	cycle, err := h.DB.GetCycle(cycleID)
+	if err != nil || cycle.BallotType == models.BallotSingle {
+		http.Error(w, "Бюллетень не найден", http.StatusNotFound)
+		return
+	}
+
+	projects, _ := h.DB.GetCycleProjects(cycleID)
+	var voting []models.Project
+	for _, p := range projects {
+		if p.Status == "voting" {
+			voting = append(voting, p)
+		}
+	}
+
+	ballot, _ := h.DB.GetUserBallot(cycleID, userID)
+	verified, _ := h.DB.IsUserVerified(userID)
+
+	// Selected pre-fills the form with the current ballot so it can be
+	// changed while voting is open.
+	selected := map[int]int{}
+	if ballot != nil {
+		for _, e := range ballot.Entries {
+			selected[e.ProjectID] = e.Value
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  userRole == "admin",
+		"Cycle":    cycle,
+		"Projects": voting,
+		"Ballot":   ballot,
+		"Selected": selected,
+		"Verified": verified,
+		"Open":     cycle.VotingOpen(time.Now()),
+	}
+
+	h.Templates.ExecuteTemplate(w, "ballot.html", data)
+}
+
+func (h *Handler) BallotSubmit(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	cycle, err := h.DB.GetCycle(cycleID)
+	if err != nil || cycle.BallotType == models.BallotSingle {
+		writeError(w, "#ballot-error", "Бюллетень не найден")
+		return
+	}
+
+	if !cycle.VotingOpen(time.Now()) {
+		writeError(w, "#ballot-error", "Голосование в этом цикле закрыто")
+		return
+	}
+
+	if verified, _ := h.DB.IsUserVerified(userID); !verified {
+		writeError(w, "#ballot-error", "Голосовать могут только жители, подтвердившие личность")
+		return
+	}
+
+	r.ParseForm()
+	ballot := &models.Ballot{
+		CycleID:    cycleID,
+		UserID:     userID,
+		BallotType: cycle.BallotType,
+		Entries:    parseBallotEntries(r, cycle.BallotType),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+
+	if len(ballot.Entries) == 0 {
+		writeError(w, "#ballot-error", "Выберите хотя бы один проект")
+		return
+	}
+
+	// replace is sent when the voter edits a ballot they already cast.
+	if r.FormValue("replace") != "" {
+		err = h.DB.ReplaceBallot(ballot)
+	} else {
+		err = h.DB.CreateBallot(ballot)
+	}
+	if err != nil {
+		writeError(w, "#ballot-error", ballotErrorMessage(err, cycle))
+		return
+	}
+
+	// Only the fact of a replacement is logged: the audit log must not
+	// reveal how anyone voted.
+	if r.FormValue("replace") != "" {
+		h.audit(r, "ballot.replace", "cycle", cycleID, nil, map[string]int{"ballot_id": ballot.ID})
+	}
+	h.DB.RecomputeAchievements(userID)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/cycles/%d/ballot", cycleID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// ballotErrorMessage explains to the voter why a ballot was not accepted.
+func ballotErrorMessage(err error, cycle *models.BudgetCycle) string {
+	switch {
+	case errors.Is(err, tally.ErrOverBudget):
+		return fmt.Sprintf("Сумма выбранных проектов превышает бюджет цикла (%s ₸)", formatNumber(cycle.Budget))
+	case errors.Is(err, tally.ErrTooManyPicks):
+		return fmt.Sprintf("Можно выбрать не более %d проектов", cycle.MaxPicks)
+	case errors.Is(err, tally.ErrInvalidRanking):
+		return "Места должны идти подряд, начиная с 1, без повторов"
+	case errors.Is(err, tally.ErrOverCredits):
+		return fmt.Sprintf("Потрачено больше кредитов, чем доступно (%d)", cycle.Credits)
+	case errors.Is(err, tally.ErrInvalidEntry):
+		return "Бюллетень заполнен некорректно"
+	case errors.Is(err, db.ErrBallotInvalidItem):
+		return "Один из проектов больше не участвует в голосовании. Обновите страницу"
+	case errors.Is(err, db.ErrBallotExists):
+		return "Вы уже отправили бюллетень в этом цикле"
+	case errors.Is(err, db.ErrVoteQuarantined):
+		return quarantinedMessage
+	}
+	return "Ошибка при сохранении бюллетеня"
+}
+
+// parseBallotEntries reads the ballot form. Knapsack and approval ballots
+// send checked project_id values; ranked ballots send rank_<id> with the
+// place given to the project and quadratic ballots send votes_<id> with the
+// number of votes. Empty and zero fields mean the project was skipped.
+func parseBallotEntries(r *http.Request, ballotType string) []models.BallotEntry {
+	var entries []models.BallotEntry
+
+	switch ballotType {
+	case models.BallotRanked, models.BallotQuadratic:
+		prefix := "rank_"
+		if ballotType == models.BallotQuadratic {
+			prefix = "votes_"
+		}
+		for key := range r.Form {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			id, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+			if err != nil {
+				continue
+			}
+			value, err := strconv.Atoi(r.Form.Get(key))
+			if err != nil || value == 0 {
+				continue
+			}
+			entries = append(entries, models.BallotEntry{ProjectID: id, Value: value})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].ProjectID < entries[j].ProjectID
+		})
+
+	default:
+		seen := map[int]bool{}
+		for _, v := range r.Form["project_id"] {
+			id, err := strconv.Atoi(v)
+			if err != nil || seen[id] {
+				continue
+			}
+			seen[id] = true
+			entries = append(entries, models.BallotEntry{ProjectID: id, Value: 1})
+		}
+	}
+
+	return entries
+}
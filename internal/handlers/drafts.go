@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxDrafts caps how many unfinished submissions one user keeps.
+const maxDrafts = 10
+
+// DraftSave is called by the submission form a moment after the author
+// stops typing. It answers with a status line for #draft-status; the first
+// save also swaps the new draft's id into the form, so that later saves
+// update the same draft.
+func (h *Handler) DraftSave(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	s := readSubmission(r)
+	draft := &models.ProjectDraft{
+		UserID:      userID,
+		Title:       s.Title,
+		Description: s.Description,
+		Category:    s.Category,
+		District:    s.District,
+		Budget:      s.Budget,
+	}
+	if s.Lat != 0 || s.Lng != 0 {
+		draft.Lat, draft.Lng = &s.Lat, &s.Lng
+	}
+	draft.ID, _ = strconv.Atoi(r.FormValue("draft_id"))
+
+	if draft.ID == 0 && s == (models.ProjectSubmission{}) {
+		return
+	}
+
+	if draft.ID != 0 {
+		err := h.DB.SaveDraft(draft)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Submitted or deleted in another tab: start a new draft.
+			draft.ID = 0
+		} else if err != nil {
+			w.Write([]byte("Не удалось сохранить черновик"))
+			return
+		}
+	}
+
+	created := draft.ID == 0
+	if created {
+		if n, err := h.DB.CountUserDrafts(userID); err != nil || n >= maxDrafts {
+			fmt.Fprintf(w, "Черновик не сохранён: у вас уже %d черновиков, удалите ненужные в профиле", maxDrafts)
+			return
+		}
+		if err := h.DB.SaveDraft(draft); err != nil {
+			w.Write([]byte("Не удалось сохранить черновик"))
+			return
+		}
+		w.Header().Set("HX-Replace-Url", fmt.Sprintf("/submit?draft=%d", draft.ID))
+	}
+
+	fmt.Fprintf(w, "Черновик сохранён в %s", draft.UpdatedAt.Format("15:04"))
+	if created {
+		fmt.Fprintf(w, `<input type="hidden" id="draft-id" name="draft_id" value="%d" hx-swap-oob="true">`, draft.ID)
+	}
+}
+
+func (h *Handler) DraftDelete(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	draftID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := h.DB.DeleteDraft(draftID, userID); err != nil {
+		writeError(w, "#drafts-error", "Ошибка удаления черновика")
+		return
+	}
+	h.audit(r, "draft.delete", "draft", draftID, nil, nil)
+
+	w.Header().Set("HX-Redirect", "/profile")
+	w.WriteHeader(http.StatusOK)
+}
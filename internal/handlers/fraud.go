@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/fraud"
+	"strconv"
+	"strings"
+)
+
+func (h *Handler) AdminFraudReport(w http.ResponseWriter, r *http.Request) {
+	records, err := h.DB.GetVoteSignals()
+	if err != nil {
+		http.Error(w, "Ошибка загрузки голосов", http.StatusInternalServerError)
+		return
+	}
+
+	report := fraud.Analyze(records)
+	quarantineLog, _ := h.DB.GetQuarantineLog(100)
+
+	data := map[string]interface{}{
+		"LoggedIn":       true,
+		"IsAdmin":        true,
+		"Report":         report,
+		"Log":            quarantineLog,
+		"TotalVotes":     len(records),
+		"MinScore":       fraud.SuspiciousMinScore,
+		"BurstAccounts":  fraud.BurstMinAccounts,
+		"SharedIPVoters": fraud.SharedIPMinVoters,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_fraud.html", data)
+}
+
+// AdminQuarantineVotes quarantines the selected votes and ballots. The
+// score stored in the audit trail is recomputed here rather than trusted
+// from the form.
+func (h *Handler) AdminQuarantineVotes(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	r.ParseForm()
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		writeError(w, "#error", "Укажите причину карантина")
+		return
+	}
+
+	var refs []fraud.Ref
+	for _, v := range r.Form["vote_id"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			refs = append(refs, fraud.Ref{ID: id})
+		}
+	}
+	for _, v := range r.Form["ballot_id"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			refs = append(refs, fraud.Ref{Ballot: true, ID: id})
+		}
+	}
+	if len(refs) == 0 {
+		writeError(w, "#error", "Не выбраны голоса")
+		return
+	}
+
+	scores := map[fraud.Ref]int{}
+	if records, err := h.DB.GetVoteSignals(); err == nil {
+		for _, vs := range fraud.Analyze(records).Votes {
+			scores[vs.Vote.Ref()] = vs.Score
+		}
+	}
+
+	if err := h.DB.QuarantineVotes(refs, scores, adminID, reason); err != nil {
+		if errors.Is(err, db.ErrCycleSealed) {
+			writeError(w, "#error", "Итоги цикла уже опубликованы, голоса этого цикла изменить нельзя")
+			return
+		}
+		writeError(w, "#error", "Ошибка при помещении голосов в карантин")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/fraud")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) AdminReleaseVote(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	var ref fraud.Ref
+	if id, err := strconv.Atoi(r.FormValue("ballot_id")); err == nil {
+		ref = fraud.Ref{Ballot: true, ID: id}
+	} else {
+		ref.ID, _ = strconv.Atoi(r.FormValue("vote_id"))
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		writeError(w, "#error", "Укажите причину снятия карантина")
+		return
+	}
+
+	if err := h.DB.ReleaseVote(ref, adminID, reason); err != nil {
+		if errors.Is(err, db.ErrCycleSealed) {
+			writeError(w, "#error", "Итоги цикла уже опубликованы, голоса этого цикла изменить нельзя")
+			return
+		}
+		writeError(w, "#error", "Голос не найден или уже не в карантине")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/fraud")
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,34 +2,70 @@ package handlers
 
 import (
         "encoding/json"
+        "errors"
         "fmt"
         "html/template"
+        "log"
         "net/http"
         "petropavlovsk-budget/internal/achievements"
         "petropavlovsk-budget/internal/ai"
         "petropavlovsk-budget/internal/auth"
         "petropavlovsk-budget/internal/db"
+        "petropavlovsk-budget/internal/identity"
+        "petropavlovsk-budget/internal/imaging"
+        "petropavlovsk-budget/internal/minhash"
         "petropavlovsk-budget/internal/models"
+        "petropavlovsk-budget/internal/moderation"
         "petropavlovsk-budget/internal/storage"
         "strconv"
         "strings"
+        "unicode/utf8"
 
         "github.com/go-chi/chi/v5"
         "github.com/gorilla/sessions"
 )
 
 type Handler struct {
-        DB        *db.Database
-        Store     *sessions.CookieStore
-        Templates *template.Template
+        DB         *db.Database
+        Store      *sessions.CookieStore
+        Templates  *template.Template
+        SMS        identity.SMSSender
+        Files      storage.Storage
+        Moderation moderation.Config
+        Screener   ai.CommentScreener
 }
 
 func New(database *db.Database, store *sessions.CookieStore) *Handler {
         tmpl := template.Must(template.ParseGlob("templates/*.html"))
+
+        sms, err := identity.NewSMSSender()
+        if err != nil {
+                log.Fatalf("Failed to configure SMS provider: %v", err)
+        }
+
+        files, err := storage.New()
+        if err != nil {
+                log.Fatalf("Failed to configure file storage: %v", err)
+        }
+
+        mod, err := moderation.ConfigFromEnv()
+        if err != nil {
+                log.Fatalf("Failed to configure moderation: %v", err)
+        }
+
+        screener, err := ai.NewCommentScreener()
+        if err != nil {
+                log.Fatalf("Failed to configure comment screening: %v", err)
+        }
+
         return &Handler{
-                DB:        database,
-                Store:     store,
-                Templates: tmpl,
+                DB:         database,
+                Store:      store,
+                Templates:  tmpl,
+                SMS:        sms,
+                Files:      files,
+                Moderation: mod,
+                Screener:   screener,
         }
 }
 
@@ -85,7 +121,7 @@ func (h *Handler) RegisterSubmit(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        user, err := h.DB.CreateUser(email, nickname, hash)
+        user, err := h.DB.CreateUser(email, nickname, hash, clientIP(r), r.UserAgent())
         if err != nil {
                 w.Header().Set("HX-Retarget", "#error")
                 w.Header().Set("HX-Reswap", "innerHTML")
@@ -100,6 +136,7 @@ func (h *Handler) RegisterSubmit(w http.ResponseWriter, r *http.Request) {
         session.Values["role"] = user.Role
         session.Save(r, w)
 
+        h.auditAs(r, &user.ID, user.Email, "auth.register", "user", user.ID, nil, map[string]string{"nickname": user.Nickname})
         h.DB.UnlockAchievement(user.ID, "newcomer")
 
         w.Header().Set("HX-Redirect", "/")
@@ -116,6 +153,7 @@ func (h *Handler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 
         user, err := h.DB.GetUserByEmail(email)
         if err != nil {
+                h.auditAs(r, nil, email, "auth.login_failed", "user", 0, nil, map[string]string{"reason": "unknown_email"})
                 w.Header().Set("HX-Retarget", "#error")
                 w.Header().Set("HX-Reswap", "innerHTML")
                 w.Write([]byte(`<div class="text-red-600 text-sm">Неверный email или пароль</div>`))
@@ -123,12 +161,21 @@ func (h *Handler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
         }
 
         if err := auth.CheckPassword(password, user.PasswordHash); err != nil {
+                h.auditAs(r, nil, email, "auth.login_failed", "user", user.ID, nil, map[string]string{"reason": "password"})
                 w.Header().Set("HX-Retarget", "#error")
                 w.Header().Set("HX-Reswap", "innerHTML")
                 w.Write([]byte(`<div class="text-red-600 text-sm">Неверный email или пароль</div>`))
                 return
         }
 
+        if user.Disabled {
+                h.auditAs(r, nil, email, "auth.login_failed", "user", user.ID, nil, map[string]string{"reason": "disabled"})
+                w.Header().Set("HX-Retarget", "#error")
+                w.Header().Set("HX-Reswap", "innerHTML")
+                w.Write([]byte(`<div class="text-red-600 text-sm">Учётная запись заблокирована</div>`))
+                return
+        }
+
         session, _ := h.Store.Get(r, "session")
         session.Values["user_id"] = user.ID
         session.Values["email"] = user.Email
@@ -136,6 +183,8 @@ func (h *Handler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
         session.Values["role"] = user.Role
         session.Save(r, w)
 
+        h.auditAs(r, &user.ID, user.Email, "auth.login", "user", user.ID, nil, map[string]string{"role": user.Role})
+
         if user.Role == "admin" {
                 w.Header().Set("HX-Redirect", "/admin")
         } else {
@@ -146,6 +195,9 @@ func (h *Handler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
         session, _ := h.Store.Get(r, "session")
+        if userID, ok := session.Values["user_id"].(int); ok {
+                h.audit(r, "auth.logout", "user", userID, nil, nil)
+        }
         session.Options.MaxAge = -1
         session.Save(r, w)
         http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -156,9 +208,21 @@ func (h *Handler) SubmitPage(w http.ResponseWriter, r *http.Request) {
         userID := session.Values["user_id"]
         userRole := session.Values["role"]
 
+        form := &models.ProjectDraft{}
+        if draftID, err := strconv.Atoi(r.URL.Query().Get("draft")); err == nil {
+                draft, err := h.DB.GetDraft(draftID, userID.(int))
+                if err != nil {
+                        http.Error(w, "Черновик не найден", http.StatusNotFound)
+                        return
+                }
+                form = draft
+        }
+
         data := map[string]interface{}{
-                "LoggedIn": userID != nil,
-                "IsAdmin":  userRole == "admin",
+                "LoggedIn":   userID != nil,
+                "IsAdmin":    userRole == "admin",
+                "Form":       form,
+                "Categories": projectCategories,
         }
 
         h.Templates.ExecuteTemplate(w, "submit.html", data)
@@ -174,70 +238,54 @@ func (h *Handler) SubmitProject(w http.ResponseWriter, r *http.Request) {
 
         r.ParseMultipartForm(20 << 20)
 
-        title := r.FormValue("title")
-        description := r.FormValue("description")
-        category := r.FormValue("category")
-        district := r.FormValue("district")
-        budgetStr := r.FormValue("budget")
-        latStr := r.FormValue("lat")
-        lngStr := r.FormValue("lng")
-
-        budget, _ := strconv.Atoi(budgetStr)
-        lat, _ := strconv.ParseFloat(latStr, 64)
-        lng, _ := strconv.ParseFloat(lngStr, 64)
-
-        submission := models.ProjectSubmission{
-                Title:       title,
-                Description: description,
-                Category:    category,
-                District:    district,
-                Budget:      budget,
-                Lat:         lat,
-                Lng:         lng,
-        }
-
-        analysis := ai.AnalyzeIdeaWithGemini(submission)
-        
-        analysisJSON, err := json.Marshal(map[string]interface{}{
-                "pros": analysis.Pros,
-                "cons": analysis.Cons,
-        })
-        if err != nil {
-                analysisJSON = []byte("{}")
+        submission := readSubmission(r)
+        if err := validateSubmission(submission); err != nil {
+                writeError(w, "#error", err.Error())
+                return
+        }
+
+        // Photos are checked before anything is stored so that a rejected
+        // file leaves neither a project nor stray uploads behind.
+        var images []*imaging.Result
+        if r.MultipartForm != nil && len(r.MultipartForm.File["photos"]) > 0 {
+                var err error
+                images, err = storage.PrepareProjectImages(r.MultipartForm.File["photos"])
+                if err != nil {
+                        writeError(w, "#error", err.Error())
+                        return
+                }
         }
 
         project := &models.Project{
-                Title:       title,
-                Description: description,
-                Category:    category,
-                District:    district,
-                Budget:      budget,
-                Lat:         lat,
-                Lng:         lng,
+                Title:       submission.Title,
+                Description: submission.Description,
+                Category:    submission.Category,
+                District:    submission.District,
+                Budget:      submission.Budget,
+                Lat:         submission.Lat,
+                Lng:         submission.Lng,
                 Status:      "moderation",
-                AIAnalysis:  string(analysisJSON),
+                AIAnalysis:  analyzeSubmission(submission),
                 UserID:      userID.(int),
-                Images:      []string{},
+                Images:      []models.Image{},
         }
 
-        err = h.DB.CreateProject(project)
+        var written []models.Image
+        err := h.DB.CreateProjectWithImages(project, func(projectID int) ([]models.Image, error) {
+                saved, err := storage.StoreProjectImages(h.Files, projectID, images)
+                written = saved
+                return saved, err
+        })
         if err != nil {
-                w.Header().Set("HX-Retarget", "#error")
-                w.Header().Set("HX-Reswap", "innerHTML")
-                w.Write([]byte(`<div class="text-red-600 text-sm">Ошибка создания проекта</div>`))
+                for _, img := range written {
+                        storage.RemoveUpload(h.Files, img.Path)
+                }
+                writeError(w, "#error", "Ошибка создания проекта")
                 return
         }
 
-        files := r.MultipartForm.File["photos"]
-        if len(files) > 0 {
-                imagePaths, err := storage.SaveProjectImages(project.ID, files)
-                if err != nil {
-                        w.Header().Set("HX-Retarget", "#error")
-                        w.Header().Set("HX-Reswap", "innerHTML")
-                        w.Write([]byte(fmt.Sprintf(`<div class="text-red-600 text-sm">%s</div>`, err.Error())))
-                        return
-                }
-                project.Images = imagePaths
+        if draftID, err := strconv.Atoi(r.FormValue("draft_id")); err == nil {
+                h.DB.DeleteDraft(draftID, userID.(int))
         }
 
         h.DB.CheckAndUnlockAchievements(userID.(int))
@@ -280,22 +328,135 @@ func (h *Handler) ProjectDetail(w http.ResponseWriter, r *http.Request) {
         }
 
         votes, _ := h.DB.GetProjectVotes(projectID)
-        comments, _ := h.DB.GetProjectComments(projectID)
+        viewerID, _ := userID.(int)
+        commentSort := r.URL.Query().Get("comments")
+        if commentSort != "useful" {
+                commentSort = "new"
+        }
+        comments, _ := h.DB.GetProjectComments(projectID, viewerID)
+        comments = visibleComments(comments, userID, userRole == "admin")
         history, _ := h.DB.GetProjectStatusHistory(projectID)
 
         hasVoted := false
+        isVerified := false
+        receipt := ""
         if userID != nil {
                 hasVoted, _ = h.DB.HasUserVoted(projectID, userID.(int))
+                isVerified, _ = h.DB.IsUserVerified(userID.(int))
+                if hasVoted {
+                        receipt, _ = h.DB.GetUserVoteReceipt(projectID, userID.(int))
+                }
+        }
+
+        votingOpen := h.projectVotingOpen(projectID)
+
+        // A vote can be moved here from any other project whose voting is
+        // still open.
+        var movable []models.Project
+        if userID != nil && votingOpen && !hasVoted {
+                voted, _ := h.DB.GetUserVotedProjects(userID.(int))
+                for _, p := range voted {
+                        if h.projectVotingOpen(p.ID) {
+                                movable = append(movable, p)
+                        }
+                }
+        }
+
+        var cycle *models.BudgetCycle
+        if project.CycleID != nil {
+                cycle, _ = h.DB.GetCycle(*project.CycleID)
+        }
+
+        // Selected projects carry a public record of how they are being
+        // built; nothing is shown until someone starts tracking it.
+        var implementation *models.Implementation
+        var milestones []models.Milestone
+        var spending []models.SpendEntry
+        var photoSets []PhotoSet
+        var comparisons []PhotoPair
+        canImplement := false
+        if isTracked(project.Status) {
+                implementation, err = h.DB.GetImplementation(projectID)
+                if err != nil {
+                        implementation = nil
+                }
+                milestones, _ = h.DB.GetMilestones(projectID)
+                spending, _ = h.DB.GetSpendEntries(projectID)
+                photos, _ := h.DB.GetProgressPhotos(projectID)
+                photoSets = groupPhotoSets(photos)
+                if project.Status == "done" {
+                        comparisons = comparisonPairs(photos)
+                }
+                canImplement = userRole == "admin"
+                if implementation != nil && implementation.ImplementerID != nil && userID != nil {
+                        canImplement = canImplement || *implementation.ImplementerID == userID.(int)
+                }
+        }
+
+        // Authors edit their project and arrange its photos while it is
+        // under review; admins can remove a photo at any stage.
+        canEdit := project.EditableByAuthor() && userID != nil && project.UserID == userID.(int)
+        canManagePhotos := userRole == "admin" || canEdit
+
+        // Only the author and admins see how the project was edited and
+        // what a moderator asked to change.
+        var revisions []revisionChange
+        var changeRequest *models.ChangeRequest
+        if userRole == "admin" || (userID != nil && project.UserID == userID.(int)) {
+                projectRevisions, _ := h.DB.GetProjectRevisions(projectID)
+                revisions = revisionChanges(project, projectRevisions)
+                if project.Status == "changes_requested" {
+                        changeRequest, _ = h.DB.GetLatestChangeRequest(projectID)
+                }
+        }
+
+        // Departments' responses are public. Department staff answer
+        // for their own department while the project is in moderation or
+        // voting, editing their department's earlier response if any.
+        responses, _ := h.DB.GetOfficialResponses(projectID)
+        var myResponse *models.OfficialResponse
+        department := ""
+        if userRole == "department" && project.RespondableByDepartment() {
+                if user, err := h.DB.GetUserByID(userID.(int)); err == nil && user.Role == "department" && !user.Disabled {
+                        department = user.Department
+                }
+                for i := range responses {
+                        if department != "" && responses[i].Department == department {
+                                myResponse = &responses[i]
+                        }
+                }
         }
 
         data := map[string]interface{}{
-                "LoggedIn": userID != nil,
-                "IsAdmin":  userRole == "admin",
-                "Project":  project,
-                "Votes":    votes,
-                "Comments": comments,
-                "History":  history,
-                "HasVoted": hasVoted,
+                "LoggedIn":        userID != nil,
+                "IsAdmin":         userRole == "admin",
+                "Project":         project,
+                "Cycle":           cycle,
+                "Votes":           votes,
+                "Comments":        commentThreads(comments, project, userID, userRole == "admin", commentSort),
+                "CommentCount":    len(comments),
+                "CommentSort":     commentSort,
+                "Responses":       responses,
+                "MyResponse":      myResponse,
+                "Department":      department,
+                "CanRespond":      department != "",
+                "Feasibilities":   feasibilityOptions(),
+                "History":         history,
+                "HasVoted":        hasVoted,
+                "Verified":        isVerified,
+                "Receipt":         receipt,
+                "VotingOpen":      votingOpen,
+                "Movable":         movable,
+                "Implementation":  implementation,
+                "Milestones":      milestones,
+                "Spending":        spending,
+                "CanImplement":    canImplement,
+                "CanEdit":         canEdit,
+                "CanManagePhotos": canManagePhotos,
+                "PhotoSets":       photoSets,
+                "Comparisons":     comparisons,
+                "Revisions":       revisions,
+                "ChangeRequest":   changeRequest,
         }
 
         h.Templates.ExecuteTemplate(w, "project_detail.html", data)
@@ -314,6 +475,42 @@ func (h *Handler) VoteSubmit(w http.ResponseWriter, r *http.Request) {
 
         projectID, _ := strconv.Atoi(projectIDStr)
 
+        verified, _ := h.DB.IsUserVerified(userID.(int))
+        if !verified {
+                w.Header().Set("HX-Retarget", "#vote-error")
+                w.Header().Set("HX-Reswap", "innerHTML")
+                w.Write([]byte(`<div class="text-red-600 text-sm">Голосовать могут только жители, подтвердившие личность. <a href="/verify" class="underline">Пройти проверку</a></div>`))
+                return
+        }
+
+        if project, err := h.DB.GetProjectByID(projectID); err == nil && project.CycleID != nil {
+                if cycle, err := h.DB.GetCycle(*project.CycleID); err == nil && cycle.BallotType != models.BallotSingle {
+                        w.Header().Set("HX-Retarget", "#vote-error")
+                        w.Header().Set("HX-Reswap", "innerHTML")
+                        w.Write([]byte(fmt.Sprintf(`<div class="text-red-600 text-sm">В этом цикле голосование проходит через общий бюллетень. <a href="/cycles/%d/ballot" class="underline">Перейти к бюллетеню</a></div>`, cycle.ID)))
+                        return
+                }
+        }
+
+        if !h.projectVotingOpen(projectID) {
+                writeError(w, "#vote-error", "Голосование по этому проекту закрыто")
+                return
+        }
+
+        // move_from turns the vote into a move: the vote for that project is
+        // withdrawn in the same transaction that records this one.
+        moveFrom, _ := strconv.Atoi(r.FormValue("move_from"))
+        if moveFrom != 0 {
+                if moveFrom == projectID || !h.projectVotingOpen(moveFrom) {
+                        writeError(w, "#vote-error", "Голос с этого проекта перенести нельзя: голосование по нему закрыто")
+                        return
+                }
+                if voted, _ := h.DB.HasUserVoted(moveFrom, userID.(int)); !voted {
+                        writeError(w, "#vote-error", "Вы не голосовали за проект, с которого переносите голос")
+                        return
+                }
+        }
+
         hasVoted, _ := h.DB.HasUserVoted(projectID, userID.(int))
         if hasVoted {
                 w.Header().Set("HX-Retarget", "#vote-error")
@@ -322,6 +519,17 @@ func (h *Handler) VoteSubmit(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
+        // The near-duplicate check runs when the vote is stored, in the same
+        // transaction, so it also protects voting when Gemini is unavailable.
+        vote := &models.Vote{
+                ProjectID:   projectID,
+                UserID:      userID.(int),
+                Comment:     comment,
+                IP:          clientIP(r),
+                UserAgent:   r.UserAgent(),
+                Fingerprint: minhash.Compute(comment),
+        }
+
         valid, reason := ai.ValidateVoteCommentWithGemini(comment)
         if !valid {
                 w.Header().Set("HX-Retarget", "#vote-error")
@@ -330,7 +538,24 @@ func (h *Handler) VoteSubmit(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        err := h.DB.CreateVote(projectID, userID.(int), comment)
+        var err error
+        if moveFrom != 0 {
+                err = h.DB.MoveVote(moveFrom, vote)
+        } else {
+                err = h.DB.CreateVote(vote)
+        }
+        if errors.Is(err, db.ErrDuplicateComment) {
+                w.Header().Set("HX-Retarget", "#vote-error")
+                w.Header().Set("HX-Reswap", "innerHTML")
+                w.Write([]byte(`<div class="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded"><strong>Комментарий отклонён:</strong> почти такой же комментарий к этому проекту уже оставил другой участник. Напишите своё обоснование.</div>`))
+                return
+        }
+        if errors.Is(err, db.ErrVoteQuarantined) {
+                w.Header().Set("HX-Retarget", "#vote-error")
+                w.Header().Set("HX-Reswap", "innerHTML")
+                w.Write([]byte(`<div class="text-red-600 text-sm">` + quarantinedMessage + `</div>`))
+                return
+        }
         if err != nil {
                 w.Header().Set("HX-Retarget", "#vote-error")
                 w.Header().Set("HX-Reswap", "innerHTML")
@@ -338,7 +563,12 @@ func (h *Handler) VoteSubmit(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        h.DB.CheckAndUnlockAchievements(userID.(int))
+        if moveFrom != 0 {
+                h.audit(r, "vote.move", "project", projectID, map[string]int{"project_id": moveFrom}, nil)
+                h.DB.RecomputeAchievements(userID.(int))
+        } else {
+                h.DB.CheckAndUnlockAchievements(userID.(int))
+        }
 
         w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d", projectID))
         w.WriteHeader(http.StatusOK)
@@ -391,18 +621,38 @@ func (h *Handler) ProjectPopup(w http.ResponseWriter, r *http.Request) {
                 "done":        "bg-green-200 text-green-800",
         }
 
+        // Each department's verdict, when there is one, is shown under the
+        // budget.
+        verdict := ""
+        responses, _ := h.DB.GetOfficialResponses(projectID)
+        for _, resp := range responses {
+                verdict += fmt.Sprintf(`<p class="text-sm mb-2"><strong>%s:</strong> <span class="px-2 py-1 rounded %s">%s</span></p>`,
+                        template.HTMLEscapeString(resp.Department), resp.FeasibilityColor(), resp.FeasibilityLabel())
+                if resp.EstimatedCost > 0 {
+                        verdict += fmt.Sprintf(`<p class="text-sm mb-2"><strong>Оценка:</strong> %s ₸</p>`, formatNumber(resp.EstimatedCost))
+                }
+        }
+
+        // Popups only ever need the smallest copy of the first photo.
+        thumb := ""
+        if len(project.Images) > 0 {
+                thumb = fmt.Sprintf(`<img src="%s" alt="" class="w-full h-32 object-cover rounded mb-2">`, project.Images[0].Thumb())
+        }
+
         html := fmt.Sprintf(`
                 <div class="p-4 max-w-sm">
+                        %s
                         <h3 class="font-bold text-lg mb-2">%s</h3>
                         <p class="text-sm text-gray-600 mb-2"><span class="px-2 py-1 rounded %s">%s</span></p>
                         <p class="text-sm mb-2">%s</p>
                         <p class="text-sm mb-2"><strong>Бюджет:</strong> %s ₸</p>
+                        %s
                         <p class="text-sm mb-2"><strong>Голосов:</strong> %d</p>
                         <a href="/projects/%d" class="text-blue-600 hover:underline text-sm">Подробнее →</a>
                 </div>
-        `, project.Title, statusColor[project.Status], statusText[project.Status], 
+        `, thumb, project.Title, statusColor[project.Status], statusText[project.Status], 
            truncateString(project.Description, 100), formatNumber(project.Budget), 
-           project.VoteCount, project.ID)
+           verdict, project.VoteCount, project.ID)
 
         w.Write([]byte(html))
 }
@@ -439,20 +689,31 @@ func (h *Handler) AdminDashboard(w http.ResponseWriter, r *http.Request) {
         userID := session.Values["user_id"]
         userRole := session.Values["role"]
 
-        moderationProjects, _ := h.DB.GetProjectsByStatus("moderation")
+        queue, _ := h.DB.GetModerationQueue()
         votingProjects, _ := h.DB.GetProjectsByStatus("voting")
+        votingProposals, _ := h.DB.GetVotingRejectionProposals()
         selectedProjects, _ := h.DB.GetProjectsByStatus("selected")
         inProgressProjects, _ := h.DB.GetProjectsByStatus("in_progress")
         doneProjects, _ := h.DB.GetProjectsByStatus("done")
+        changesRequested, _ := h.DB.GetProjectsByStatus("changes_requested")
+        cycles, _ := h.DB.ListCycles()
+        reasons, _ := h.DB.GetRejectionReasons(false)
 
         data := map[string]interface{}{
                 "LoggedIn":           userID != nil,
                 "IsAdmin":            userRole == "admin",
-                "ModerationProjects": moderationProjects,
-                "VotingProjects":     votingProjects,
+                "ModerationProjects": h.queueItems(queue, userID.(int)),
+                "RejectionReasons":   reasons,
+                "Resubmissions":      h.resubmissions(queue),
+                "TwoPersonReject":    h.Moderation.TwoPersonReject,
+                "Moderators":         h.moderators(),
+                "ChangesRequested":   changesRequested,
+                "VotingProjects":     votingItems(votingProjects, votingProposals, userID.(int)),
                 "SelectedProjects":   selectedProjects,
                 "InProgressProjects": inProgressProjects,
                 "DoneProjects":       doneProjects,
+                "Cycles":             cycles,
+                "Categories":         projectCategories,
         }
 
         h.Templates.ExecuteTemplate(w, "admin.html", data)
@@ -475,20 +736,31 @@ func (h *Handler) AdminUpdateProjectStatus(w http.ResponseWriter, r *http.Reques
                 return
         }
 
-        err = h.DB.UpdateProjectStatus(projectID, newStatus, adminID, comment)
-        if err != nil {
-                w.Header().Set("HX-Retarget", "#error")
-                w.Header().Set("HX-Reswap", "innerHTML")
-                w.Write([]byte(`<div class="text-red-600 text-sm">Ошибка обновления статуса</div>`))
+        if project.Status == "moderation" {
+                h.moderate(w, r, project, adminID)
+                return
+        }
+
+        if !canTransition(project.Status, newStatus) {
+                writeError(w, "#error", "Такой переход статуса невозможен, обновите страницу")
                 return
         }
 
-        if newStatus == "voting" {
-                voteStart := r.FormValue("vote_start")
-                voteEnd := r.FormValue("vote_end")
-                if voteStart != "" && voteEnd != "" {
-                        h.DB.SetVotingPeriod(projectID, voteStart, voteEnd)
+        if newStatus == "rejected" {
+                if !h.reject(w, r, project, adminID) {
+                        return
+                }
+        } else {
+                err = h.DB.UpdateProjectStatus(projectID, project.Status, newStatus, adminID, comment)
+                if err != nil {
+                        w.Header().Set("HX-Retarget", "#error")
+                        w.Header().Set("HX-Reswap", "innerHTML")
+                        w.Write([]byte(`<div class="text-red-600 text-sm">Ошибка обновления статуса</div>`))
+                        return
                 }
+                h.audit(r, "project.status", "project", projectID,
+                        map[string]string{"status": project.Status},
+                        map[string]string{"status": newStatus, "comment": comment})
         }
 
         h.DB.CheckAndUnlockAchievements(project.UserID)
@@ -507,20 +779,47 @@ func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 
         projectIDStr := r.FormValue("project_id")
         projectID, _ := strconv.Atoi(projectIDStr)
+        parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
         content := r.FormValue("content")
 
-        if len(content) < 50 {
-                w.Header().Set("HX-Retarget", "#comment-error")
-                w.Header().Set("HX-Reswap", "innerHTML")
-                w.Write([]byte(`<div class="text-red-600 text-sm">Комментарий должен быть минимум 50 символов</div>`))
+        // Replies answer in a thread and may be short.
+        errTarget := "#comment-error"
+        minLength := minCommentLength
+        if parentID != 0 {
+                errTarget = fmt.Sprintf("#reply-error-%d", parentID)
+                minLength = minReplyLength
+        }
+
+        if utf8.RuneCountInString(strings.TrimSpace(content)) < minLength {
+                writeError(w, errTarget, fmt.Sprintf("Комментарий должен быть минимум %d символов", minLength))
                 return
         }
 
-        err := h.DB.CreateComment(projectID, userID.(int), content)
+        // Comments the screener objects to wait for a moderator instead of
+        // being published.
+        verdict := h.Screener.Screen(content)
+        screeningReason := ""
+        if verdict.Toxic {
+                screeningReason = verdict.Reason
+                if screeningReason == "" {
+                        screeningReason = "Отмечен автоматической проверкой"
+                }
+        }
+
+        err := h.DB.CreateComment(projectID, userID.(int), parentID, content, screeningReason)
+        if errors.Is(err, db.ErrCommentNotFound) || errors.Is(err, db.ErrCommentNotPublished) {
+                writeError(w, errTarget, "На этот комментарий нельзя ответить")
+                return
+        }
         if err != nil {
-                w.Header().Set("HX-Retarget", "#comment-error")
+                writeError(w, errTarget, "Ошибка добавления комментария")
+                return
+        }
+
+        if verdict.Toxic {
+                w.Header().Set("HX-Retarget", errTarget)
                 w.Header().Set("HX-Reswap", "innerHTML")
-                w.Write([]byte(`<div class="text-red-600 text-sm">Ошибка добавления комментария</div>`))
+                w.Write([]byte(`<div class="text-yellow-700 text-sm">Комментарий отправлен на проверку модератору и появится после одобрения</div>`))
                 return
         }
 
@@ -560,7 +859,25 @@ func (h *Handler) AdminEditProject(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        err = h.DB.UpdateProject(projectID, title, description, category, district, budget)
+        // The author sees the reason next to the diff of the edit.
+        reason := strings.TrimSpace(r.FormValue("reason"))
+        if reason == "" {
+                writeError(w, "#error", "Укажите причину изменений")
+                return
+        }
+
+        project, err := h.DB.GetProjectByID(projectID)
+        if err != nil {
+                writeError(w, "#error", "Проект не найден")
+                return
+        }
+
+        adminID := session.Values["user_id"].(int)
+        err = h.DB.UpdateProject(projectID, adminID, title, description, category, district, budget, reason)
+        if errors.Is(err, db.ErrVotingStarted) {
+                writeError(w, "#error", "Бюджет нельзя изменить после начала голосования")
+                return
+        }
         if err != nil {
                 w.Header().Set("HX-Retarget", "#error")
                 w.Header().Set("HX-Reswap", "innerHTML")
@@ -568,6 +885,13 @@ func (h *Handler) AdminEditProject(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
+        after := projectFields(project)
+        after.Title, after.Description, after.Category, after.District, after.Budget = title, description, category, district, budget
+        h.audit(r, "project.edit", "project", projectID, projectFields(project), struct {
+                models.ProjectSubmission
+                Reason string
+        }{after, reason})
+
         w.Header().Set("HX-Redirect", "/admin")
         w.WriteHeader(http.StatusOK)
 }
@@ -625,6 +949,11 @@ func (h *Handler) ProfilePage(w http.ResponseWriter, r *http.Request) {
                 userAchievements = []models.UserAchievement{}
         }
 
+        voteChanges, _ := h.DB.GetUserVoteChanges(uid)
+        drafts, _ := h.DB.GetUserDrafts(uid)
+        notifications, _ := h.DB.GetNotifications(uid, 20)
+        h.DB.MarkNotificationsRead(uid)
+
         allAchievements := achievements.GetAllAchievementsList()
         unlockedMap := make(map[string]bool)
         for _, ua := range userAchievements {
@@ -640,16 +969,21 @@ func (h *Handler) ProfilePage(w http.ResponseWriter, r *http.Request) {
         }
 
         data := map[string]interface{}{
-                "LoggedIn":     true,
-                "IsAdmin":      userRole == "admin",
-                "User":         user,
-                "Email":        userEmail,
-                "Nickname":     userNickname,
-                "UserID":       userID,
-                "ProjectCount": len(userProjects),
-                "Projects":     userProjects,
-                "Stats":        stats,
-                "Achievements": achievementsWithStatus,
+                "LoggedIn":      true,
+                "IsAdmin":       userRole == "admin",
+                "IsImplementer": userRole == "implementer",
+                "IsDepartment":  userRole == "department",
+                "User":          user,
+                "Email":         userEmail,
+                "Nickname":      userNickname,
+                "UserID":        userID,
+                "ProjectCount":  len(userProjects),
+                "Projects":      userProjects,
+                "Stats":         stats,
+                "Achievements":  achievementsWithStatus,
+                "VoteChanges":   voteChanges,
+                "Drafts":        drafts,
+                "Notifications": notifications,
         }
 
         h.Templates.ExecuteTemplate(w, "profile.html", data)
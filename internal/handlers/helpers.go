@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+)
+
+// writeError renders an inline error into target for HTMX forms.
+func writeError(w http.ResponseWriter, target, message string) {
+	w.Header().Set("HX-Retarget", target)
+	w.Header().Set("HX-Reswap", "innerHTML")
+	w.Write([]byte(fmt.Sprintf(`<div class="text-red-600 text-sm">%s</div>`, template.HTMLEscapeString(message))))
+}
+
+// clientIP returns the caller's address without the port. The router runs
+// middleware.RealIP, so the address reported by a trusted proxy is already
+// applied.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// projectVotingOpen reports whether votes for the project can still be
+// cast, withdrawn or moved: it must be in voting and inside its cycle's
+// voting window, or its own window if it is not in a cycle.
+func (h *Handler) projectVotingOpen(projectID int) bool {
+	project, err := h.DB.GetProjectByID(projectID)
+	if err != nil || project.Status != "voting" {
+		return false
+	}
+	if project.CycleID != nil {
+		cycle, err := h.DB.GetCycle(*project.CycleID)
+		if err != nil || !cycle.VotingOpen(time.Now()) {
+			return false
+		}
+		return true
+	}
+	return project.VotingOpen(time.Now())
+}
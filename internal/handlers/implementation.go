@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// trackedStatuses are the project statuses that have an implementation.
+var trackedStatuses = []string{"selected", "in_progress", "done"}
+
+func isTracked(status string) bool {
+	for _, s := range trackedStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDate reads an <input type="date"> value; empty means no date.
+func parseDate(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// implementer loads the project and checks that the session user may edit
+// its implementation: admins always, implementers only while they hold the
+// role and are assigned.
+func (h *Handler) implementer(r *http.Request) (*models.Project, *models.Implementation, int, bool, error) {
+	session, _ := h.Store.Get(r, "session")
+	userID, _ := session.Values["user_id"].(int)
+	isAdmin := session.Values["role"] == "admin"
+
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	project, err := h.DB.GetProjectByID(projectID)
+	if err != nil || !isTracked(project.Status) {
+		return nil, nil, 0, false, pgx.ErrNoRows
+	}
+
+	impl, err := h.DB.GetImplementation(projectID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		impl = &models.Implementation{ProjectID: projectID, ApprovedBudget: project.Budget}
+	} else if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	// A former implementer keeps the assignment row until an admin
+	// reassigns the project, so the current role is checked as well.
+	isImplementer := session.Values["role"] == "implementer"
+	if !isAdmin && (!isImplementer || impl.ImplementerID == nil || *impl.ImplementerID != userID) {
+		return nil, nil, 0, false, errForbidden
+	}
+
+	return project, impl, userID, isAdmin, nil
+}
+
+func (h *Handler) accessError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errForbidden) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Проект не найден", http.StatusNotFound)
+}
+
+// ImplementationList shows implementers their assigned projects and admins
+// every project that has been selected.
+func (h *Handler) ImplementationList(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+	userRole := session.Values["role"]
+
+	if userRole != "admin" && userRole != "implementer" {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var projects []models.Project
+	if userRole == "admin" {
+		for _, status := range trackedStatuses {
+			list, _ := h.DB.GetProjectsByStatus(status)
+			projects = append(projects, list...)
+		}
+	} else {
+		ids, _ := h.DB.GetImplementerProjectIDs(userID)
+		for _, id := range ids {
+			if p, err := h.DB.GetProjectByID(id); err == nil && isTracked(p.Status) {
+				projects = append(projects, *p)
+			}
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  userRole == "admin",
+		"Projects": projects,
+	}
+
+	h.Templates.ExecuteTemplate(w, "implementation_list.html", data)
+}
+
+func (h *Handler) ImplementationPage(w http.ResponseWriter, r *http.Request) {
+	project, impl, _, isAdmin, err := h.implementer(r)
+	if err != nil {
+		h.accessError(w, err)
+		return
+	}
+
+	milestones, _ := h.DB.GetMilestones(project.ID)
+	spending, _ := h.DB.GetSpendEntries(project.ID)
+	photos, _ := h.DB.GetProgressPhotos(project.ID)
+
+	var implementers []models.User
+	currentImplementer := 0
+	if impl.ImplementerID != nil {
+		currentImplementer = *impl.ImplementerID
+	}
+	if isAdmin {
+		users, _ := h.DB.ListUsers()
+		for _, u := range users {
+			if u.Role == "implementer" && !u.Disabled {
+				implementers = append(implementers, u)
+			}
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":           true,
+		"IsAdmin":            isAdmin,
+		"Project":            project,
+		"Implementation":     impl,
+		"Milestones":         milestones,
+		"Spending":           spending,
+		"Implementers":       implementers,
+		"CurrentImplementer": currentImplementer,
+		"PhotoSets":          groupPhotoSets(photos),
+		"Stages":             []string{models.StageBefore, models.StageDuring, models.StageAfter},
+		"StageLabels":        stageLabels,
+		"Today":              time.Now().Format("2006-01-02"),
+	}
+
+	h.Templates.ExecuteTemplate(w, "implementation.html", data)
+}
+
+func (h *Handler) ImplementationSave(w http.ResponseWriter, r *http.Request) {
+	project, impl, _, isAdmin, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#impl-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	impl.Contractor = strings.TrimSpace(r.FormValue("contractor"))
+	impl.ContractNumber = strings.TrimSpace(r.FormValue("contract_number"))
+	impl.DelayReason = strings.TrimSpace(r.FormValue("delay_reason"))
+
+	amount, err := strconv.Atoi(strings.ReplaceAll(r.FormValue("contract_amount"), " ", ""))
+	if err != nil || amount < 0 {
+		writeError(w, "#impl-error", "Сумма договора должна быть неотрицательным числом")
+		return
+	}
+	impl.ContractAmount = amount
+
+	percent, err := strconv.Atoi(r.FormValue("percent_complete"))
+	if err != nil || percent < 0 || percent > 100 {
+		writeError(w, "#impl-error", "Готовность указывается в процентах от 0 до 100")
+		return
+	}
+	impl.PercentComplete = percent
+
+	dates := map[string]**time.Time{
+		"planned_start": &impl.PlannedStart,
+		"planned_end":   &impl.PlannedEnd,
+		"actual_start":  &impl.ActualStart,
+		"actual_end":    &impl.ActualEnd,
+	}
+	for field, dst := range dates {
+		t, err := parseDate(r.FormValue(field))
+		if err != nil {
+			writeError(w, "#impl-error", "Неверный формат даты")
+			return
+		}
+		*dst = t
+	}
+	if impl.PlannedStart != nil && impl.PlannedEnd != nil && impl.PlannedEnd.Before(*impl.PlannedStart) {
+		writeError(w, "#impl-error", "Плановое окончание раньше планового начала")
+		return
+	}
+	if impl.ActualStart != nil && impl.ActualEnd != nil && impl.ActualEnd.Before(*impl.ActualStart) {
+		writeError(w, "#impl-error", "Фактическое окончание раньше фактического начала")
+		return
+	}
+	if impl.ActualEnd != nil && impl.PercentComplete != 100 {
+		writeError(w, "#impl-error", "Если работы завершены, готовность должна быть 100%")
+		return
+	}
+	if impl.Late() && impl.DelayReason == "" {
+		writeError(w, "#impl-error", "Сроки нарушены: укажите причину задержки")
+		return
+	}
+
+	// Only admins assign implementers; an implementer cannot hand the
+	// project over or remove themselves.
+	if isAdmin {
+		if id, err := strconv.Atoi(r.FormValue("implementer_id")); err == nil && id > 0 {
+			user, err := h.DB.GetUserByID(id)
+			if err != nil || user.Role != "implementer" || user.Disabled {
+				writeError(w, "#impl-error", "Исполнителем можно назначить только активного пользователя с ролью исполнителя")
+				return
+			}
+			impl.ImplementerID = &id
+		} else {
+			impl.ImplementerID = nil
+		}
+	}
+
+	if err := h.DB.SaveImplementation(impl); err != nil {
+		writeError(w, "#impl-error", "Ошибка сохранения")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) MilestoneSave(w http.ResponseWriter, r *http.Request) {
+	project, _, _, _, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#milestone-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	milestoneID, _ := strconv.Atoi(r.FormValue("milestone_id"))
+	m := &models.Milestone{
+		ID:          milestoneID,
+		ProjectID:   project.ID,
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		DelayReason: strings.TrimSpace(r.FormValue("delay_reason")),
+	}
+	if m.Title == "" {
+		writeError(w, "#milestone-error", "Укажите название этапа")
+		return
+	}
+	if m.PlannedDate, err = parseDate(r.FormValue("planned_date")); err != nil {
+		writeError(w, "#milestone-error", "Неверный формат даты")
+		return
+	}
+	if m.ActualDate, err = parseDate(r.FormValue("actual_date")); err != nil {
+		writeError(w, "#milestone-error", "Неверный формат даты")
+		return
+	}
+	if m.ActualDate != nil && m.ActualDate.After(time.Now()) {
+		writeError(w, "#milestone-error", "Фактическая дата не может быть в будущем")
+		return
+	}
+	if m.Late() && m.DelayReason == "" {
+		writeError(w, "#milestone-error", "Этап просрочен: укажите причину задержки")
+		return
+	}
+
+	if err := h.DB.SaveMilestone(m); err != nil {
+		writeError(w, "#milestone-error", "Ошибка сохранения этапа")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) MilestoneDelete(w http.ResponseWriter, r *http.Request) {
+	project, _, _, _, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#milestone-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	milestoneID, _ := strconv.Atoi(chi.URLParam(r, "mid"))
+	if err := h.DB.DeleteMilestone(project.ID, milestoneID); err != nil {
+		writeError(w, "#milestone-error", "Ошибка удаления этапа")
+		return
+	}
+	h.audit(r, "milestone.delete", "project", project.ID, map[string]int{"milestone_id": milestoneID}, nil)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// SpendCreate records a payment. A negative amount corrects an earlier
+// entry; entries themselves cannot be changed.
+func (h *Handler) SpendCreate(w http.ResponseWriter, r *http.Request) {
+	project, _, userID, _, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#spend-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	amount, err := strconv.Atoi(strings.ReplaceAll(r.FormValue("amount"), " ", ""))
+	if err != nil || amount == 0 {
+		writeError(w, "#spend-error", "Укажите сумму в тенге; для исправления ошибки — отрицательную")
+		return
+	}
+	description := strings.TrimSpace(r.FormValue("description"))
+	if description == "" {
+		writeError(w, "#spend-error", "Укажите, за что произведена оплата")
+		return
+	}
+	spentOn, err := parseDate(r.FormValue("spent_on"))
+	if err != nil || spentOn == nil || spentOn.After(time.Now()) {
+		writeError(w, "#spend-error", "Укажите дату оплаты не позже сегодняшней")
+		return
+	}
+
+	entry := &models.SpendEntry{
+		ProjectID:   project.ID,
+		Amount:      amount,
+		Description: description,
+		SpentOn:     *spentOn,
+		RecordedBy:  userID,
+	}
+	if err := h.DB.CreateSpendEntry(entry); err != nil {
+		writeError(w, "#spend-error", "Ошибка сохранения")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/moderation"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// queueItem is a moderation queue entry as the moderator viewing the
+// queue sees it.
+type queueItem struct {
+	models.ModerationItem
+	Age      string
+	Deadline time.Time
+	DueIn    string
+	Overdue  bool
+	// Mine is set while the viewer's own claim holds the project; Locked
+	// while someone else's does.
+	Mine   bool
+	Locked bool
+	// OwnProposal is set when the viewer proposed the pending rejection
+	// and so cannot confirm it.
+	OwnProposal bool
+}
+
+func (h *Handler) queueItems(queue []models.ModerationItem, moderatorID int) []queueItem {
+	now := time.Now()
+	items := make([]queueItem, 0, len(queue))
+	for _, m := range queue {
+		item := queueItem{
+			ModerationItem: m,
+			Age:            moderation.FormatDuration(now.Sub(m.QueuedAt)),
+			Deadline:       h.Moderation.Deadline(m.QueuedAt),
+		}
+		item.Overdue = now.After(item.Deadline)
+		item.DueIn = moderation.FormatDuration(item.Deadline.Sub(now))
+		if m.ClaimedBy != nil {
+			item.Mine = *m.ClaimedBy == moderatorID
+			item.Locked = !item.Mine
+		}
+		if m.RejectProposal != nil {
+			item.OwnProposal = m.RejectProposal.ProposedBy == moderatorID
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// votingItem is a project in voting as the admin panel shows it, with any
+// rejection waiting for a second moderator.
+type votingItem struct {
+	models.Project
+	RejectProposal *models.RejectionProposal
+	OwnProposal    bool
+}
+
+func votingItems(projects []models.Project, proposals map[int]*models.RejectionProposal, moderatorID int) []votingItem {
+	items := make([]votingItem, 0, len(projects))
+	for _, p := range projects {
+		item := votingItem{Project: p, RejectProposal: proposals[p.ID]}
+		if item.RejectProposal != nil {
+			item.OwnProposal = item.RejectProposal.ProposedBy == moderatorID
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// statusTransitions lists where a project outside moderation may be moved
+// from the admin panel. Decisions on the queue go through moderate, and
+// every rejection through RejectProject.
+var statusTransitions = map[string][]string{
+	"voting":      {"selected", "rejected"},
+	"selected":    {"in_progress"},
+	"in_progress": {"done"},
+}
+
+func canTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// writeModerationError explains why a moderation action was refused.
+func writeModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotInModeration):
+		writeError(w, "#error", "Проект уже не на модерации")
+	case errors.Is(err, db.ErrClaimed):
+		writeError(w, "#error", "Проект взят в работу другим модератором")
+	case errors.Is(err, db.ErrSameModerator):
+		writeError(w, "#error", "Отклонение должен подтвердить другой модератор")
+	case errors.Is(err, db.ErrNotModerator):
+		writeError(w, "#error", "Назначить можно только активного модератора")
+	case errors.Is(err, db.ErrNotRejectable):
+		writeError(w, "#error", "Проект в этом статусе уже нельзя отклонить")
+	default:
+		writeError(w, "#error", "Ошибка обновления статуса")
+	}
+}
+
+// moderate applies a moderator's decision on a project in the queue.
+func (h *Handler) moderate(w http.ResponseWriter, r *http.Request, project *models.Project, adminID int) {
+	comment := strings.TrimSpace(r.FormValue("comment"))
+
+	switch r.FormValue("status") {
+	case "voting":
+		cycleID, _ := strconv.Atoi(r.FormValue("cycle_id"))
+		if cycleID > 0 {
+			cycle, err := h.DB.GetCycle(cycleID)
+			if err != nil {
+				writeError(w, "#error", "Цикл не найден")
+				return
+			}
+			if cycle.VotingStarted(time.Now()) {
+				writeError(w, "#error", "Голосование в этом цикле уже началось, добавить в него проект нельзя")
+				return
+			}
+		}
+		if err := h.DB.ApproveProject(project.ID, adminID, comment); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		h.audit(r, "project.status", "project", project.ID,
+			map[string]string{"status": project.Status},
+			map[string]string{"status": "voting", "comment": comment})
+		voteStart := r.FormValue("vote_start")
+		voteEnd := r.FormValue("vote_end")
+		if voteStart != "" && voteEnd != "" {
+			h.DB.SetVotingPeriod(project.ID, voteStart, voteEnd)
+		}
+		if cycleID > 0 {
+			if err := h.DB.SetProjectCycle(project.ID, cycleID); err != nil {
+				writeError(w, "#error", "Проект одобрен, но не добавлен в цикл: голосование в нём уже началось")
+				return
+			}
+		}
+	case "rejected":
+		if !h.reject(w, r, project, adminID) {
+			return
+		}
+	case "changes_requested":
+		h.requestChanges(w, r, project.ID, adminID)
+		return
+	default:
+		writeError(w, "#error", "Выберите решение по проекту")
+		return
+	}
+
+	h.DB.CheckAndUnlockAchievements(project.UserID)
+
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+// reject rejects the project, or under the two-person rule proposes to,
+// with the reasons and comment from the form. It reports whether it did;
+// otherwise the refusal has been written.
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request, project *models.Project, adminID int) bool {
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	codes, err := h.selectedReasons(r.Form["reason"])
+	if err != nil {
+		writeError(w, "#error", "Неизвестная причина отклонения, обновите страницу")
+		return false
+	}
+	if len(codes) == 0 && comment == "" {
+		writeError(w, "#error", "Выберите причину отклонения или напишите комментарий")
+		return false
+	}
+
+	rejected, err := h.DB.RejectProject(project.ID, adminID, codes, comment, h.Moderation.TwoPersonReject)
+	if err != nil {
+		writeModerationError(w, err)
+		return false
+	}
+	action, after := "project.reject_proposed", map[string]interface{}{"reasons": codes, "comment": comment}
+	if rejected {
+		action = "project.status"
+		after["status"] = "rejected"
+	}
+	h.audit(r, action, "project", project.ID, map[string]string{"status": project.Status}, after)
+	return true
+}
+
+// ModerationClaim takes a project in the queue, or extends one's claim.
+func (h *Handler) ModerationClaim(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if err := h.DB.ClaimProject(projectID, adminID, h.Moderation.ClaimTTL); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	h.audit(r, "moderation.claim", "project", projectID, nil, nil)
+
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+// ModerationAssign hands a project in the queue to a moderator, who gets
+// the same claim as if they had taken it themselves.
+func (h *Handler) ModerationAssign(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	assigneeID, _ := strconv.Atoi(r.FormValue("moderator_id"))
+
+	if err := h.DB.AssignProject(projectID, adminID, assigneeID, h.Moderation.ClaimTTL); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	h.audit(r, "moderation.assign", "project", projectID, nil, map[string]int{"moderator_id": assigneeID})
+
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+// moderators returns the accounts that can be assigned queue items.
+func (h *Handler) moderators() []models.User {
+	users, _ := h.DB.ListUsers()
+	var moderators []models.User
+	for _, u := range users {
+		if u.Role == "admin" && !u.Disabled {
+			moderators = append(moderators, u)
+		}
+	}
+	return moderators
+}
+
+// ModerationRelease gives a claimed project back to the queue.
+func (h *Handler) ModerationRelease(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if err := h.DB.ReleaseClaim(projectID, adminID); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	h.audit(r, "moderation.release", "project", projectID, nil, nil)
+
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+var statsPeriods = []int{7, 30, 90}
+
+// moderatorRow is one line of the moderation dashboard.
+type moderatorRow struct {
+	models.ModeratorStats
+	SLAPercent  int
+	AvgDecision string
+	AvgHandling string
+}
+
+// ModerationStats shows each moderator's throughput and time to decision
+// over the chosen period, next to the current state of the queue.
+func (h *Handler) ModerationStats(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	days := 30
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil {
+		for _, p := range statsPeriods {
+			if d == p {
+				days = d
+			}
+		}
+	}
+
+	stats, err := h.DB.GetModeratorStats(time.Now().AddDate(0, 0, -days), h.Moderation.SLA)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки статистики", http.StatusInternalServerError)
+		return
+	}
+	var rows []moderatorRow
+	for _, s := range stats {
+		row := moderatorRow{
+			ModeratorStats: s,
+			AvgDecision:    moderation.FormatDuration(s.AvgDecision),
+			AvgHandling:    "—",
+		}
+		if s.Total > 0 {
+			row.SLAPercent = s.WithinSLA * 100 / s.Total
+		}
+		if s.AvgHandling > 0 {
+			row.AvgHandling = moderation.FormatDuration(s.AvgHandling)
+		}
+		rows = append(rows, row)
+	}
+
+	queue, _ := h.DB.GetModerationQueue()
+	items := h.queueItems(queue, adminID)
+	var overdue, claimed, proposals int
+	for _, item := range items {
+		if item.Overdue {
+			overdue++
+		}
+		if item.ClaimedBy != nil {
+			claimed++
+		}
+		if item.RejectProposal != nil {
+			proposals++
+		}
+	}
+	oldest := ""
+	if len(items) > 0 {
+		oldest = items[0].Age
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":        true,
+		"IsAdmin":         true,
+		"Days":            days,
+		"Periods":         statsPeriods,
+		"Moderators":      rows,
+		"Queue":           len(items),
+		"Overdue":         overdue,
+		"Claimed":         claimed,
+		"Proposals":       proposals,
+		"Oldest":          oldest,
+		"TwoPersonReject": h.Moderation.TwoPersonReject,
+		"SLA":             moderation.FormatDuration(h.Moderation.SLA),
+		"ClaimTTL":        moderation.FormatDuration(h.Moderation.ClaimTTL),
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_moderation.html", data)
+}
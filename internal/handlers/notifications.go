@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// NotificationsBadge answers the header's request for the number of
+// unread notifications with a small badge, or nothing when all are read.
+func (h *Handler) NotificationsBadge(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	n, err := h.DB.CountUnreadNotifications(userID)
+	if err != nil || n == 0 {
+		return
+	}
+	fmt.Fprintf(w, `<span class="ml-1 bg-red-600 text-white text-xs rounded-full px-2 py-0.5">%d</span>`, n)
+}
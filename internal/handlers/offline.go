@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/identity"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxPaperCSV limits an uploaded paper ballot file.
+const maxPaperCSV = 5 << 20
+
+var paperColumns = []string{"iin", "cycle_id", "project_id", "value"}
+
+// paperRow is one line of a paper ballot CSV. Rows with the same IIN and
+// cycle form one ballot.
+type paperRow struct {
+	Line      int
+	Fields    []string
+	IIN       string
+	CycleID   int
+	ProjectID int
+	Value     int
+	Error     string
+}
+
+// paperImport is a parsed CSV: every row for the preview and error report,
+// and the ballots built from rows without errors.
+type paperImport struct {
+	Rows    []*paperRow
+	Ballots []*models.OfflineBallot
+	groups  [][]*paperRow
+}
+
+// parsePaperCSV reads the file and checks every row on its own: IIN format
+// and checksum, numeric ids, duplicates inside a ballot. A ballot with a
+// bad row is skipped as a whole, so a voter is never recorded with only
+// part of their choice.
+func parsePaperCSV(data []byte) (*paperImport, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать заголовок: %v", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range paperColumns[:3] {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("в заголовке нет столбца %s (ожидается %s)", name, strings.Join(paperColumns, ","))
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	imp := &paperImport{}
+	groupOf := map[string]int{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		row := &paperRow{Line: line, Fields: record}
+		imp.Rows = append(imp.Rows, row)
+		if err != nil {
+			row.Error = "Строка не разобрана: " + err.Error()
+			continue
+		}
+
+		row.IIN = identity.NormalizeIIN(field(record, "iin"))
+		if err := identity.ValidateIIN(row.IIN); err != nil {
+			row.Error = err.Error()
+			continue
+		}
+		// An empty cycle_id means a project outside any cycle.
+		if c := field(record, "cycle_id"); c != "" {
+			if row.CycleID, err = strconv.Atoi(c); err != nil || row.CycleID < 0 {
+				row.Error = "Некорректный номер цикла"
+				continue
+			}
+		}
+		if row.ProjectID, err = strconv.Atoi(field(record, "project_id")); err != nil || row.ProjectID <= 0 {
+			row.Error = "Некорректный номер проекта"
+			continue
+		}
+		row.Value = 1
+		if v := field(record, "value"); v != "" {
+			if row.Value, err = strconv.Atoi(v); err != nil || row.Value <= 0 {
+				row.Error = "Некорректное значение голоса"
+				continue
+			}
+		}
+
+		key := row.IIN + "/" + strconv.Itoa(row.CycleID)
+		g, ok := groupOf[key]
+		if !ok {
+			g = len(imp.groups)
+			groupOf[key] = g
+			imp.groups = append(imp.groups, nil)
+		}
+		for _, other := range imp.groups[g] {
+			if other.ProjectID == row.ProjectID {
+				row.Error = fmt.Sprintf("Проект №%d уже есть в этом бюллетене (строка %d)", row.ProjectID, other.Line)
+			}
+		}
+		imp.groups[g] = append(imp.groups[g], row)
+	}
+
+	var groups [][]*paperRow
+	for _, group := range imp.groups {
+		bad := 0
+		for _, row := range group {
+			if row.Error != "" {
+				bad = row.Line
+				break
+			}
+		}
+		if bad != 0 {
+			for _, row := range group {
+				if row.Error == "" {
+					row.Error = fmt.Sprintf("Бюллетень пропущен из-за ошибки в строке %d", bad)
+				}
+			}
+			continue
+		}
+
+		iinHash, err := identity.HashIIN(group[0].IIN)
+		if err != nil {
+			return nil, err
+		}
+		ballot := &models.OfflineBallot{IINHash: iinHash, CycleID: group[0].CycleID}
+		for _, row := range group {
+			ballot.Entries = append(ballot.Entries, models.BallotEntry{ProjectID: row.ProjectID, Value: row.Value})
+		}
+		imp.Ballots = append(imp.Ballots, ballot)
+		groups = append(groups, group)
+	}
+	imp.groups = groups
+
+	return imp, nil
+}
+
+// apply attaches the database result for each ballot to its rows.
+func (imp *paperImport) apply(errs []error, message func(error, int) string) {
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		text := message(err, imp.Ballots[i].CycleID)
+		for _, row := range imp.groups[i] {
+			row.Error = text
+		}
+	}
+}
+
+// Invalid returns the rows that will not be or were not imported.
+func (imp *paperImport) Invalid() []*paperRow {
+	var rows []*paperRow
+	for _, row := range imp.Rows {
+		if row.Error != "" {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// MaskedIIN hides the middle of the IIN on screen.
+func (row *paperRow) MaskedIIN() string {
+	if len(row.IIN) != 12 {
+		return ""
+	}
+	return row.IIN[:4] + "******" + row.IIN[10:]
+}
+
+// offlineErrorMessage explains why a paper or kiosk ballot was refused.
+func (h *Handler) offlineErrorMessage(err error, cycleID int) string {
+	switch {
+	case errors.Is(err, db.ErrOfflineVerificationPending):
+		return "По этому ИИН идёт онлайн-проверка личности: дождитесь её решения"
+	case errors.Is(err, db.ErrOfflineAlreadyVoted), errors.Is(err, db.ErrBallotExists):
+		return "Этот житель уже проголосовал"
+	case errors.Is(err, db.ErrOfflineVotingClosed):
+		return "Голосование в этом цикле не открыто"
+	case errors.Is(err, db.ErrOfflineEmptyBallot):
+		return "Не выбран ни один проект"
+	case errors.Is(err, db.ErrCycleSealed):
+		return "Журнал цикла закрыт, голоса больше не принимаются"
+	case errors.Is(err, db.ErrBallotInvalidItem):
+		return "Проект не участвует в голосовании этого цикла"
+	case errors.Is(err, pgx.ErrNoRows):
+		return "Цикл не найден"
+	}
+
+	cycle := &models.BudgetCycle{}
+	if cycleID != 0 {
+		if c, err := h.DB.GetCycle(cycleID); err == nil {
+			cycle = c
+		}
+	}
+	return ballotErrorMessage(err, cycle)
+}
+
+func (h *Handler) AdminPaperBallots(w http.ResponseWriter, r *http.Request) {
+	batches, _ := h.DB.GetPaperBatches()
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Batches":  batches,
+		"Columns":  strings.Join(paperColumns, ","),
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_paper.html", data)
+}
+
+// readPaperCSV takes the file from a fresh upload or, on import and error
+// report, the copy the preview carried in a hidden field.
+func readPaperCSV(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPaperCSV+(1<<20))
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(maxPaperCSV); err != nil {
+			return "", nil, err
+		}
+		if file, header, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			data, err := io.ReadAll(io.LimitReader(file, maxPaperCSV+1))
+			if err != nil {
+				return "", nil, err
+			}
+			if len(data) > maxPaperCSV {
+				return "", nil, errors.New("файл слишком большой")
+			}
+			return header.Filename, data, nil
+		}
+	}
+	return r.FormValue("filename"), []byte(r.FormValue("csv")), nil
+}
+
+// AdminPaperPreview checks an uploaded file by running the import in a
+// transaction that is rolled back, and shows which rows would be refused.
+func (h *Handler) AdminPaperPreview(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	filename, content, err := readPaperCSV(w, r)
+	if err != nil || len(content) == 0 {
+		writeError(w, "#paper-error", "Выберите CSV-файл размером до 5 МБ")
+		return
+	}
+
+	imp, err := parsePaperCSV(content)
+	if err != nil {
+		writeError(w, "#paper-error", "Ошибка в файле: "+err.Error())
+		return
+	}
+
+	batch := &models.PaperBatch{AdminID: adminID, Filename: filename, Rows: len(imp.Rows)}
+	errs, err := h.DB.ImportPaperBallots(batch, imp.Ballots, true)
+	if err != nil {
+		writeError(w, "#paper-error", "Ошибка при проверке бюллетеней")
+		return
+	}
+	imp.apply(errs, h.offlineErrorMessage)
+
+	data := map[string]interface{}{
+		"Filename": filename,
+		"CSV":      string(content),
+		"Rows":     imp.Rows,
+		"Invalid":  imp.Invalid(),
+		"Ballots":  len(imp.Ballots),
+		"Valid":    batch.Imported,
+		"Skipped":  batch.Skipped,
+	}
+
+	h.Templates.ExecuteTemplate(w, "paper-preview", data)
+}
+
+// AdminPaperImport records the previewed file. Rows are checked again: the
+// state may have changed since the preview.
+func (h *Handler) AdminPaperImport(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	filename, content, err := readPaperCSV(w, r)
+	if err != nil || len(content) == 0 {
+		writeError(w, "#paper-error", "Файл не передан, загрузите его заново")
+		return
+	}
+
+	imp, err := parsePaperCSV(content)
+	if err != nil {
+		writeError(w, "#paper-error", "Ошибка в файле: "+err.Error())
+		return
+	}
+
+	batch := &models.PaperBatch{AdminID: adminID, Filename: filename, Rows: len(imp.Rows)}
+	if _, err := h.DB.ImportPaperBallots(batch, imp.Ballots, false); err != nil {
+		writeError(w, "#paper-error", "Ошибка при импорте бюллетеней")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/paper")
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminPaperErrors downloads the refused rows as CSV, in the uploaded
+// columns plus line number and reason, so they can be fixed and uploaded
+// again.
+func (h *Handler) AdminPaperErrors(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	filename, content, err := readPaperCSV(w, r)
+	if err != nil || len(content) == 0 {
+		http.Error(w, "Файл не передан", http.StatusBadRequest)
+		return
+	}
+
+	imp, err := parsePaperCSV(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch := &models.PaperBatch{AdminID: adminID, Filename: filename, Rows: len(imp.Rows)}
+	errs, err := h.DB.ImportPaperBallots(batch, imp.Ballots, true)
+	if err != nil {
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	imp.apply(errs, h.offlineErrorMessage)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="paper-errors.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(append([]string{"line", "error"}, paperColumns...))
+	for _, row := range imp.Invalid() {
+		record := []string{strconv.Itoa(row.Line), row.Error}
+		record = append(record, row.Fields...)
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+// KioskPage lists what can be voted on at a staffed station: cycles with
+// an open voting window and projects outside any cycle.
+func (h *Handler) KioskPage(w http.ResponseWriter, r *http.Request) {
+	cycles, _ := h.DB.ListCycles()
+	var open []models.BudgetCycle
+	now := time.Now()
+	for _, c := range cycles {
+		if c.VotingOpen(now) && c.SealedAt == nil {
+			open = append(open, c)
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Cycles":   open,
+	}
+
+	h.Templates.ExecuteTemplate(w, "kiosk.html", data)
+}
+
+// kioskBallot returns the cycle (nil for projects outside any cycle) and
+// the projects a kiosk voter can choose from.
+func (h *Handler) kioskBallot(cycleID int) (*models.BudgetCycle, []models.Project, error) {
+	var projects []models.Project
+	if cycleID == 0 {
+		all, err := h.DB.GetProjectsByStatus("voting")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range all {
+			if p.CycleID == nil {
+				projects = append(projects, p)
+			}
+		}
+		return nil, projects, nil
+	}
+
+	cycle, err := h.DB.GetCycle(cycleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	all, err := h.DB.GetCycleProjects(cycleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range all {
+		if p.Status == "voting" {
+			projects = append(projects, p)
+		}
+	}
+	return cycle, projects, nil
+}
+
+func (h *Handler) KioskBallotPage(w http.ResponseWriter, r *http.Request) {
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	cycle, projects, err := h.kioskBallot(cycleID)
+	if err != nil {
+		http.Error(w, "Цикл не найден", http.StatusNotFound)
+		return
+	}
+
+	ballotType := models.BallotSingle
+	open := true
+	if cycle != nil {
+		ballotType = cycle.BallotType
+		open = cycle.VotingOpen(time.Now()) && cycle.SealedAt == nil
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":   true,
+		"IsAdmin":    true,
+		"CycleID":    cycleID,
+		"Cycle":      cycle,
+		"BallotType": ballotType,
+		"Projects":   projects,
+		"Open":       open,
+	}
+
+	h.Templates.ExecuteTemplate(w, "kiosk_ballot.html", data)
+}
+
+// KioskBallotSubmit records an in-person ballot. Staff have checked the
+// voter's ID card; the IIN ties the ballot to that person so they cannot
+// also vote online or on paper.
+func (h *Handler) KioskBallotSubmit(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	staffID := session.Values["user_id"].(int)
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	cycle, _, err := h.kioskBallot(cycleID)
+	if err != nil {
+		writeError(w, "#kiosk-result", "Цикл не найден")
+		return
+	}
+
+	iin := identity.NormalizeIIN(r.FormValue("iin"))
+	if err := identity.ValidateIIN(iin); err != nil {
+		writeError(w, "#kiosk-result", err.Error())
+		return
+	}
+	iinHash, err := identity.HashIIN(iin)
+	if err != nil {
+		writeError(w, "#kiosk-result", "Ошибка сервера")
+		return
+	}
+
+	ballotType := models.BallotSingle
+	if cycle != nil {
+		ballotType = cycle.BallotType
+	}
+
+	r.ParseForm()
+	ballot := &models.OfflineBallot{
+		IINHash: iinHash,
+		CycleID: cycleID,
+		Entries: parseBallotEntries(r, ballotType),
+		Channel: models.ChannelKiosk,
+		StaffID: staffID,
+	}
+
+	receipts, err := h.DB.RecordOfflineBallot(ballot)
+	if err != nil {
+		writeError(w, "#kiosk-result", h.offlineErrorMessage(err, cycleID))
+		return
+	}
+
+	data := map[string]interface{}{
+		"Receipts": receipts,
+	}
+
+	h.Templates.ExecuteTemplate(w, "kiosk-receipt", data)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/storage"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// photoAccess loads the project in the URL and what the session user may
+// do with its photos. Authors (and admins) arrange photos while the project
+// is under review; admins may remove an inappropriate photo at any stage.
+func (h *Handler) photoAccess(r *http.Request) (project *models.Project, canEdit, canRemove bool, err error) {
+	session, _ := h.Store.Get(r, "session")
+	userID, _ := session.Values["user_id"].(int)
+	isAdmin := session.Values["role"] == "admin"
+
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	project, err = h.DB.GetProjectByID(projectID)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	canEdit = project.EditableByAuthor() && (project.UserID == userID || isAdmin)
+	canRemove = canEdit || isAdmin
+	if !canRemove {
+		return nil, false, false, errForbidden
+	}
+	return project, canEdit, canRemove, nil
+}
+
+// photoSlot is one photo on the management page with the positions its
+// move buttons send.
+type photoSlot struct {
+	Image  models.Image
+	Number int
+	Cover  bool
+	Prev   int
+	Next   int
+	Last   bool
+}
+
+func photoSlots(images []models.Image) []photoSlot {
+	var slots []photoSlot
+	for i, img := range images {
+		slots = append(slots, photoSlot{
+			Image:  img,
+			Number: i + 1,
+			Cover:  i == 0,
+			Prev:   i - 1,
+			Next:   i + 1,
+			Last:   i == len(images)-1,
+		})
+	}
+	return slots
+}
+
+func imageIndex(images []models.Image, path string) int {
+	for i, img := range images {
+		if img.Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+func photosChanged(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrImagesChanged) {
+		writeError(w, "#photo-error", "Фотографии изменились в другой вкладке, обновите страницу")
+		return
+	}
+	writeError(w, "#photo-error", "Ошибка сохранения")
+}
+
+func (h *Handler) ProjectPhotosPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userRole := session.Values["role"]
+
+	project, canEdit, canRemove, err := h.photoAccess(r)
+	if err != nil {
+		h.accessError(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":  true,
+		"IsAdmin":   userRole == "admin",
+		"Project":   project,
+		"Photos":    photoSlots(project.Images),
+		"CanEdit":   canEdit,
+		"CanRemove": canRemove,
+		"MaxPhotos": storage.MaxFilesCount,
+		"Remaining": storage.MaxFilesCount - len(project.Images),
+	}
+
+	h.Templates.ExecuteTemplate(w, "project_photos.html", data)
+}
+
+func (h *Handler) ProjectPhotosAdd(w http.ResponseWriter, r *http.Request) {
+	project, canEdit, _, err := h.photoAccess(r)
+	if err != nil || !canEdit {
+		writeError(w, "#photo-error", "Фотографии можно менять только пока проект на модерации")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, storage.MaxFilesCount*storage.MaxFileSize+(1<<20))
+	if err := r.ParseMultipartForm(20 << 20); err != nil || len(r.MultipartForm.File["photos"]) == 0 {
+		writeError(w, "#photo-error", "Выберите фотографии")
+		return
+	}
+	files := r.MultipartForm.File["photos"]
+	if len(project.Images)+len(files) > storage.MaxFilesCount {
+		writeError(w, "#photo-error", fmt.Sprintf("У проекта может быть не больше %d фотографий", storage.MaxFilesCount))
+		return
+	}
+
+	images, err := storage.PrepareProjectImages(files)
+	if err != nil {
+		writeError(w, "#photo-error", err.Error())
+		return
+	}
+	saved, err := storage.StoreProjectImages(h.Files, project.ID, images)
+	if err != nil {
+		writeError(w, "#photo-error", "Ошибка сохранения фотографий")
+		return
+	}
+
+	updated := append(append([]models.Image{}, project.Images...), saved...)
+	if err := h.DB.UpdateProjectImages(project.ID, project.Images, updated); err != nil {
+		for _, img := range saved {
+			storage.RemoveUpload(h.Files, img.Path)
+		}
+		photosChanged(w, err)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d/photos", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// ProjectPhotoRemove drops the photo from the project first and deletes
+// its files afterwards, so the page never points at a missing file.
+func (h *Handler) ProjectPhotoRemove(w http.ResponseWriter, r *http.Request) {
+	project, _, canRemove, err := h.photoAccess(r)
+	if err != nil || !canRemove {
+		writeError(w, "#photo-error", "Нет доступа к фотографиям проекта")
+		return
+	}
+
+	path := r.FormValue("path")
+	i := imageIndex(project.Images, path)
+	if i < 0 {
+		writeError(w, "#photo-error", "Фотография не найдена")
+		return
+	}
+
+	updated := append(append([]models.Image{}, project.Images[:i]...), project.Images[i+1:]...)
+	if err := h.DB.UpdateProjectImages(project.ID, project.Images, updated); err != nil {
+		photosChanged(w, err)
+		return
+	}
+	storage.RemoveUpload(h.Files, path)
+	h.audit(r, "project.photo_remove", "project", project.ID, map[string]string{"photo": path}, nil)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d/photos", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// ProjectPhotoMove moves a photo to position "to"; position 0 is the
+// cover shown in lists and on the map.
+func (h *Handler) ProjectPhotoMove(w http.ResponseWriter, r *http.Request) {
+	project, canEdit, _, err := h.photoAccess(r)
+	if err != nil || !canEdit {
+		writeError(w, "#photo-error", "Фотографии можно менять только пока проект на модерации")
+		return
+	}
+
+	from := imageIndex(project.Images, r.FormValue("path"))
+	to, err := strconv.Atoi(r.FormValue("to"))
+	if from < 0 || err != nil || to < 0 || to >= len(project.Images) {
+		writeError(w, "#photo-error", "Фотография не найдена")
+		return
+	}
+
+	updated := append([]models.Image{}, project.Images...)
+	img := updated[from]
+	updated = append(updated[:from], updated[from+1:]...)
+	updated = append(updated[:to], append([]models.Image{img}, updated[to:]...)...)
+
+	if err := h.DB.UpdateProjectImages(project.ID, project.Images, updated); err != nil {
+		photosChanged(w, err)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d/photos", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
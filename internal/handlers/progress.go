@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var stageLabels = map[string]string{
+	models.StageBefore: "До начала работ",
+	models.StageDuring: "В ходе работ",
+	models.StageAfter:  "После завершения",
+}
+
+const maxCaptionLength = 300
+
+// PhotoSet is one upload of progress photos: the same milestone, stage and
+// date.
+type PhotoSet struct {
+	Milestone  string
+	Stage      string
+	StageLabel string
+	TakenOn    time.Time
+	Photos     []models.ProgressPhoto
+}
+
+// PhotoPair sets the first "before" photo of a milestone (or of the whole
+// project) against its latest "after" photo.
+type PhotoPair struct {
+	Title  string
+	Before models.ProgressPhoto
+	After  models.ProgressPhoto
+}
+
+// groupPhotoSets expects photos ordered by date, as GetProgressPhotos
+// returns them.
+func groupPhotoSets(photos []models.ProgressPhoto) []PhotoSet {
+	var sets []PhotoSet
+	index := map[string]int{}
+	for _, p := range photos {
+		milestone := 0
+		if p.MilestoneID != nil {
+			milestone = *p.MilestoneID
+		}
+		key := fmt.Sprintf("%d/%s/%s", milestone, p.Stage, p.TakenOn.Format("2006-01-02"))
+		i, ok := index[key]
+		if !ok {
+			i = len(sets)
+			index[key] = i
+			sets = append(sets, PhotoSet{
+				Milestone:  p.MilestoneTitle,
+				Stage:      p.Stage,
+				StageLabel: stageLabels[p.Stage],
+				TakenOn:    p.TakenOn,
+			})
+		}
+		sets[i].Photos = append(sets[i].Photos, p)
+	}
+	return sets
+}
+
+func comparisonPairs(photos []models.ProgressPhoto) []PhotoPair {
+	type pair struct {
+		before, after *models.ProgressPhoto
+		title         string
+	}
+	var order []int
+	pairs := map[int]*pair{}
+	for i := range photos {
+		p := &photos[i]
+		milestone := 0
+		if p.MilestoneID != nil {
+			milestone = *p.MilestoneID
+		}
+		pr, ok := pairs[milestone]
+		if !ok {
+			pr = &pair{title: p.MilestoneTitle}
+			pairs[milestone] = pr
+			order = append(order, milestone)
+		}
+		switch p.Stage {
+		case models.StageBefore:
+			if pr.before == nil {
+				pr.before = p
+			}
+		case models.StageAfter:
+			pr.after = p
+		}
+	}
+
+	var out []PhotoPair
+	for _, milestone := range order {
+		pr := pairs[milestone]
+		if pr.before == nil || pr.after == nil {
+			continue
+		}
+		title := pr.title
+		if title == "" {
+			title = "Проект целиком"
+		}
+		out = append(out, PhotoPair{Title: title, Before: *pr.before, After: *pr.after})
+	}
+	return out
+}
+
+func (h *Handler) ProgressPhotoUpload(w http.ResponseWriter, r *http.Request) {
+	project, _, userID, _, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#photo-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, storage.MaxProgressPhotos*storage.MaxFileSize+(1<<20))
+	if err := r.ParseMultipartForm(20 << 20); err != nil {
+		writeError(w, "#photo-error", "Слишком большой объём файлов")
+		return
+	}
+
+	stage := r.FormValue("stage")
+	if _, ok := stageLabels[stage]; !ok {
+		writeError(w, "#photo-error", "Выберите, к какому моменту относятся фото")
+		return
+	}
+	takenOn, err := parseDate(r.FormValue("taken_on"))
+	if err != nil || takenOn == nil || takenOn.After(time.Now()) {
+		writeError(w, "#photo-error", "Укажите дату съёмки не позже сегодняшней")
+		return
+	}
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	if len([]rune(caption)) > maxCaptionLength {
+		writeError(w, "#photo-error", fmt.Sprintf("Подпись не длиннее %d символов", maxCaptionLength))
+		return
+	}
+
+	var milestoneID *int
+	if id, err := strconv.Atoi(r.FormValue("milestone_id")); err == nil && id > 0 {
+		milestones, _ := h.DB.GetMilestones(project.ID)
+		for _, m := range milestones {
+			if m.ID == id {
+				milestoneID = &id
+			}
+		}
+		if milestoneID == nil {
+			writeError(w, "#photo-error", "Этап не найден")
+			return
+		}
+	}
+
+	files := r.MultipartForm.File["photos"]
+	if len(files) == 0 {
+		writeError(w, "#photo-error", "Выберите хотя бы одну фотографию")
+		return
+	}
+	saved, err := storage.SaveProgressPhotos(h.Files, project.ID, files)
+	if err != nil {
+		writeError(w, "#photo-error", err.Error())
+		return
+	}
+
+	var photos []models.ProgressPhoto
+	for _, img := range saved {
+		photos = append(photos, models.ProgressPhoto{
+			ProjectID:   project.ID,
+			MilestoneID: milestoneID,
+			Stage:       stage,
+			Path:        img,
+			Caption:     caption,
+			TakenOn:     *takenOn,
+			UploadedBy:  userID,
+		})
+	}
+	if err := h.DB.CreateProgressPhotos(photos); err != nil {
+		for _, img := range saved {
+			storage.RemoveUpload(h.Files, img.Path)
+		}
+		writeError(w, "#photo-error", "Ошибка сохранения фотографий")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ProgressPhotoDelete(w http.ResponseWriter, r *http.Request) {
+	project, _, _, _, err := h.implementer(r)
+	if err != nil {
+		writeError(w, "#photo-error", "Нет доступа к этому проекту")
+		return
+	}
+
+	photoID, _ := strconv.Atoi(chi.URLParam(r, "pid"))
+	path, err := h.DB.DeleteProgressPhoto(project.ID, photoID)
+	if err != nil {
+		writeError(w, "#photo-error", "Фотография не найдена")
+		return
+	}
+	storage.RemoveUpload(h.Files, path)
+	h.audit(r, "progress_photo.delete", "project", project.ID, map[string]interface{}{"photo_id": photoID, "photo": path}, nil)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/implementation/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
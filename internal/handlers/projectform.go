@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/ai"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	minDescriptionLength = 500
+	minProjectBudget     = 300000
+	maxProjectBudget     = 2000000
+)
+
+type projectCategory struct {
+	Value string
+	Label string
+}
+
+var projectCategories = []projectCategory{
+	{"озеленение", "Озеленение"},
+	{"благоустройство", "Благоустройство"},
+	{"скверы", "Скверы"},
+	{"культура", "Культура"},
+	{"урбанистика", "Урбанистика"},
+}
+
+// readSubmission reads the fields of the project form; unparsable numbers
+// are left at zero for validateSubmission to reject.
+func readSubmission(r *http.Request) models.ProjectSubmission {
+	budget, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("budget")))
+	lat, _ := strconv.ParseFloat(r.FormValue("lat"), 64)
+	lng, _ := strconv.ParseFloat(r.FormValue("lng"), 64)
+	return models.ProjectSubmission{
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Category:    r.FormValue("category"),
+		District:    strings.TrimSpace(r.FormValue("district")),
+		Budget:      budget,
+		Lat:         lat,
+		Lng:         lng,
+	}
+}
+
+func validateSubmission(s models.ProjectSubmission) error {
+	if s.Title == "" || s.District == "" {
+		return errors.New("Укажите название и район проекта")
+	}
+	if utf8.RuneCountInString(s.Description) < minDescriptionLength {
+		return fmt.Errorf("Описание должно быть не короче %d символов", minDescriptionLength)
+	}
+	known := false
+	for _, c := range projectCategories {
+		known = known || c.Value == s.Category
+	}
+	if !known {
+		return errors.New("Выберите категорию")
+	}
+	if s.Budget < minProjectBudget || s.Budget > maxProjectBudget {
+		return fmt.Errorf("Бюджет должен быть от %d до %d тенге", minProjectBudget, maxProjectBudget)
+	}
+	if s.Lat == 0 && s.Lng == 0 {
+		return errors.New("Выберите местоположение на карте")
+	}
+	return nil
+}
+
+// analyzeSubmission asks Gemini for the pros and cons of the project as
+// shown to moderators, encoded for projects.ai_analysis.
+func analyzeSubmission(s models.ProjectSubmission) string {
+	analysis := ai.AnalyzeIdeaWithGemini(s)
+	analysisJSON, err := json.Marshal(map[string]interface{}{
+		"pros": analysis.Pros,
+		"cons": analysis.Cons,
+	})
+	if err != nil {
+		return "{}"
+	}
+	return string(analysisJSON)
+}
+
+// authorProject loads the project in the URL for its author while it can
+// still be edited.
+func (h *Handler) authorProject(r *http.Request) (*models.Project, int, error) {
+	session, _ := h.Store.Get(r, "session")
+	userID, _ := session.Values["user_id"].(int)
+
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	project, err := h.DB.GetProjectByID(projectID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if project.UserID != userID || !project.EditableByAuthor() {
+		return nil, 0, errForbidden
+	}
+	return project, userID, nil
+}
+
+// ProjectEditPage reuses the submission form to let the author correct a
+// project that is still under review. Each saved edit is kept as a
+// revision that the author and admins can compare on the project page.
+func (h *Handler) ProjectEditPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userRole := session.Values["role"]
+
+	project, _, err := h.authorProject(r)
+	if err != nil {
+		h.accessError(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  userRole == "admin",
+		"Editing":  true,
+		"Project":  project,
+		"Form": &models.ProjectDraft{
+			Title:       project.Title,
+			Description: project.Description,
+			Category:    project.Category,
+			District:    project.District,
+			Budget:      project.Budget,
+			Lat:         &project.Lat,
+			Lng:         &project.Lng,
+		},
+		"Categories": projectCategories,
+	}
+	if project.Status == "changes_requested" {
+		data["ChangeRequest"], _ = h.DB.GetLatestChangeRequest(project.ID)
+	}
+
+	h.Templates.ExecuteTemplate(w, "submit.html", data)
+}
+
+func (h *Handler) ProjectEditSave(w http.ResponseWriter, r *http.Request) {
+	project, userID, err := h.authorProject(r)
+	if err != nil {
+		writeError(w, "#error", "Проект больше нельзя редактировать")
+		return
+	}
+
+	submission := readSubmission(r)
+	if err := validateSubmission(submission); err != nil {
+		writeError(w, "#error", err.Error())
+		return
+	}
+
+	// The AI analysis describes the old text, so an edit that changes
+	// anything is analysed again.
+	current := models.ProjectSubmission{
+		Title:       project.Title,
+		Description: project.Description,
+		Category:    project.Category,
+		District:    project.District,
+		Budget:      project.Budget,
+		Lat:         project.Lat,
+		Lng:         project.Lng,
+	}
+	analysis := ""
+	if submission != current {
+		analysis = analyzeSubmission(submission)
+	}
+
+	// A project sent back for changes returns to the moderation queue
+	// with the edit; one still in moderation is simply updated.
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if project.Status == "changes_requested" {
+		err = h.DB.ResubmitProject(project.ID, userID, submission, reason, analysis)
+	} else {
+		err = h.DB.UpdateProjectByAuthor(project.ID, userID, submission, reason, analysis)
+	}
+	if errors.Is(err, db.ErrNotEditable) {
+		writeError(w, "#error", "Проект больше нельзя редактировать")
+		return
+	}
+	if errors.Is(err, db.ErrVotingStarted) {
+		writeError(w, "#error", "Бюджет нельзя изменить после начала голосования")
+		return
+	}
+	if errors.Is(err, db.ErrNoChanges) {
+		writeError(w, "#error", "Внесите исправления, о которых просил модератор, прежде чем отправлять проект снова")
+		return
+	}
+	if err != nil {
+		writeError(w, "#error", "Ошибка сохранения")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d", project.ID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errUnknownReason = errors.New("unknown rejection reason")
+
+	reasonCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+)
+
+// selectedReasons checks the reason codes picked in the status form
+// against the active reasons and drops repeats.
+func (h *Handler) selectedReasons(codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	reasons, err := h.DB.GetRejectionReasons(false)
+	if err != nil {
+		return nil, err
+	}
+	active := map[string]bool{}
+	for _, r := range reasons {
+		active[r.Code] = true
+	}
+
+	var selected []string
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !active[code] {
+			return nil, errUnknownReason
+		}
+		if !seen[code] {
+			seen[code] = true
+			selected = append(selected, code)
+		}
+	}
+	return selected, nil
+}
+
+var reportPeriods = []int{30, 90, 365}
+
+// reasonShare is a line of the rejection report.
+type reasonShare struct {
+	models.RejectionReasonCount
+	Percent int
+}
+
+// AdminRejectionReasons lists the canned rejection reasons for editing,
+// next to how often each was cited over the chosen period.
+func (h *Handler) AdminRejectionReasons(w http.ResponseWriter, r *http.Request) {
+	reasons, err := h.DB.GetRejectionReasons(true)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки причин", http.StatusInternalServerError)
+		return
+	}
+
+	days := 90
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil {
+		for _, p := range reportPeriods {
+			if d == p {
+				days = d
+			}
+		}
+	}
+	counts, total, _ := h.DB.GetRejectionStats(time.Now().AddDate(0, 0, -days))
+	var shares []reasonShare
+	for _, c := range counts {
+		share := reasonShare{RejectionReasonCount: c}
+		if total > 0 {
+			share.Percent = c.Count * 100 / total
+		}
+		shares = append(shares, share)
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"IsAdmin":  true,
+		"Reasons":  reasons,
+		"Days":     days,
+		"Periods":  reportPeriods,
+		"Shares":   shares,
+		"Rejected": total,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_reasons.html", data)
+}
+
+// AdminSaveRejectionReason creates a reason, or updates one when
+// reason_id is set. The code of an existing reason cannot change.
+func (h *Handler) AdminSaveRejectionReason(w http.ResponseWriter, r *http.Request) {
+	reasonID, _ := strconv.Atoi(r.FormValue("reason_id"))
+	position, _ := strconv.Atoi(r.FormValue("position"))
+	reason := &models.RejectionReason{
+		ID:       reasonID,
+		Code:     strings.TrimSpace(r.FormValue("code")),
+		TitleRu:  strings.TrimSpace(r.FormValue("title_ru")),
+		TitleKk:  strings.TrimSpace(r.FormValue("title_kk")),
+		TextRu:   strings.TrimSpace(r.FormValue("text_ru")),
+		TextKk:   strings.TrimSpace(r.FormValue("text_kk")),
+		Position: position,
+		Active:   r.FormValue("active") != "",
+	}
+
+	if reason.TitleRu == "" || reason.TitleKk == "" || reason.TextRu == "" || reason.TextKk == "" {
+		writeError(w, "#error", "Заполните название и текст на русском и казахском")
+		return
+	}
+
+	var err error
+	if reasonID == 0 {
+		if !reasonCodePattern.MatchString(reason.Code) {
+			writeError(w, "#error", "Код: латинские строчные буквы, цифры и _, от 2 до 40 символов")
+			return
+		}
+		err = h.DB.CreateRejectionReason(reason)
+	} else {
+		err = h.DB.UpdateRejectionReason(reason)
+	}
+	if errors.Is(err, db.ErrReasonCodeTaken) {
+		writeError(w, "#error", "Причина с таким кодом уже есть")
+		return
+	}
+	if err != nil {
+		writeError(w, "#error", "Ошибка сохранения причины")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/reasons")
+	w.WriteHeader(http.StatusOK)
+}
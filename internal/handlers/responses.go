@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// feasibilityOption is a choice in the official response form.
+type feasibilityOption struct {
+	Value string
+	Label string
+}
+
+func feasibilityOptions() []feasibilityOption {
+	var options []feasibilityOption
+	for _, f := range models.Feasibilities {
+		options = append(options, feasibilityOption{f, models.FeasibilityLabel(f)})
+	}
+	return options
+}
+
+func validFeasibility(f string) bool {
+	for _, v := range models.Feasibilities {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
+// departmentUser returns the signed-in user if they answer for a city
+// department. Responses are signed with the department stored on the
+// user, so a department user without one cannot answer yet.
+func (h *Handler) departmentUser(r *http.Request) (*models.User, error) {
+	session, _ := h.Store.Get(r, "session")
+	userID, _ := session.Values["user_id"].(int)
+	if session.Values["role"] != "department" {
+		return nil, errForbidden
+	}
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != "department" || user.Disabled {
+		return nil, errForbidden
+	}
+	return user, nil
+}
+
+// DepartmentPage lists the projects still waiting for a response from the
+// user's department.
+func (h *Handler) DepartmentPage(w http.ResponseWriter, r *http.Request) {
+	user, err := h.departmentUser(r)
+	if err != nil {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var projects []models.Project
+	if user.Department != "" {
+		projects, err = h.DB.GetProjectsAwaitingResponse(user.Department)
+		if err != nil {
+			http.Error(w, "Ошибка загрузки проектов", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":   true,
+		"IsAdmin":    false,
+		"Department": user.Department,
+		"Projects":   projects,
+	}
+
+	h.Templates.ExecuteTemplate(w, "department.html", data)
+}
+
+// OfficialResponseSave publishes or replaces the response of the user's
+// department on a project in moderation or voting. Only users with the
+// department role may answer.
+func (h *Handler) OfficialResponseSave(w http.ResponseWriter, r *http.Request) {
+	user, err := h.departmentUser(r)
+	if err != nil {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	if user.Department == "" {
+		writeError(w, "#response-error", "Ваше подразделение не указано — обратитесь к администратору")
+		return
+	}
+
+	projectID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	project, err := h.DB.GetProjectByID(projectID)
+	if err != nil {
+		http.Error(w, "Проект не найден", http.StatusNotFound)
+		return
+	}
+	if !project.RespondableByDepartment() {
+		writeError(w, "#response-error", "Отвечать можно только на проекты на модерации и голосовании")
+		return
+	}
+
+	cost, err := strconv.Atoi(strings.ReplaceAll(r.FormValue("estimated_cost"), " ", ""))
+	if r.FormValue("estimated_cost") == "" {
+		cost, err = 0, nil
+	}
+	if err != nil || cost < 0 {
+		writeError(w, "#response-error", "Оценка стоимости — целое число тенге")
+		return
+	}
+
+	response := &models.OfficialResponse{
+		ProjectID:     projectID,
+		AuthorID:      &user.ID,
+		Feasibility:   r.FormValue("feasibility"),
+		EstimatedCost: cost,
+		Department:    user.Department,
+		Body:          strings.TrimSpace(r.FormValue("body")),
+	}
+	if !validFeasibility(response.Feasibility) {
+		writeError(w, "#response-error", "Выберите оценку реализуемости")
+		return
+	}
+	if response.Body == "" {
+		writeError(w, "#response-error", "Напишите текст ответа")
+		return
+	}
+
+	previous, err := h.DB.SaveOfficialResponse(response)
+	if errors.Is(err, db.ErrNotRespondable) {
+		writeError(w, "#response-error", "Отвечать можно только на проекты на модерации и голосовании")
+		return
+	}
+	if err != nil {
+		writeError(w, "#response-error", "Ошибка сохранения ответа")
+		return
+	}
+
+	var before interface{}
+	if previous != nil {
+		before = previous
+	}
+	h.audit(r, "project.official_response", "project", projectID, before, response)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d#official-response", projectID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/charts"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/results"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ResultsIndex lists the cycles whose voting has ended.
+func (h *Handler) ResultsIndex(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"]
+	userRole := session.Values["role"]
+
+	cycles, _ := h.DB.ListCycles()
+	var finished []models.BudgetCycle
+	now := time.Now()
+	for _, c := range cycles {
+		if results.Published(c, now) {
+			finished = append(finished, c)
+		}
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": userID != nil,
+		"IsAdmin":  userRole == "admin",
+		"Cycles":   finished,
+	}
+
+	h.Templates.ExecuteTemplate(w, "results_index.html", data)
+}
+
+// cycleSummary counts the cycle and gathers the participation figures. The
+// bool is false while voting is still running: results are hidden then so
+// they cannot sway the remaining voters. Admins always see them.
+func (h *Handler) cycleSummary(r *http.Request) (*results.Summary, bool, error) {
+	session, _ := h.Store.Get(r, "session")
+	isAdmin := session.Values["role"] == "admin"
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	cycle, projects, result, err := h.countCycle(cycleID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !results.Published(*cycle, time.Now()) && !isAdmin {
+		return &results.Summary{CycleID: cycle.ID, Title: cycle.Title, VoteEnd: cycle.VoteEnd}, false, nil
+	}
+
+	voters, total, err := h.DB.GetCycleVoters(cycleID)
+	if err != nil {
+		return nil, false, err
+	}
+	daily, err := h.DB.GetCycleDailyParticipation(cycleID)
+	if err != nil {
+		return nil, false, err
+	}
+	channels, err := h.DB.GetChannelBreakdown(cycleID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	summary := results.New(*cycle, eligibleProjects(projects), result, results.Stats{
+		Voters:        total,
+		ProjectVoters: voters,
+		Channels:      channels,
+		Daily:         daily,
+	})
+	return &summary, true, nil
+}
+
+func (h *Handler) ResultsPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"]
+	userRole := session.Values["role"]
+
+	summary, published, err := h.cycleSummary(r)
+	if err != nil {
+		http.Error(w, "Цикл не найден", http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":  userID != nil,
+		"IsAdmin":   userRole == "admin",
+		"Summary":   summary,
+		"Published": published,
+	}
+
+	if published {
+		unit := summary.ScoreUnit()
+
+		var projectBars []charts.Bar
+		for _, p := range summary.Projects {
+			projectBars = append(projectBars, charts.Bar{Label: p.Title, Value: p.Score, Highlight: p.Winner})
+		}
+		var districtBars []charts.Bar
+		for _, g := range summary.Districts {
+			districtBars = append(districtBars, charts.Bar{
+				Label: g.Name,
+				Value: g.Voters,
+				Note:  fmt.Sprintf("проектов %d, победителей %d", g.Projects, g.Winners),
+			})
+		}
+		var categoryBars []charts.Bar
+		for _, g := range summary.Categories {
+			categoryBars = append(categoryBars, charts.Bar{
+				Label:     g.Name,
+				Value:     g.Score,
+				Note:      fmt.Sprintf("%s ₸ профинансировано", formatNumber(g.Funded)),
+				Highlight: g.Winners > 0,
+			})
+		}
+		var points []charts.Point
+		for _, d := range summary.Days {
+			points = append(points, charts.Point{Date: d.Date, Value: d.Count})
+		}
+
+		data["BudgetChart"] = charts.Progress("Использование бюджета", summary.BudgetUsed, summary.Budget,
+			fmt.Sprintf("Распределено %s ₸", formatNumber(summary.BudgetUsed)),
+			fmt.Sprintf("из %s ₸, остаток %s ₸", formatNumber(summary.Budget), formatNumber(summary.Budget-summary.BudgetUsed)))
+		data["ProjectChart"] = charts.HBar("Поддержка проектов", projectBars, unit)
+		data["DistrictChart"] = charts.HBar("Участники по районам", districtBars, "участников")
+		data["CategoryChart"] = charts.HBar("Поддержка по категориям", categoryBars, unit)
+		data["TimelineChart"] = charts.Timeline("Участие по дням", points)
+		data["Unit"] = unit
+		data["Live"] = summary.VoteEnd == nil || time.Now().Before(*summary.VoteEnd)
+	}
+
+	h.Templates.ExecuteTemplate(w, "results.html", data)
+}
+
+func (h *Handler) ResultsCSV(w http.ResponseWriter, r *http.Request) {
+	summary, published, err := h.cycleSummary(r)
+	if err != nil || !published {
+		http.Error(w, "Итоги не найдены", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cycle-%d-results.csv"`, summary.CycleID))
+	summary.WriteCSV(w)
+}
+
+func (h *Handler) ResultsJSON(w http.ResponseWriter, r *http.Request) {
+	summary, published, err := h.cycleSummary(r)
+	if err != nil || !published {
+		http.Error(w, "Итоги не найдены", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cycle-%d-results.json"`, summary.CycleID))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(summary)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/textdiff"
+)
+
+type fieldChange struct {
+	Label string
+	Old   string
+	New   string
+}
+
+// projectDiff is what changed between two versions of a project: word
+// diffs of the title and description (nil when unchanged) and the old and
+// new values of the other fields.
+type projectDiff struct {
+	Title       []textdiff.Op
+	Description []textdiff.Op
+	Fields      []fieldChange
+}
+
+func (d projectDiff) Empty() bool {
+	return d.Title == nil && d.Description == nil && len(d.Fields) == 0
+}
+
+func diffProject(before, after models.ProjectSubmission) projectDiff {
+	var d projectDiff
+	if ops := textdiff.Words(before.Title, after.Title); textdiff.Changed(ops) {
+		d.Title = ops
+	}
+	if ops := textdiff.Words(before.Description, after.Description); textdiff.Changed(ops) {
+		d.Description = ops
+	}
+	if before.Category != after.Category {
+		d.Fields = append(d.Fields, fieldChange{"Категория", before.Category, after.Category})
+	}
+	if before.District != after.District {
+		d.Fields = append(d.Fields, fieldChange{"Район", before.District, after.District})
+	}
+	if before.Budget != after.Budget {
+		d.Fields = append(d.Fields, fieldChange{"Бюджет", fmt.Sprintf("%d ₸", before.Budget), fmt.Sprintf("%d ₸", after.Budget)})
+	}
+	if before.Lat != after.Lat || before.Lng != after.Lng {
+		d.Fields = append(d.Fields, fieldChange{"Местоположение",
+			fmt.Sprintf("%.5f, %.5f", before.Lat, before.Lng), fmt.Sprintf("%.5f, %.5f", after.Lat, after.Lng)})
+	}
+	return d
+}
+
+func projectFields(p *models.Project) models.ProjectSubmission {
+	return models.ProjectSubmission{
+		Title:       p.Title,
+		Description: p.Description,
+		Category:    p.Category,
+		District:    p.District,
+		Budget:      p.Budget,
+		Lat:         p.Lat,
+		Lng:         p.Lng,
+	}
+}
+
+// revisionChange is one edit shown as a diff: a revision holds the fields
+// before the edit, and the next revision (or the project itself, for the
+// latest edit) holds them after it.
+type revisionChange struct {
+	Revision models.ProjectRevision
+	ByAuthor bool
+	Diff     projectDiff
+}
+
+func revisionChanges(project *models.Project, revisions []models.ProjectRevision) []revisionChange {
+	var changes []revisionChange
+	for i := len(revisions) - 1; i >= 0; i-- {
+		after := projectFields(project)
+		if i+1 < len(revisions) {
+			after = revisions[i+1].Fields()
+		}
+		changes = append(changes, revisionChange{
+			Revision: revisions[i],
+			ByAuthor: revisions[i].EditorID == project.UserID,
+			Diff:     diffProject(revisions[i].Fields(), after),
+		})
+	}
+	return changes
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/identity"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/storage"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	otpTTL         = 10 * time.Minute
+	otpMaxAttempts = 5
+)
+
+func (h *Handler) VerifyPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"]
+	userRole := session.Values["role"]
+
+	user, err := h.DB.GetUserByID(userID.(int))
+	if err != nil {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	}
+
+	verification, _ := h.DB.GetIdentityVerification(user.ID)
+
+	data := map[string]interface{}{
+		"LoggedIn":     true,
+		"IsAdmin":      userRole == "admin",
+		"User":         user,
+		"Verification": verification,
+	}
+
+	h.Templates.ExecuteTemplate(w, "verify.html", data)
+}
+
+func (h *Handler) VerifySubmit(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	if existing, err := h.DB.GetIdentityVerification(userID); err == nil && existing.Status == "approved" {
+		writeError(w, "#error", "Ваша личность уже подтверждена")
+		return
+	}
+
+	r.ParseMultipartForm(10 << 20)
+
+	iin := identity.NormalizeIIN(r.FormValue("iin"))
+	method := r.FormValue("method")
+
+	if err := identity.ValidateIIN(iin); err != nil {
+		writeError(w, "#error", err.Error())
+		return
+	}
+
+	iinHash, err := identity.HashIIN(iin)
+	if err != nil {
+		writeError(w, "#error", "Проверка личности временно недоступна")
+		return
+	}
+
+	taken, err := h.DB.IsIINHashTaken(iinHash, userID)
+	if err != nil {
+		writeError(w, "#error", "Ошибка сервера")
+		return
+	}
+	if taken {
+		writeError(w, "#error", "Этот ИИН уже привязан к другой учётной записи")
+		return
+	}
+
+	v := &models.IdentityVerification{
+		UserID:  userID,
+		IINHash: iinHash,
+		Method:  method,
+	}
+
+	var code string
+	switch method {
+	case "sms":
+		phone, ok := identity.NormalizePhone(r.FormValue("phone"))
+		if !ok {
+			writeError(w, "#error", "Укажите номер мобильного телефона в формате +7XXXXXXXXXX")
+			return
+		}
+		code, err = identity.GenerateOTP()
+		if err != nil {
+			writeError(w, "#error", "Ошибка сервера")
+			return
+		}
+		expires := time.Now().Add(otpTTL)
+		v.Phone = phone
+		v.OTPHash = identity.HashOTP(code)
+		v.OTPExpiresAt = &expires
+
+	case "document":
+		if r.MultipartForm == nil || len(r.MultipartForm.File["document"]) == 0 {
+			writeError(w, "#error", "Приложите фото удостоверения личности или справки о прописке")
+			return
+		}
+		path, err := storage.SaveVerificationDocument(h.Files, userID, r.MultipartForm.File["document"][0])
+		if err != nil {
+			writeError(w, "#error", err.Error())
+			return
+		}
+		v.DocumentPath = path
+
+	default:
+		writeError(w, "#error", "Выберите способ подтверждения")
+		return
+	}
+
+	if err := h.DB.StartIdentityVerification(v); err != nil {
+		if errors.Is(err, db.ErrIINAlreadyUsed) {
+			writeError(w, "#error", "Этот ИИН уже привязан к другой учётной записи")
+			return
+		}
+		if errors.Is(err, db.ErrPhoneAlreadyUsed) {
+			writeError(w, "#error", "Этот номер телефона уже использован для подтверждения другой учётной записи")
+			return
+		}
+		if errors.Is(err, db.ErrAlreadyVerified) {
+			writeError(w, "#error", "Ваша личность уже подтверждена")
+			return
+		}
+		writeError(w, "#error", "Ошибка сохранения заявки")
+		return
+	}
+
+	if method == "sms" {
+		if err := h.SMS.Send(v.Phone, fmt.Sprintf("Мой Петропавловск: код подтверждения %s", code)); err != nil {
+			writeError(w, "#error", "Не удалось отправить SMS, попробуйте позже")
+			return
+		}
+	}
+
+	w.Header().Set("HX-Redirect", "/verify")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) VerifyConfirm(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	v, err := h.DB.GetIdentityVerification(userID)
+	if err != nil || v.Method != "sms" || v.Status != "pending" || v.OTPHash == "" {
+		writeError(w, "#error", "Нет активного запроса на подтверждение")
+		return
+	}
+
+	if v.OTPAttempts >= otpMaxAttempts || v.OTPExpiresAt == nil || time.Now().After(*v.OTPExpiresAt) {
+		writeError(w, "#error", "Код истёк или превышено число попыток. Запросите новый код")
+		return
+	}
+
+	if identity.HashOTP(r.FormValue("code")) != v.OTPHash {
+		h.DB.IncrementOTPAttempts(userID)
+		writeError(w, "#error", "Неверный код")
+		return
+	}
+
+	if err := h.DB.ApproveIdentityVerification(v.ID, nil, "Подтверждено по SMS"); err != nil {
+		writeError(w, "#error", "Ошибка сервера")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", "/verify")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) AdminVerifications(w http.ResponseWriter, r *http.Request) {
+	pending, _ := h.DB.GetPendingDocumentVerifications()
+
+	data := map[string]interface{}{
+		"LoggedIn":      true,
+		"IsAdmin":       true,
+		"Verifications": pending,
+	}
+
+	h.Templates.ExecuteTemplate(w, "admin_verifications.html", data)
+}
+
+func (h *Handler) AdminReviewVerification(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	adminID := session.Values["user_id"].(int)
+
+	id, _ := strconv.Atoi(r.FormValue("verification_id"))
+	comment := r.FormValue("comment")
+
+	var err error
+	switch r.FormValue("decision") {
+	case "approve":
+		err = h.DB.ApproveIdentityVerification(id, &adminID, comment)
+	case "reject":
+		if comment == "" {
+			writeError(w, "#error", "Укажите причину отказа")
+			return
+		}
+		err = h.DB.RejectIdentityVerification(id, &adminID, comment)
+	default:
+		writeError(w, "#error", "Неизвестное решение")
+		return
+	}
+	if err != nil {
+		writeError(w, "#error", "Заявка не найдена или уже рассмотрена")
+		return
+	}
+	h.audit(r, "identity.review", "verification", id, nil,
+		map[string]string{"decision": r.FormValue("decision"), "comment": comment})
+
+	w.Header().Set("HX-Redirect", "/admin/verifications")
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminVerificationDocument streams an uploaded identity document. These
+// files live outside uploads/ and are only reachable through this handler,
+// and every view is audited.
+func (h *Handler) AdminVerificationDocument(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	v, err := h.DB.GetIdentityVerificationByID(id)
+	if err != nil || v.DocumentPath == "" {
+		http.Error(w, "Документ не найден", http.StatusNotFound)
+		return
+	}
+
+	key, err := storage.Key(v.DocumentPath)
+	if err != nil {
+		http.Error(w, "Документ не найден", http.StatusNotFound)
+		return
+	}
+	h.audit(r, "identity.document_view", "verification", id, nil, nil)
+
+	// Backends that can sign links send the admin straight to the file
+	// for a few minutes; the local one streams it through the server.
+	if signer, ok := h.Files.(storage.Signer); ok {
+		url, err := signer.SignedURL(key, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Ошибка хранилища", http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "inline")
+	storage.Serve(w, r, h.Files, key, "private, no-store")
+}
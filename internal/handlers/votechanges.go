@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// quarantinedMessage is shown when the voter tries to withdraw, move or
+// replace a vote that is held for a fraud review.
+const quarantinedMessage = "Голос находится на проверке модераторов и не может быть изменён до её завершения"
+
+// VoteWithdraw retracts the user's vote for a project while its voting
+// window is open. The vote's comment stays in the user's vote history.
+func (h *Handler) VoteWithdraw(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	projectID, _ := strconv.Atoi(r.FormValue("project_id"))
+
+	if !h.projectVotingOpen(projectID) {
+		writeError(w, "#vote-error", "Голосование по этому проекту закрыто, голос отозвать нельзя")
+		return
+	}
+
+	if err := h.DB.WithdrawVote(projectID, userID); err != nil {
+		if errors.Is(err, db.ErrVoteQuarantined) {
+			writeError(w, "#vote-error", quarantinedMessage)
+			return
+		}
+		writeError(w, "#vote-error", "Голос не найден или уже отозван")
+		return
+	}
+
+	h.audit(r, "vote.withdraw", "project", projectID, nil, nil)
+	h.DB.RecomputeAchievements(userID)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/projects/%d", projectID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// BallotWithdraw retracts the user's ballot while the cycle is open.
+func (h *Handler) BallotWithdraw(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"].(int)
+
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	cycle, err := h.DB.GetCycle(cycleID)
+	if err != nil || !cycle.VotingOpen(time.Now()) {
+		writeError(w, "#ballot-error", "Голосование в этом цикле закрыто, бюллетень отозвать нельзя")
+		return
+	}
+
+	if err := h.DB.WithdrawBallot(cycleID, userID); err != nil {
+		if errors.Is(err, db.ErrVoteQuarantined) {
+			writeError(w, "#ballot-error", quarantinedMessage)
+			return
+		}
+		writeError(w, "#ballot-error", "Бюллетень не найден или уже отозван")
+		return
+	}
+
+	h.audit(r, "ballot.withdraw", "cycle", cycleID, nil, nil)
+	h.DB.RecomputeAchievements(userID)
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/cycles/%d/ballot", cycleID))
+	w.WriteHeader(http.StatusOK)
+}
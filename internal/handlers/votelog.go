@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"petropavlovsk-budget/internal/db"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/votelog"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ReceiptPage lets anyone look up a receipt code and see the log entries
+// that carry it, whether the ballot currently counts and whether it is
+// covered by the cycle's published root hash.
+func (h *Handler) ReceiptPage(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.Store.Get(r, "session")
+	userID := session.Values["user_id"]
+	userRole := session.Values["role"]
+
+	code := votelog.NormalizeReceipt(r.URL.Query().Get("code"))
+
+	data := map[string]interface{}{
+		"LoggedIn": userID != nil,
+		"IsAdmin":  userRole == "admin",
+		"Code":     code,
+	}
+
+	if code != "" {
+		entries, _ := h.DB.GetVoteLogByReceipt(code)
+		data["Entries"] = entries
+		if len(entries) > 0 {
+			counted := false
+			for _, e := range entries {
+				counted = e.Kind != votelog.KindVoid
+			}
+			data["Counted"] = counted
+
+			if cycleID := entries[0].CycleID; cycleID != 0 {
+				if cycle, err := h.DB.GetCycle(cycleID); err == nil {
+					data["Cycle"] = cycle
+					data["Sealed"] = cycle.SealedAt != nil
+					data["Included"] = cycle.SealedAt != nil && entries[0].Seq <= cycle.RootSeq
+				}
+			}
+		}
+	}
+
+	h.Templates.ExecuteTemplate(w, "receipt.html", data)
+}
+
+// CycleLogExport serves the anonymized log of a cycle together with the
+// published result, in the format cmd/verify-tally reads.
+func (h *Handler) CycleLogExport(w http.ResponseWriter, r *http.Request) {
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	cycle, projects, result, err := h.countCycle(cycleID)
+	if err != nil {
+		http.Error(w, "Цикл не найден", http.StatusNotFound)
+		return
+	}
+
+	entries, err := h.DB.GetVoteLog(cycleID)
+	if err != nil {
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	export := votelog.NewExport(*cycle, eligibleProjects(projects), entries, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cycle-%d-log.json"`, cycleID))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(export)
+}
+
+// eligibleProjects keeps the projects that take part in the count, so the
+// export does not reveal drafts or rejected submissions.
+func eligibleProjects(projects []models.Project) []models.Project {
+	var out []models.Project
+	for _, p := range projects {
+		if p.Tallied() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// AdminSealCycle publishes the cycle's root hash once voting has ended.
+func (h *Handler) AdminSealCycle(w http.ResponseWriter, r *http.Request) {
+	cycleID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	_, err := h.DB.SealCycle(cycleID)
+	switch {
+	case errors.Is(err, db.ErrCycleNotClosed):
+		writeError(w, "#error", "Журнал можно закрыть только после окончания голосования")
+		return
+	case errors.Is(err, db.ErrCycleSealed):
+		writeError(w, "#error", "Корневой хеш этого цикла уже опубликован")
+		return
+	case err != nil:
+		writeError(w, "#error", "Ошибка при публикации корневого хеша")
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/admin/cycles/%d/tally", cycleID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIINFormat   = errors.New("ИИН должен состоять из 12 цифр")
+	ErrIINDate     = errors.New("ИИН содержит некорректную дату рождения")
+	ErrIINCentury  = errors.New("ИИН содержит некорректный признак века и пола")
+	ErrIINChecksum = errors.New("ИИН не прошёл проверку контрольной суммы")
+)
+
+var (
+	iinWeights1 = [11]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	iinWeights2 = [11]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 1, 2}
+)
+
+// NormalizeIIN strips spaces and dashes that people commonly type when
+// copying the number from an ID card.
+func NormalizeIIN(iin string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(iin))
+}
+
+// ValidateIIN checks a Kazakhstan individual identification number: twelve
+// digits, a valid YYMMDD birth date, a century/gender digit 1-6 and the
+// control digit computed with the two-pass mod 11 weighting.
+func ValidateIIN(iin string) error {
+	if len(iin) != 12 {
+		return ErrIINFormat
+	}
+
+	var d [12]int
+	for i, c := range iin {
+		if c < '0' || c > '9' {
+			return ErrIINFormat
+		}
+		d[i] = int(c - '0')
+	}
+
+	century := d[6]
+	if century < 1 || century > 6 {
+		return ErrIINCentury
+	}
+
+	year := 1800 + (century-1)/2*100 + d[0]*10 + d[1]
+	month := time.Month(d[2]*10 + d[3])
+	day := d[4]*10 + d[5]
+	birth := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if birth.Year() != year || birth.Month() != month || birth.Day() != day || birth.After(time.Now()) {
+		return ErrIINDate
+	}
+
+	control := iinControl(d, iinWeights1)
+	if control == 10 {
+		control = iinControl(d, iinWeights2)
+	}
+	if control == 10 || control != d[11] {
+		return ErrIINChecksum
+	}
+
+	return nil
+}
+
+func iinControl(d [12]int, weights [11]int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += d[i] * w
+	}
+	return sum % 11
+}
+
+// HashIIN returns a keyed hash of the IIN. The key comes from IIN_HASH_SECRET
+// and is the same for every user so the hash can carry a UNIQUE constraint;
+// the raw IIN is never stored.
+func HashIIN(iin string) (string, error) {
+	secret := os.Getenv("IIN_HASH_SECRET")
+	if secret == "" {
+		return "", errors.New("IIN_HASH_SECRET is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(iin))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
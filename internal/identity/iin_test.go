@@ -0,0 +1,45 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateIIN(t *testing.T) {
+	tests := []struct {
+		name string
+		iin  string
+		want error
+	}{
+		{"born 1990", "900101300007", nil},
+		{"born 1985, female", "851231404560", nil},
+		{"leap day 2000", "000229501234", nil},
+		{"born 2005", "050615607891", nil},
+		{"control from the second pass", "900101300811", nil},
+		{"too short", "90010130000", ErrIINFormat},
+		{"too long", "9001013000070", ErrIINFormat},
+		{"letters", "90010130000O", ErrIINFormat},
+		{"spaces not normalized", "900101 30000", ErrIINFormat},
+		{"century 0", "900101000007", ErrIINCentury},
+		{"century 7", "900101700007", ErrIINCentury},
+		{"month 13", "901301300007", ErrIINDate},
+		{"31 April", "900431300007", ErrIINDate},
+		{"29 February 1899", "990229100017", ErrIINDate},
+		{"born in the future", "991130600011", ErrIINDate},
+		{"wrong control digit", "900101300008", ErrIINChecksum},
+		{"both passes give 10", "900101300806", ErrIINChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateIIN(tt.iin); !errors.Is(err, tt.want) {
+				t.Errorf("ValidateIIN(%q) = %v, want %v", tt.iin, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIIN(t *testing.T) {
+	if got := NormalizeIIN(" 900101-300 007 "); got != "900101300007" {
+		t.Errorf("NormalizeIIN = %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SMSSender delivers one-time verification codes. Real gateways implement
+// it; FakeSMSSender is for development and must be chosen explicitly.
+type SMSSender interface {
+	Send(phone, message string) error
+}
+
+type FakeSMSSender struct{}
+
+func (FakeSMSSender) Send(phone, message string) error {
+	log.Printf("[sms:fake] %s: %s", phone, message)
+	return nil
+}
+
+// NewSMSSender picks the sender named by SMS_PROVIDER. Only the fake sender
+// ships with the platform; an unset or unknown name is a configuration
+// error, so a deployment never logs codes instead of sending them by
+// accident.
+func NewSMSSender() (SMSSender, error) {
+	switch provider := os.Getenv("SMS_PROVIDER"); provider {
+	case "":
+		return nil, fmt.Errorf("SMS_PROVIDER is not set (use SMS_PROVIDER=fake for development)")
+	case "fake":
+		return FakeSMSSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", provider)
+	}
+}
+
+var kzPhonePattern = regexp.MustCompile(`^\+7\d{10}$`)
+
+// NormalizePhone converts 8XXXXXXXXXX and 7XXXXXXXXXX forms to +7XXXXXXXXXX
+// and reports whether the result looks like a Kazakhstan mobile number.
+func NormalizePhone(phone string) (string, bool) {
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(phone))
+	switch {
+	case strings.HasPrefix(phone, "8") && len(phone) == 11:
+		phone = "+7" + phone[1:]
+	case strings.HasPrefix(phone, "7") && len(phone) == 11:
+		phone = "+" + phone
+	}
+	return phone, kzPhonePattern.MatchString(phone)
+}
+
+func GenerateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func HashOTP(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,239 @@
+// Package imaging turns an uploaded photo into the files that are actually
+// served. The upload is decoded by content rather than trusted by its
+// extension, turned upright according to its EXIF orientation and encoded
+// again at several widths. Re-encoding drops every piece of metadata, so
+// the GPS position and camera details of whoever took the photo never
+// reach the public /uploads directory.
+//
+// Every variant keeps the upload's format and, when asked, gets a WebP
+// copy as well. WebP is encoded with libwebp through cgo, so building the
+// server needs a C compiler.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"petropavlovsk-budget/internal/models"
+
+	"github.com/chai2010/webp"
+)
+
+const (
+	// MaxPixels rejects images that would take too much memory to decode.
+	// Decoding needs about 4 bytes a pixel on top of the upload, so 24
+	// megapixels, more than phone cameras save by default, stays under
+	// 100 MB per photo. Photos of a request are processed one at a time.
+	MaxPixels = 24_000_000
+	// MaxSide rejects absurd aspect ratios and dimensions.
+	MaxSide = 12_000
+
+	jpegQuality = 82
+	webpQuality = 80
+)
+
+var ErrUnsupported = errors.New("файл не является изображением JPG или PNG")
+
+// Variant is one encoded size. WebPData is empty unless Process was asked
+// for WebP copies.
+type Variant struct {
+	models.ImageVariant
+	Data     []byte
+	WebPData []byte
+}
+
+// Result is the processed upload. Ext is the extension matching the
+// detected format, with the leading dot.
+type Result struct {
+	Format   string
+	Ext      string
+	Variants []Variant
+}
+
+// Image describes the result stored at p, with the variants' real sizes.
+func (res *Result) Image(p string) models.Image {
+	img := models.Image{Path: p}
+	for _, v := range res.Variants {
+		img.Variants = append(img.Variants, v.ImageVariant)
+	}
+	return img
+}
+
+// Process decodes r, checks it is a sane JPEG or PNG and encodes it at
+// every size, largest first. JPEGs stay JPEGs and PNGs stay PNGs so that
+// screenshots and plans with transparency keep it. With withWebP every
+// variant is also encoded as WebP.
+func Process(r io.Reader, sizes []models.ImageSize, withWebP bool) (*Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png") {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxSide || cfg.Height > MaxSide || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("изображение слишком большое: %d×%d пикселей", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	img := toNRGBA(src)
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	res := &Result{Format: format, Ext: ".jpg"}
+	if format == "png" {
+		res.Ext = ".png"
+	}
+
+	current := img
+	for _, size := range sizes {
+		if current.Bounds().Dx() > size.Width {
+			// Each size is scaled from the previous one, which is
+			// cheaper than going back to the original every time.
+			current = resize(current, size.Width)
+		}
+
+		var buf bytes.Buffer
+		if format == "png" {
+			err = png.Encode(&buf, current)
+		} else {
+			err = jpeg.Encode(&buf, current, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b := current.Bounds()
+		v := Variant{
+			ImageVariant: models.ImageVariant{Name: size.Name, Width: b.Dx(), Height: b.Dy()},
+			Data:         buf.Bytes(),
+		}
+		if withWebP {
+			if v.WebPData, err = encodeWebP(current); err != nil {
+				return nil, err
+			}
+			v.WebP = true
+		}
+		res.Variants = append(res.Variants, v)
+	}
+
+	return res, nil
+}
+
+// encodeWebP encodes img as lossy WebP. libwebp expects straight alpha,
+// which is what NRGBA holds, so the pixels are handed over as they are;
+// the library would otherwise convert NRGBA to premultiplied RGBA and
+// darken semi-transparent edges.
+func encodeWebP(img *image.NRGBA) ([]byte, error) {
+	return webp.EncodeRGBA(&image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}, webpQuality)
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if img, ok := src.(*image.NRGBA); ok && img.Bounds().Min == (image.Point{}) {
+		return img
+	}
+	b := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	return img
+}
+
+// resize scales img down to width, averaging every source pixel that
+// falls into a destination pixel.
+func resize(img *image.NRGBA, width int) *image.NRGBA {
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			// Colours are weighted by alpha so transparent pixels do
+			// not darken the edges of a PNG.
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := img.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					pa := uint64(img.Pix[i+3])
+					r += uint64(img.Pix[i]) * pa
+					g += uint64(img.Pix[i+1]) * pa
+					b += uint64(img.Pix[i+2]) * pa
+					a += pa
+					n++
+					i += 4
+				}
+			}
+
+			c := color.NRGBA{}
+			if a > 0 {
+				c = color.NRGBA{R: uint8(r / a), G: uint8(g / a), B: uint8(b / a), A: uint8(a / n)}
+			}
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (1–8) so the image is stored the
+// way it should be displayed.
+func orient(img *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			si := img.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,109 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"petropavlovsk-budget/internal/models"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+func encodePNG(t *testing.T, w, h int, c color.NRGBA) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessRecordsRealWidths(t *testing.T) {
+	// Narrower than the full size and the card size: neither is enlarged.
+	data := encodePNG(t, 600, 300, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+
+	res, err := Process(bytes.NewReader(data), models.ImageSizes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.ImageVariant{
+		{Name: "full", Width: 600, Height: 300},
+		{Name: "card", Width: 600, Height: 300},
+		{Name: "thumb", Width: 320, Height: 160},
+	}
+	if len(res.Variants) != len(want) {
+		t.Fatalf("got %d variants, want %d", len(res.Variants), len(want))
+	}
+	for i, v := range res.Variants {
+		if v.ImageVariant != want[i] {
+			t.Errorf("variant %d = %+v, want %+v", i, v.ImageVariant, want[i])
+		}
+		if v.WebPData != nil {
+			t.Errorf("variant %s has WebP data without asking", v.Name)
+		}
+	}
+
+	img := res.Image("/uploads/1/photo.png")
+	if got, want := img.Srcset(), "/uploads/1/photo_thumb.png 320w, /uploads/1/photo_card.png 600w"; got != want {
+		t.Errorf("Srcset() = %q, want %q", got, want)
+	}
+}
+
+func TestProcessEncodesWebP(t *testing.T) {
+	// Half-transparent pixels must keep their colour: the encoder is given
+	// straight alpha, not premultiplied.
+	data := encodePNG(t, 400, 200, color.NRGBA{R: 200, G: 100, B: 50, A: 128})
+
+	res, err := Process(bytes.NewReader(data), models.ImageSizes, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range res.Variants {
+		if !v.WebP || len(v.WebPData) == 0 {
+			t.Fatalf("variant %s has no WebP copy", v.Name)
+		}
+		w, h, hasAlpha, err := webp.GetInfo(v.WebPData)
+		if err != nil {
+			t.Fatalf("variant %s: %v", v.Name, err)
+		}
+		if w != v.Width || h != v.Height || !hasAlpha {
+			t.Errorf("variant %s: WebP is %d×%d alpha=%v, want %d×%d with alpha", v.Name, w, h, hasAlpha, v.Width, v.Height)
+		}
+	}
+
+	decoded, err := webp.DecodeRGBA(res.Variants[0].WebPData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DecodeRGBA returns straight alpha in an image.RGBA.
+	i := decoded.PixOffset(100, 100)
+	r, a := int(decoded.Pix[i]), int(decoded.Pix[i+3])
+	if a < 120 || a > 136 || r < 185 {
+		t.Errorf("pixel decoded as R=%d A=%d, want about R=200 A=128", r, a)
+	}
+}
+
+func TestProcessRejectsLargeImages(t *testing.T) {
+	// A blank 6000×5000 PNG compresses to a few kilobytes; the size check
+	// reads only its header.
+	var buf bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 6000, 5000))
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Process(&buf, models.ImageSizes, false); err == nil {
+		t.Error("30-megapixel image accepted")
+	}
+
+	if _, err := Process(bytes.NewReader([]byte("GIF89a")), models.ImageSizes, false); err != ErrUnsupported {
+		t.Errorf("non-image: err = %v, want ErrUnsupported", err)
+	}
+}
@@ -1,11 +1,60 @@
 package middleware
 
 import (
+        "errors"
+        "log"
         "net/http"
+        "petropavlovsk-budget/internal/models"
 
         "github.com/gorilla/sessions"
+        "github.com/jackc/pgx/v5"
 )
 
+// UserLoader looks up the account behind a session.
+type UserLoader interface {
+        GetUserByID(id int) (*models.User, error)
+}
+
+// LoadUser checks the signed-in account on every request. A session whose
+// account has been disabled or deleted is ended; otherwise the role and
+// email in the session are refreshed, so that a role change made with
+// petroctl takes effect at once rather than at the next login. Handlers
+// and the Require middleware below read the refreshed values.
+func LoadUser(store *sessions.CookieStore, users UserLoader) func(http.Handler) http.Handler {
+        return func(next http.Handler) http.Handler {
+                return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                        session, _ := store.Get(r, "session")
+                        userID, ok := session.Values["user_id"].(int)
+                        if !ok {
+                                next.ServeHTTP(w, r)
+                                return
+                        }
+
+                        user, err := users.GetUserByID(userID)
+                        if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+                                log.Printf("load session user %d: %v", userID, err)
+                                http.Error(w, "Сервис временно недоступен", http.StatusServiceUnavailable)
+                                return
+                        }
+
+                        if user == nil || user.Disabled {
+                                for key := range session.Values {
+                                        delete(session.Values, key)
+                                }
+                                session.Save(r, w)
+                        } else if session.Values["role"] != user.Role || session.Values["email"] != user.Email {
+                                session.Values["role"] = user.Role
+                                session.Values["email"] = user.Email
+                                session.Save(r, w)
+                        }
+
+                        next.ServeHTTP(w, r)
+                })
+        }
+}
+
+// RequireAuth lets through only signed-in users. It relies on LoadUser
+// having ended the sessions of disabled accounts.
 func RequireAuth(store *sessions.CookieStore) func(http.Handler) http.Handler {
         return func(next http.Handler) http.Handler {
                 return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,6 +71,7 @@ func RequireAuth(store *sessions.CookieStore) func(http.Handler) http.Handler {
         }
 }
 
+// RequireAdmin lets through only admins, by the role LoadUser refreshed.
 func RequireAdmin(store *sessions.CookieStore) func(http.Handler) http.Handler {
         return func(next http.Handler) http.Handler {
                 return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
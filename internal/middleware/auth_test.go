@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"petropavlovsk-budget/internal/models"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx/v5"
+)
+
+type fakeUsers map[int]*models.User
+
+func (f fakeUsers) GetUserByID(id int) (*models.User, error) {
+	if u, ok := f[id]; ok {
+		return u, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+// signedIn returns a request carrying the cookie of a session signed in as
+// userID with the given role.
+func signedIn(t *testing.T, store *sessions.CookieStore, userID int, role string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.Get(r, "session")
+	session.Values["user_id"] = userID
+	session.Values["role"] = role
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestRequireAdminUsesCurrentAccount(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	users := fakeUsers{
+		1: {ID: 1, Email: "admin@example.kz", Role: "admin"},
+		2: {ID: 2, Email: "demoted@example.kz", Role: "citizen"},
+		3: {ID: 3, Email: "disabled@example.kz", Role: "admin", Disabled: true},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := LoadUser(store, users)(RequireAdmin(store)(ok))
+
+	tests := []struct {
+		name   string
+		userID int
+		want   int
+	}{
+		{"admin", 1, http.StatusOK},
+		{"demoted since login", 2, http.StatusForbidden},
+		{"disabled since login", 3, http.StatusSeeOther},
+		{"deleted since login", 4, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, signedIn(t, store, tt.userID, "admin"))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadUserRefreshesRole(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	users := fakeUsers{5: {ID: 5, Email: "staff@example.kz", Role: "department"}}
+
+	var role interface{}
+	handler := LoadUser(store, users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session")
+		role = session.Values["role"]
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), signedIn(t, store, 5, "citizen"))
+
+	if role != "department" {
+		t.Errorf("handler saw role %v, want department", role)
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies(" 10.0.0.1, 192.168.0.0/16,,::1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("got %d networks, want 3", len(nets))
+	}
+	if got := nets[0].String(); got != "10.0.0.1/32" {
+		t.Errorf("single IP parsed as %s", got)
+	}
+
+	if nets, err := ParseTrustedProxies(""); err != nil || len(nets) != 0 {
+		t.Errorf("empty list: %v, %v", nets, err)
+	}
+	for _, bad := range []string{"proxy.local", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{"direct client ignores headers", "203.0.113.5:4000", []string{"1.2.3.4"}, "1.2.3.4", "203.0.113.5:4000"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"spoofed entry before the real one", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"198.51.100.7, 10.1.1.1"}, "", "198.51.100.7"},
+		{"repeated header", "10.0.0.2:4000", []string{"1.2.3.4", "198.51.100.7"}, "", "198.51.100.7"},
+		{"garbage hop", "10.0.0.2:4000", []string{"1.2.3.4, nonsense"}, "", "10.0.0.2:4000"},
+		{"only trusted hops", "10.0.0.2:4000", []string{"10.0.0.3"}, "", "10.0.0.2:4000"},
+		{"x-real-ip from trusted proxy", "10.0.0.2:4000", nil, "198.51.100.7", "198.51.100.7"},
+		{"no headers", "10.0.0.2:4000", nil, "", "10.0.0.2:4000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no trusted proxies", func(t *testing.T) {
+		var got string
+		h := RealIP(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.RemoteAddr
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.2:4000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.7")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != "10.0.0.2:4000" {
+			t.Errorf("RemoteAddr = %q", got)
+		}
+	})
+}
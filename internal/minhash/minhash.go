@@ -0,0 +1,167 @@
+// Package minhash fingerprints short texts so near-identical vote comments
+// can be found without comparing every pair. Signatures are compared
+// directly; LSH band keys let the database narrow down candidates.
+package minhash
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// NumHashes is the signature length. Bands*Rows must equal it.
+	NumHashes = 64
+	Bands     = 16
+	Rows      = 4
+
+	// ShingleSize is the length in runes of the character shingles.
+	ShingleSize = 5
+
+	// DuplicateThreshold is the estimated similarity from which two vote
+	// comments are treated as copies of each other.
+	DuplicateThreshold = 0.8
+)
+
+type Signature []uint64
+
+// Empty reports whether the signature was computed from text with no
+// shingles, e.g. a comment of only punctuation. Empty signatures are
+// similar to nothing, so such comments are never flagged as copies.
+func (s Signature) Empty() bool {
+	for _, v := range s {
+		if v != ^uint64(0) {
+			return false
+		}
+	}
+	return true
+}
+
+var coefficients = func() [NumHashes][2]uint64 {
+	var c [NumHashes][2]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	next := func() uint64 {
+		// splitmix64: fixed sequence so signatures stay comparable across
+		// releases and Go versions.
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	for i := range c {
+		c[i] = [2]uint64{next() | 1, next()}
+	}
+	return c
+}()
+
+// Normalize lowercases the text and collapses everything that is not a
+// letter or digit into single spaces, so punctuation and spacing tricks do
+// not change the fingerprint.
+func Normalize(text string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func shingles(text string) map[uint64]struct{} {
+	runes := []rune(Normalize(text))
+	set := map[uint64]struct{}{}
+	if len(runes) == 0 {
+		return set
+	}
+	if len(runes) < ShingleSize {
+		set[hashString(string(runes))] = struct{}{}
+		return set
+	}
+	for i := 0; i+ShingleSize <= len(runes); i++ {
+		set[hashString(string(runes[i:i+ShingleSize]))] = struct{}{}
+	}
+	return set
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Compute returns the MinHash signature of text. Empty text yields a
+// signature of all ones; see Empty.
+func Compute(text string) Signature {
+	sig := make(Signature, NumHashes)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for sh := range shingles(text) {
+		for i, c := range coefficients {
+			if v := c[0]*sh + c[1]; v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// Similarity estimates the Jaccard similarity of the shingle sets behind
+// two signatures.
+func Similarity(a, b Signature) float64 {
+	if len(a) != NumHashes || len(b) != NumHashes || a.Empty() || b.Empty() {
+		return 0
+	}
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / NumHashes
+}
+
+// BandKeys returns one key per LSH band. Two texts with similarity s share
+// at least one key with probability 1-(1-s^Rows)^Bands, about 0.99 at 0.6.
+func BandKeys(sig Signature) []int64 {
+	if len(sig) != NumHashes || sig.Empty() {
+		return nil
+	}
+	keys := make([]int64, 0, Bands)
+	buf := make([]byte, 8)
+	for band := 0; band < Bands; band++ {
+		h := fnv.New64a()
+		binary.LittleEndian.PutUint64(buf, uint64(band))
+		h.Write(buf)
+		for _, v := range sig[band*Rows : (band+1)*Rows] {
+			binary.LittleEndian.PutUint64(buf, v)
+			h.Write(buf)
+		}
+		keys = append(keys, int64(h.Sum64()))
+	}
+	return keys
+}
+
+// ToInt64s and FromInt64s convert signatures for BIGINT[] columns.
+func (s Signature) ToInt64s() []int64 {
+	out := make([]int64, len(s))
+	for i, v := range s {
+		out[i] = int64(v)
+	}
+	return out
+}
+
+func FromInt64s(values []int64) Signature {
+	sig := make(Signature, len(values))
+	for i, v := range values {
+		sig[i] = uint64(v)
+	}
+	return sig
+}
@@ -0,0 +1,79 @@
+package minhash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	if got, want := Normalize("  Нужна   НОВАЯ площадка!!! Во-дворе, срочно "), "нужна новая площадка во дворе срочно"; got != want {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	base := "Поддерживаю проект, во дворе давно нужна новая детская площадка с качелями и горкой"
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"identical", base, base, 1, 1},
+		{"punctuation and case", base, "ПОДДЕРЖИВАЮ проект... во дворе давно нужна новая детская площадка, с качелями и горкой!", 1, 1},
+		{"one word changed", base, "Поддерживаю проект, во дворе давно нужна новая детская площадка с качелями и песочницей", DuplicateThreshold - 0.15, 0.99},
+		{"unrelated", base, "Освещение на остановке не работает уже полгода, вечером там опасно ходить", 0, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Similarity(Compute(tt.a), Compute(tt.b))
+			if got < tt.min || got > tt.max {
+				t.Errorf("Similarity = %.2f, want between %.2f and %.2f", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestEmptySignatureMatchesNothing(t *testing.T) {
+	empty := Compute("!!! ...")
+	if !empty.Empty() {
+		t.Fatal("signature of punctuation is not empty")
+	}
+	if Compute("да").Empty() {
+		t.Error("signature of a short word is empty")
+	}
+	if got := Similarity(empty, Compute("")); got != 0 {
+		t.Errorf("two empty comments have similarity %.2f, want 0", got)
+	}
+	if keys := BandKeys(empty); keys != nil {
+		t.Errorf("empty signature has band keys %v", keys)
+	}
+	if got := Similarity(Signature{1, 2, 3}, Signature{1, 2, 3}); got != 0 {
+		t.Errorf("short signatures have similarity %.2f, want 0", got)
+	}
+}
+
+func TestBandKeysShareBandForCopies(t *testing.T) {
+	a := BandKeys(Compute("Поддерживаю проект, во дворе давно нужна новая детская площадка"))
+	b := BandKeys(Compute("поддерживаю проект во дворе давно нужна новая детская площадка!"))
+	if len(a) != Bands || !reflect.DeepEqual(a, b) {
+		t.Errorf("copies have different band keys:\n%v\n%v", a, b)
+	}
+
+	c := BandKeys(Compute("Освещение на остановке не работает уже полгода"))
+	shared := map[int64]bool{}
+	for _, k := range a {
+		shared[k] = true
+	}
+	for _, k := range c {
+		if shared[k] {
+			t.Errorf("unrelated comments share band key %d", k)
+		}
+	}
+}
+
+func TestInt64sRoundTrip(t *testing.T) {
+	sig := Compute("Нужна новая площадка")
+	if got := FromInt64s(sig.ToInt64s()); !reflect.DeepEqual(got, sig) {
+		t.Error("signature changed after BIGINT[] round trip")
+	}
+}
@@ -1,6 +1,12 @@
 package models
 
-import "time"
+import (
+        "encoding/json"
+        "fmt"
+        "path"
+        "strings"
+        "time"
+)
 
 type User struct {
         ID           int       `json:"id"`
@@ -8,9 +14,30 @@ type User struct {
         Nickname     string    `json:"nickname"`
         PasswordHash string    `json:"-"`
         Role         string    `json:"role"`
+        Department   string    `json:"department,omitempty"`
+        Disabled     bool      `json:"disabled"`
+        Verified     bool      `json:"verified"`
         CreatedAt    time.Time `json:"created_at"`
 }
 
+type IdentityVerification struct {
+        ID            int        `json:"id"`
+        UserID        int        `json:"user_id"`
+        UserEmail     string     `json:"user_email,omitempty"`
+        IINHash       string     `json:"-"`
+        Method        string     `json:"method"`
+        Status        string     `json:"status"`
+        Phone         string     `json:"phone,omitempty"`
+        DocumentPath  string     `json:"-"`
+        OTPHash       string     `json:"-"`
+        OTPExpiresAt  *time.Time `json:"-"`
+        OTPAttempts   int        `json:"-"`
+        ReviewComment string     `json:"review_comment,omitempty"`
+        ReviewerID    *int       `json:"reviewer_id,omitempty"`
+        CreatedAt     time.Time  `json:"created_at"`
+        ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
 type Project struct {
         ID          int        `json:"id"`
         Title       string     `json:"title"`
@@ -20,22 +47,200 @@ type Project struct {
         Budget      int        `json:"budget"`
         Lat         float64    `json:"lat"`
         Lng         float64    `json:"lng"`
-        Images      []string   `json:"images"`
+        Images      []Image    `json:"images"`
         Status      string     `json:"status"`
         AIAnalysis  string     `json:"ai_analysis,omitempty"`
         VoteStart   *time.Time `json:"vote_start,omitempty"`
         VoteEnd     *time.Time `json:"vote_end,omitempty"`
         UserID      int        `json:"user_id"`
+        CycleID     *int       `json:"cycle_id,omitempty"`
         CreatedAt   time.Time  `json:"created_at"`
         VoteCount   int        `json:"vote_count"`
 }
 
+const (
+        BallotSingle    = "single"
+        BallotKnapsack  = "knapsack"
+        BallotApproval  = "approval"
+        BallotRanked    = "ranked"
+        BallotQuadratic = "quadratic"
+)
+
+type BudgetCycle struct {
+        ID         int        `json:"id"`
+        Title      string     `json:"title"`
+        Budget     int        `json:"budget"`
+        BallotType string     `json:"ballot_type"`
+        MaxPicks   int        `json:"max_picks,omitempty"`
+        Credits    int        `json:"credits,omitempty"`
+        VoteStart  *time.Time `json:"vote_start,omitempty"`
+        VoteEnd    *time.Time `json:"vote_end,omitempty"`
+        RootHash   string     `json:"root_hash,omitempty"`
+        RootSeq    int        `json:"root_seq,omitempty"`
+        SealedAt   *time.Time `json:"sealed_at,omitempty"`
+        CreatedAt  time.Time  `json:"created_at"`
+        // HasVotes is set once the first ballot or vote is cast; from then
+        // on the budget and ballot rules can no longer change.
+        HasVotes bool `json:"-"`
+}
+
+// VotingOpen reports whether now falls inside the cycle's voting window.
+// Missing bounds are treated as open-ended.
+func (c *BudgetCycle) VotingOpen(now time.Time) bool {
+        if c.VoteStart != nil && now.Before(*c.VoteStart) {
+                return false
+        }
+        if c.VoteEnd != nil && now.After(*c.VoteEnd) {
+                return false
+        }
+        return true
+}
+
+// VotingStarted reports whether the cycle has been voted on or its voting
+// window has opened; a cycle without a start date is open from the
+// beginning. From then on projects cannot join or leave it and their
+// budgets are fixed.
+func (c *BudgetCycle) VotingStarted(now time.Time) bool {
+        return c.HasVotes || c.VoteStart == nil || !now.Before(*c.VoteStart)
+}
+
+// VotingClosed reports whether the cycle's voting window has ended.
+func (c *BudgetCycle) VotingClosed(now time.Time) bool {
+        return c.VoteEnd != nil && !now.Before(*c.VoteEnd)
+}
+
+// BallotTypeLabel is the human-readable name of the cycle's voting method.
+func (c *BudgetCycle) BallotTypeLabel() string {
+        switch c.BallotType {
+        case BallotKnapsack:
+                return "Бюллетень с бюджетом"
+        case BallotApproval:
+                return "Одобрительное голосование"
+        case BallotRanked:
+                return "Ранжирование проектов"
+        case BallotQuadratic:
+                return "Квадратичное голосование"
+        default:
+                return "Один голос за проект"
+        }
+}
+
+// DayCount is the number of votes or ballots cast on one day.
+type DayCount struct {
+        Date  time.Time `json:"date"`
+        Count int       `json:"count"`
+}
+
+// Vote channels record how a vote reached the system.
+const (
+        ChannelOnline = "online"
+        ChannelPaper  = "paper"
+        ChannelKiosk  = "kiosk"
+)
+
+// OfflineBallot is a paper or kiosk ballot recorded by staff for a voter
+// identified by IIN. CycleID is 0 for projects outside any cycle; for
+// single-vote cycles each entry becomes a separate vote.
+type OfflineBallot struct {
+        IINHash string
+        CycleID int
+        Entries []BallotEntry
+        Channel string
+        StaffID int
+        BatchID *int
+}
+
+// PaperBatch is one uploaded CSV file of paper ballots.
+type PaperBatch struct {
+        ID         int       `json:"id"`
+        AdminID    int       `json:"admin_id"`
+        AdminEmail string    `json:"admin_email"`
+        Filename   string    `json:"filename"`
+        Rows       int       `json:"rows"`
+        Imported   int       `json:"imported"`
+        Skipped    int       `json:"skipped"`
+        CreatedAt  time.Time `json:"created_at"`
+}
+
+// VoteChange is the audit record of a withdrawn or moved vote or ballot.
+// Comment keeps the text of the vote as it was at the time of the change.
+type VoteChange struct {
+        ID             int       `json:"id"`
+        UserID         int       `json:"user_id"`
+        Action         string    `json:"action"`
+        VoteID         *int      `json:"vote_id,omitempty"`
+        BallotID       *int      `json:"ballot_id,omitempty"`
+        ProjectID      *int      `json:"project_id,omitempty"`
+        ProjectTitle   string    `json:"project_title,omitempty"`
+        ToProjectID    *int      `json:"to_project_id,omitempty"`
+        ToProjectTitle string    `json:"to_project_title,omitempty"`
+        CycleID        *int      `json:"cycle_id,omitempty"`
+        CycleTitle     string    `json:"cycle_title,omitempty"`
+        Comment        string    `json:"comment,omitempty"`
+        CreatedAt      time.Time `json:"created_at"`
+}
+
+const (
+        VoteChangeWithdraw       = "withdraw"
+        VoteChangeMove           = "move"
+        VoteChangeBallotWithdraw = "ballot_withdraw"
+        VoteChangeBallotReplace  = "ballot_replace"
+)
+
+type Ballot struct {
+        ID         int           `json:"id"`
+        CycleID    int           `json:"cycle_id"`
+        UserID     int           `json:"user_id"`
+        BallotType string        `json:"ballot_type"`
+        Entries    []BallotEntry `json:"entries"`
+        Receipt    string        `json:"-"`
+        Channel    string        `json:"channel"`
+        RecordedBy *int          `json:"-"`
+        BatchID    *int          `json:"-"`
+        IP         string        `json:"-"`
+        UserAgent  string        `json:"-"`
+        CreatedAt  time.Time     `json:"created_at"`
+}
+
+type BallotEntry struct {
+        ProjectID int `json:"project_id"`
+        Value     int `json:"value"`
+}
+
 type Vote struct {
-        ID        int       `json:"id"`
-        ProjectID int       `json:"project_id"`
-        UserID    int       `json:"user_id"`
-        Comment   string    `json:"comment"`
-        CreatedAt time.Time `json:"created_at"`
+        ID                  int       `json:"id"`
+        ProjectID           int       `json:"project_id"`
+        UserID              int       `json:"user_id"`
+        Comment             string    `json:"comment"`
+        IP                  string    `json:"-"`
+        UserAgent           string    `json:"-"`
+        Fingerprint         []uint64  `json:"-"`
+        DuplicateOf         *int      `json:"duplicate_of,omitempty"`
+        DuplicateSimilarity float64   `json:"duplicate_similarity,omitempty"`
+        Receipt             string    `json:"-"`
+        Channel             string    `json:"channel"`
+        RecordedBy          *int      `json:"-"`
+        BatchID             *int      `json:"-"`
+        CreatedAt           time.Time `json:"created_at"`
+}
+
+// SimilarVote is an existing vote whose comment is close to a new one.
+type SimilarVote struct {
+        VoteID     int
+        ProjectID  int
+        UserID     int
+        Similarity float64
+}
+
+type VoteQuarantineEntry struct {
+        ID         int       `json:"id"`
+        VoteID     int       `json:"vote_id"`
+        BallotID   int       `json:"ballot_id"`
+        Action     string    `json:"action"`
+        Reason     string    `json:"reason"`
+        Score      int       `json:"score"`
+        AdminEmail string    `json:"admin_email"`
+        CreatedAt  time.Time `json:"created_at"`
 }
 
 type ProjectSubmission struct {
@@ -48,22 +253,562 @@ type ProjectSubmission struct {
         Lng         float64
 }
 
+// VotingOpen reports whether the project is in voting and now falls inside
+// its own voting window. Projects in a cycle follow the cycle's window
+// instead. Missing bounds are treated as open-ended.
+func (p *Project) VotingOpen(now time.Time) bool {
+        if p.Status != "voting" {
+                return false
+        }
+        if p.VoteStart != nil && now.Before(*p.VoteStart) {
+                return false
+        }
+        if p.VoteEnd != nil && now.After(*p.VoteEnd) {
+                return false
+        }
+        return true
+}
+
+// AuthorEditableStatuses are the statuses in which the author may still
+// change the project's text, budget and location.
+var AuthorEditableStatuses = []string{"moderation", "changes_requested"}
+
+func (p *Project) EditableByAuthor() bool {
+        for _, s := range AuthorEditableStatuses {
+                if p.Status == s {
+                        return true
+                }
+        }
+        return false
+}
+
+// TalliedStatuses are the statuses of projects that take part in their
+// cycle's count: on the ballot, and afterwards while selected ones are
+// carried out, so a recount gives the same result later.
+var TalliedStatuses = []string{"voting", "selected", "in_progress", "done"}
+
+func (p *Project) Tallied() bool {
+        for _, s := range TalliedStatuses {
+                if p.Status == s {
+                        return true
+                }
+        }
+        return false
+}
+
+// ProjectDraft is an unfinished submission saved as the author types.
+// Every field may still be empty; Lat and Lng are nil until a point is
+// picked on the map.
+type ProjectDraft struct {
+        ID          int       `json:"id"`
+        UserID      int       `json:"user_id"`
+        Title       string    `json:"title"`
+        Description string    `json:"description"`
+        Category    string    `json:"category"`
+        District    string    `json:"district"`
+        Budget      int       `json:"budget"`
+        Lat         *float64  `json:"lat,omitempty"`
+        Lng         *float64  `json:"lng,omitempty"`
+        UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProjectRevision records one edit of a project: who made it, when and
+// why, and the fields as they were before it.
+type ProjectRevision struct {
+        ID             int       `json:"id"`
+        ProjectID      int       `json:"project_id"`
+        EditorID       int       `json:"editor_id"`
+        EditorNickname string    `json:"editor_nickname"`
+        Reason         string    `json:"reason"`
+        Title          string    `json:"title"`
+        Description    string    `json:"description"`
+        Category       string    `json:"category"`
+        District       string    `json:"district"`
+        Budget         int       `json:"budget"`
+        Lat            float64   `json:"lat"`
+        Lng            float64   `json:"lng"`
+        CreatedAt      time.Time `json:"created_at"`
+}
+
+// Fields returns the revision's copy of the editable project fields.
+func (r ProjectRevision) Fields() ProjectSubmission {
+        return ProjectSubmission{
+                Title:       r.Title,
+                Description: r.Description,
+                Category:    r.Category,
+                District:    r.District,
+                Budget:      r.Budget,
+                Lat:         r.Lat,
+                Lng:         r.Lng,
+        }
+}
+
+// ChangeRequest is a moderator sending a project back to its author with
+// a list of things to fix. Before holds the project's fields at that
+// moment, so the resubmission can be compared with them.
+type ChangeRequest struct {
+        ID            int               `json:"id"`
+        ProjectID     int               `json:"project_id"`
+        RequestedBy   int               `json:"requested_by"`
+        Items         []string          `json:"items"`
+        Comment       string            `json:"comment,omitempty"`
+        Before        ProjectSubmission `json:"-"`
+        CreatedAt     time.Time         `json:"created_at"`
+        ResubmittedAt *time.Time        `json:"resubmitted_at,omitempty"`
+}
+
+// ModerationItem is a project waiting in the moderation queue. QueuedAt
+// is when it entered the queue, or was last resubmitted. The claim fields
+// are set only while a moderator's claim is in force.
+type ModerationItem struct {
+        Project
+        QueuedAt       time.Time
+        ClaimedBy      *int
+        ClaimedByName  string
+        ClaimExpiresAt *time.Time
+        RejectProposal *RejectionProposal
+}
+
+// RejectionProposal is a first moderator's rejection waiting for a second
+// moderator to confirm it.
+type RejectionProposal struct {
+        ProposedBy     int       `json:"proposed_by"`
+        ProposedByName string    `json:"proposed_by_name"`
+        ReasonCodes    []string  `json:"reason_codes"`
+        ReasonTitles   []string  `json:"reason_titles"`
+        Comment        string    `json:"comment"`
+        CreatedAt      time.Time `json:"created_at"`
+}
+
+// ModeratorStats sums up one moderator's decisions over a period.
+// AvgDecision runs from entering the queue to the decision, AvgHandling
+// from the claim to the decision.
+type ModeratorStats struct {
+        ModeratorID      int           `json:"moderator_id"`
+        Nickname         string        `json:"nickname"`
+        Approved         int           `json:"approved"`
+        ChangesRequested int           `json:"changes_requested"`
+        Rejected         int           `json:"rejected"`
+        Total            int           `json:"total"`
+        WithinSLA        int           `json:"within_sla"`
+        AvgDecision      time.Duration `json:"avg_decision"`
+        AvgHandling      time.Duration `json:"avg_handling"`
+}
+
+type Notification struct {
+        ID        int        `json:"id"`
+        UserID    int        `json:"user_id"`
+        Message   string     `json:"message"`
+        Link      string     `json:"link,omitempty"`
+        CreatedAt time.Time  `json:"created_at"`
+        ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// AuditEntry is one recorded administrative or sensitive action. ActorID
+// is nil for failed logins and command-line tools; ActorName keeps who it
+// was even if the account is later renamed. Before and After are JSON
+// snapshots of the target, when the action has them.
+type AuditEntry struct {
+        ID         int64           `json:"id"`
+        ActorID    *int            `json:"actor_id"`
+        ActorName  string          `json:"actor_name"`
+        Action     string          `json:"action"`
+        TargetType string          `json:"target_type"`
+        TargetID   string          `json:"target_id"`
+        Before     json.RawMessage `json:"before,omitempty"`
+        After      json.RawMessage `json:"after,omitempty"`
+        IP         string          `json:"ip"`
+        UserAgent  string          `json:"user_agent"`
+        CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows the audit log; empty fields match everything.
+// Actor matches the actor's ID or part of their name.
+type AuditFilter struct {
+        Actor      string
+        Action     string
+        TargetType string
+        TargetID   string
+        From       *time.Time
+        To         *time.Time
+}
+
+// Comment is a remark in a project's discussion. Status is "published",
+// "held" (stopped by screening until a moderator looks at it), "hidden" or
+// "deleted"; only published comments are shown in full to everyone.
+// Replies have a ParentID and a Depth of 1 or 2. Useful counts the users
+// who found the comment useful; Reacted is whether the viewer did.
 type Comment struct {
-        ID        int       `json:"id"`
-        ProjectID int       `json:"project_id"`
-        UserID    int       `json:"user_id"`
-        UserEmail string    `json:"user_email"`
-        Content   string    `json:"content"`
-        CreatedAt time.Time `json:"created_at"`
+        ID               int       `json:"id"`
+        ProjectID        int       `json:"project_id"`
+        UserID           int       `json:"user_id"`
+        UserEmail        string    `json:"user_email"`
+        UserRole         string    `json:"user_role"`
+        ParentID         *int      `json:"parent_id,omitempty"`
+        Depth            int       `json:"depth"`
+        Content          string    `json:"content"`
+        Status           string    `json:"status"`
+        ScreeningReason  string    `json:"screening_reason,omitempty"`
+        ModerationReason string    `json:"moderation_reason,omitempty"`
+        Useful           int       `json:"useful"`
+        Reacted          bool      `json:"-"`
+        CreatedAt        time.Time `json:"created_at"`
+}
+
+// CommentQueueItem is a comment waiting for a moderator: held by
+// screening or reported by users.
+type CommentQueueItem struct {
+        Comment
+        ProjectTitle  string   `json:"project_title"`
+        Reports       int      `json:"reports"`
+        ReportReasons []string `json:"report_reasons"`
 }
 
 type ProjectStatusHistory struct {
-        ID        int       `json:"id"`
-        ProjectID int       `json:"project_id"`
-        Status    string    `json:"status"`
-        Comment   string    `json:"comment"`
-        AdminID   int       `json:"admin_id"`
-        CreatedAt time.Time `json:"created_at"`
+        ID          int               `json:"id"`
+        ProjectID   int               `json:"project_id"`
+        Status      string            `json:"status"`
+        Comment     string            `json:"comment"`
+        AdminID     int               `json:"admin_id"`
+        ReasonCodes []string          `json:"reason_codes"`
+        Reasons     []RejectionReason `json:"reasons,omitempty"`
+        CreatedAt   time.Time         `json:"created_at"`
+}
+
+// RejectionReason is a canned explanation a moderator can pick when
+// rejecting a project, in Russian and Kazakh. Status history stores only
+// the Code, so the texts can be corrected later without losing the
+// statistics.
+type RejectionReason struct {
+        ID       int    `json:"id"`
+        Code     string `json:"code"`
+        TitleRu  string `json:"title_ru"`
+        TitleKk  string `json:"title_kk"`
+        TextRu   string `json:"text_ru"`
+        TextKk   string `json:"text_kk"`
+        Position int    `json:"position"`
+        Active   bool   `json:"active"`
+}
+
+// RejectionReasonCount is how many rejections cited a reason; an empty
+// Code counts rejections that cited none.
+type RejectionReasonCount struct {
+        Code    string `json:"code"`
+        TitleRu string `json:"title_ru"`
+        Count   int    `json:"count"`
+}
+
+// Feasibility assessments a city department gives a project.
+const (
+        FeasibilityFeasible    = "feasible"
+        FeasibilityConditional = "conditional"
+        FeasibilityStudy       = "study"
+        FeasibilityInfeasible  = "infeasible"
+)
+
+var Feasibilities = []string{FeasibilityFeasible, FeasibilityConditional, FeasibilityStudy, FeasibilityInfeasible}
+
+// RespondableStatuses are the statuses in which city departments answer a
+// project: while it is reviewed and while residents vote on it.
+var RespondableStatuses = []string{"moderation", "voting"}
+
+func (p *Project) RespondableByDepartment() bool {
+        for _, s := range RespondableStatuses {
+                if p.Status == s {
+                        return true
+                }
+        }
+        return false
+}
+
+// OfficialResponse is a city department's public answer on a project,
+// written by a user with the department role. Each department keeps one
+// answer per project. EstimatedCost is the department's own estimate in
+// tenge, 0 when it gave none.
+type OfficialResponse struct {
+        ID            int        `json:"id"`
+        ProjectID     int        `json:"project_id"`
+        AuthorID      *int       `json:"-"`
+        AuthorName    string     `json:"author_name"`
+        Feasibility   string     `json:"feasibility"`
+        EstimatedCost int        `json:"estimated_cost,omitempty"`
+        Department    string     `json:"department"`
+        Body          string     `json:"body"`
+        CreatedAt     time.Time  `json:"created_at"`
+        UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// FeasibilityLabel is the human-readable assessment.
+func (r *OfficialResponse) FeasibilityLabel() string {
+        return FeasibilityLabel(r.Feasibility)
+}
+
+// FeasibilityLabel names a feasibility assessment.
+func FeasibilityLabel(feasibility string) string {
+        switch feasibility {
+        case FeasibilityFeasible:
+                return "Реализуем"
+        case FeasibilityConditional:
+                return "Реализуем с изменениями"
+        case FeasibilityInfeasible:
+                return "Не реализуем"
+        default:
+                return "Требует изучения"
+        }
+}
+
+// FeasibilityColor is the Tailwind classes of the assessment's badge.
+func (r *OfficialResponse) FeasibilityColor() string {
+        switch r.Feasibility {
+        case FeasibilityFeasible:
+                return "bg-green-200 text-green-800"
+        case FeasibilityConditional:
+                return "bg-yellow-200 text-yellow-800"
+        case FeasibilityInfeasible:
+                return "bg-red-200 text-red-800"
+        default:
+                return "bg-gray-200 text-gray-800"
+        }
+}
+
+// Implementation tracks how a selected project is being built: the
+// contract, the schedule and what has been spent so far.
+type Implementation struct {
+        ProjectID        int        `json:"project_id"`
+        ImplementerID    *int       `json:"-"`
+        ImplementerEmail string     `json:"-"`
+        Contractor       string     `json:"contractor"`
+        ContractNumber   string     `json:"contract_number"`
+        ContractAmount   int        `json:"contract_amount"`
+        ApprovedBudget   int        `json:"approved_budget"`
+        PlannedStart     *time.Time `json:"planned_start,omitempty"`
+        PlannedEnd       *time.Time `json:"planned_end,omitempty"`
+        ActualStart      *time.Time `json:"actual_start,omitempty"`
+        ActualEnd        *time.Time `json:"actual_end,omitempty"`
+        PercentComplete  int        `json:"percent_complete"`
+        DelayReason      string     `json:"delay_reason,omitempty"`
+        Spent            int        `json:"spent"`
+        UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// ContractDelta is the contract amount minus the approved budget: positive
+// when the contract costs more than citizens voted for.
+func (i *Implementation) ContractDelta() int {
+        return i.ContractAmount - i.ApprovedBudget
+}
+
+// SpentPercent is the share of the contract amount (or of the approved
+// budget while there is no contract) spent so far.
+func (i *Implementation) SpentPercent() int {
+        base := i.ContractAmount
+        if base == 0 {
+                base = i.ApprovedBudget
+        }
+        if base <= 0 {
+                return 0
+        }
+        return i.Spent * 100 / base
+}
+
+// Late reports whether the work is unfinished past its planned end, or
+// finished after it.
+func (i *Implementation) Late() bool {
+        if i.PlannedEnd == nil {
+                return false
+        }
+        if i.ActualEnd != nil {
+                return i.ActualEnd.After(*i.PlannedEnd)
+        }
+        return time.Now().After(i.PlannedEnd.AddDate(0, 0, 1))
+}
+
+type Milestone struct {
+        ID          int        `json:"id"`
+        ProjectID   int        `json:"project_id"`
+        Title       string     `json:"title"`
+        PlannedDate *time.Time `json:"planned_date,omitempty"`
+        ActualDate  *time.Time `json:"actual_date,omitempty"`
+        DelayReason string     `json:"delay_reason,omitempty"`
+        CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Late reports whether the milestone was reached after its planned date or
+// is still open past it.
+func (m *Milestone) Late() bool {
+        if m.PlannedDate == nil {
+                return false
+        }
+        if m.ActualDate != nil {
+                return m.ActualDate.After(*m.PlannedDate)
+        }
+        return time.Now().After(m.PlannedDate.AddDate(0, 0, 1))
+}
+
+type SpendEntry struct {
+        ID          int       `json:"id"`
+        ProjectID   int       `json:"project_id"`
+        Amount      int       `json:"amount"`
+        Description string    `json:"description"`
+        SpentOn     time.Time `json:"spent_on"`
+        RecordedBy  int       `json:"-"`
+        CreatedAt   time.Time `json:"created_at"`
+}
+
+// ImageSize is one width a photo is stored at; photos narrower than Width
+// are not enlarged.
+type ImageSize struct {
+        Name  string
+        Width int
+}
+
+// ImageSizes are the variants kept for public photos, largest first. The
+// first one is stored under the upload's own name.
+var ImageSizes = []ImageSize{
+        {Name: "full", Width: 1600},
+        {Name: "card", Width: 800},
+        {Name: "thumb", Width: 320},
+}
+
+// ImageVariant records the size a variant was actually encoded at and
+// whether a WebP copy sits next to it.
+type ImageVariant struct {
+        Name   string `json:"name"`
+        Width  int    `json:"width"`
+        Height int    `json:"height"`
+        WebP   bool   `json:"webp,omitempty"`
+}
+
+// Image is an uploaded photo: its public path and the variants stored
+// next to it. Photos saved before variants were recorded have none and are
+// stored in JSON as a bare path, which is also how they are written back.
+type Image struct {
+        Path     string
+        Variants []ImageVariant
+}
+
+type imageJSON struct {
+        Path     string         `json:"path"`
+        Variants []ImageVariant `json:"variants"`
+}
+
+func (i Image) MarshalJSON() ([]byte, error) {
+        if len(i.Variants) == 0 {
+                return json.Marshal(i.Path)
+        }
+        return json.Marshal(imageJSON{i.Path, i.Variants})
+}
+
+func (i *Image) UnmarshalJSON(data []byte) error {
+        var path string
+        if err := json.Unmarshal(data, &path); err == nil {
+                *i = Image{Path: path}
+                return nil
+        }
+        var v imageJSON
+        if err := json.Unmarshal(data, &v); err != nil {
+                return err
+        }
+        *i = Image{Path: v.Path, Variants: v.Variants}
+        return nil
+}
+
+// String returns the path, so templates can print an Image as a URL.
+func (i Image) String() string {
+        return i.Path
+}
+
+func (i Image) Thumb() string {
+        return ImageVariantPath(i.Path, "thumb")
+}
+
+func (i Image) Card() string {
+        return ImageVariantPath(i.Path, "card")
+}
+
+// Srcset lists the variants with the widths they were encoded at, for the
+// srcset attribute of an <img>. Variants of the same width are listed
+// once. It is empty for photos without recorded variants, and the browser
+// then falls back to src.
+func (i Image) Srcset() string {
+        return i.srcset(ImageVariantPath)
+}
+
+// WebPSrcset is Srcset for the WebP copies, for a <source> element. It is
+// empty when the photo has none.
+func (i Image) WebPSrcset() string {
+        for _, v := range i.Variants {
+                if !v.WebP {
+                        return ""
+                }
+        }
+        return i.srcset(ImageWebPPath)
+}
+
+func (i Image) srcset(variantPath func(p, size string) string) string {
+        var parts []string
+        seen := map[int]bool{}
+        for n := len(i.Variants) - 1; n >= 0; n-- {
+                v := i.Variants[n]
+                if v.Width <= 0 || seen[v.Width] {
+                        continue
+                }
+                seen[v.Width] = true
+                parts = append(parts, fmt.Sprintf("%s %dw", variantPath(i.Path, v.Name), v.Width))
+        }
+        return strings.Join(parts, ", ")
+}
+
+// ImageVariantPath returns where the named variant of the image at p is
+// stored: the first size under p itself, the others as name_size.ext.
+func ImageVariantPath(p, size string) string {
+        if len(ImageSizes) == 0 || size == ImageSizes[0].Name {
+                return p
+        }
+        ext := path.Ext(p)
+        return strings.TrimSuffix(p, ext) + "_" + size + ext
+}
+
+// ImageWebPPath returns where the WebP copy of the named variant is
+// stored: the variant's path with a .webp extension.
+func ImageWebPPath(p, size string) string {
+        v := ImageVariantPath(p, size)
+        return strings.TrimSuffix(v, path.Ext(v)) + ".webp"
+}
+
+// IsImageVariant reports whether p names a smaller variant or a WebP copy
+// rather than an upload.
+func IsImageVariant(p string) bool {
+        if strings.EqualFold(path.Ext(p), ".webp") {
+                return true
+        }
+        base := strings.TrimSuffix(p, path.Ext(p))
+        for _, size := range ImageSizes[1:] {
+                if strings.HasSuffix(base, "_"+size.Name) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Progress photo stages: a set of photos shows the site before work
+// starts, while it runs or after it is finished.
+const (
+        StageBefore = "before"
+        StageDuring = "during"
+        StageAfter  = "after"
+)
+
+type ProgressPhoto struct {
+        ID             int       `json:"id"`
+        ProjectID      int       `json:"project_id"`
+        MilestoneID    *int      `json:"milestone_id,omitempty"`
+        MilestoneTitle string    `json:"milestone_title,omitempty"`
+        Stage          string    `json:"stage"`
+        Path           Image     `json:"path"`
+        Caption        string    `json:"caption,omitempty"`
+        TakenOn        time.Time `json:"taken_on"`
+        UploadedBy     int       `json:"-"`
+        CreatedAt      time.Time `json:"created_at"`
 }
 
 type Achievement struct {
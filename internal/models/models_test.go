@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImageJSON(t *testing.T) {
+	var images []Image
+	data := `["/uploads/1/old.jpg", {"path": "/uploads/1/new.jpg", "variants": [{"name": "full", "width": 1200, "height": 800, "webp": true}]}]`
+	if err := json.Unmarshal([]byte(data), &images); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Image{
+		{Path: "/uploads/1/old.jpg"},
+		{Path: "/uploads/1/new.jpg", Variants: []ImageVariant{{Name: "full", Width: 1200, Height: 800, WebP: true}}},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Fatalf("got %+v, want %+v", images, want)
+	}
+
+	// Photos without variants are written back as bare paths, so the
+	// compare-and-swap in UpdateProjectImages still matches old rows.
+	out, err := json.Marshal(images[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `["/uploads/1/old.jpg"]` {
+		t.Errorf("legacy image marshalled as %s", out)
+	}
+}
+
+func TestImageSrcset(t *testing.T) {
+	img := Image{Path: "/uploads/1/photo.jpg", Variants: []ImageVariant{
+		{Name: "full", Width: 1000, Height: 500, WebP: true},
+		{Name: "card", Width: 800, Height: 400, WebP: true},
+		{Name: "thumb", Width: 320, Height: 160, WebP: true},
+	}}
+
+	if got, want := img.Srcset(), "/uploads/1/photo_thumb.jpg 320w, /uploads/1/photo_card.jpg 800w, /uploads/1/photo.jpg 1000w"; got != want {
+		t.Errorf("Srcset() = %q, want %q", got, want)
+	}
+	if got, want := img.WebPSrcset(), "/uploads/1/photo_thumb.webp 320w, /uploads/1/photo_card.webp 800w, /uploads/1/photo.webp 1000w"; got != want {
+		t.Errorf("WebPSrcset() = %q, want %q", got, want)
+	}
+
+	legacy := Image{Path: "/uploads/1/photo.jpg"}
+	if legacy.Srcset() != "" || legacy.WebPSrcset() != "" {
+		t.Errorf("legacy image srcset = %q / %q, want empty", legacy.Srcset(), legacy.WebPSrcset())
+	}
+	if legacy.Card() != "/uploads/1/photo_card.jpg" || legacy.String() != "/uploads/1/photo.jpg" {
+		t.Errorf("Card() = %q, String() = %q", legacy.Card(), legacy.String())
+	}
+}
+
+func TestIsImageVariant(t *testing.T) {
+	for p, want := range map[string]bool{
+		"uploads/1/photo_ab.jpg":       false,
+		"uploads/1/photo_ab_card.jpg":  true,
+		"uploads/1/photo_ab_thumb.png": true,
+		"uploads/1/photo_ab.webp":      true,
+		"uploads/1/photo_ab_card.webp": true,
+	} {
+		if got := IsImageVariant(p); got != want {
+			t.Errorf("IsImageVariant(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestCycleVotingWindow(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name            string
+		cycle           BudgetCycle
+		started, closed bool
+	}{
+		{"no dates", BudgetCycle{}, true, false},
+		{"not yet open", BudgetCycle{VoteStart: &after, VoteEnd: &after}, false, false},
+		{"not yet open but voted", BudgetCycle{VoteStart: &after, HasVotes: true}, true, false},
+		{"open", BudgetCycle{VoteStart: &before, VoteEnd: &after}, true, false},
+		{"ended", BudgetCycle{VoteStart: &before, VoteEnd: &before}, true, true},
+		{"ends now", BudgetCycle{VoteStart: &before, VoteEnd: &now}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cycle.VotingStarted(now); got != tt.started {
+				t.Errorf("VotingStarted = %v, want %v", got, tt.started)
+			}
+			if got := tt.cycle.VotingClosed(now); got != tt.closed {
+				t.Errorf("VotingClosed = %v, want %v", got, tt.closed)
+			}
+		})
+	}
+}
+
+func TestProjectVotingOpen(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		project Project
+		want    bool
+	}{
+		{"no window", Project{Status: "voting"}, true},
+		{"inside window", Project{Status: "voting", VoteStart: &before, VoteEnd: &after}, true},
+		{"not yet open", Project{Status: "voting", VoteStart: &after}, false},
+		{"ended", Project{Status: "voting", VoteEnd: &before}, false},
+		{"not in voting", Project{Status: "selected", VoteStart: &before, VoteEnd: &after}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.project.VotingOpen(now); got != tt.want {
+				t.Errorf("VotingOpen = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
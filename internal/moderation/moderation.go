@@ -0,0 +1,80 @@
+// Package moderation holds the rules of the moderation queue: how long a
+// moderator's claim on a project lasts, how soon a project must be decided
+// and whether a rejection needs a second moderator.
+package moderation
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	// ClaimTTL is how long a claimed project stays reserved for its
+	// moderator; an expired claim returns the project to the queue.
+	ClaimTTL time.Duration
+	// SLA is the time a project may wait in the queue for a decision,
+	// counted from when it entered the queue (or was resubmitted).
+	SLA time.Duration
+	// TwoPersonReject makes a rejection a proposal until a second
+	// moderator confirms it.
+	TwoPersonReject bool
+}
+
+var Defaults = Config{
+	ClaimTTL:        30 * time.Minute,
+	SLA:             72 * time.Hour,
+	TwoPersonReject: true,
+}
+
+// ConfigFromEnv reads MODERATION_CLAIM_TTL and MODERATION_SLA (Go
+// durations such as "45m" or "48h") and MODERATION_TWO_PERSON_REJECT
+// ("true" or "false"), falling back to Defaults.
+func ConfigFromEnv() (Config, error) {
+	c := Defaults
+	if v := os.Getenv("MODERATION_CLAIM_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return c, fmt.Errorf("MODERATION_CLAIM_TTL %q: want a duration of at least 1m", v)
+		}
+		c.ClaimTTL = d
+	}
+	if v := os.Getenv("MODERATION_SLA"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Hour {
+			return c, fmt.Errorf("MODERATION_SLA %q: want a duration of at least 1h", v)
+		}
+		c.SLA = d
+	}
+	if v := os.Getenv("MODERATION_TWO_PERSON_REJECT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("MODERATION_TWO_PERSON_REJECT %q: want true or false", v)
+		}
+		c.TwoPersonReject = b
+	}
+	return c, nil
+}
+
+// Deadline is when a project that entered the queue at queuedAt must be
+// decided.
+func (c Config) Deadline(queuedAt time.Time) time.Time {
+	return queuedAt.Add(c.SLA)
+}
+
+// FormatDuration renders a wait or a handling time the way the queue
+// shows it: days and hours, or hours and minutes below a day.
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%d д %d ч", int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%d ч %d мин", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	default:
+		return fmt.Sprintf("%d мин", int(d/time.Minute))
+	}
+}
@@ -0,0 +1,220 @@
+// Package results builds the public summary of a finished budget cycle:
+// winners, budget use, support by district and category and participation
+// over time. The same Summary feeds the results page and its CSV and JSON
+// downloads.
+package results
+
+import (
+	"io"
+	"petropavlovsk-budget/internal/csvexport"
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/tally"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Project struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	District string `json:"district"`
+	Budget   int    `json:"budget"`
+	Score    int    `json:"score"`
+	Winner   bool   `json:"winner"`
+}
+
+// Group aggregates the projects of one district or category. Voters counts
+// distinct citizens who supported at least one of its projects.
+type Group struct {
+	Name     string `json:"name"`
+	Projects int    `json:"projects"`
+	Winners  int    `json:"winners"`
+	Score    int    `json:"score"`
+	Voters   int    `json:"voters"`
+	Funded   int    `json:"funded"`
+}
+
+type Day struct {
+	Date  time.Time `json:"date"`
+	Count int       `json:"count"`
+}
+
+type Summary struct {
+	CycleID    int            `json:"cycle_id"`
+	Title      string         `json:"title"`
+	BallotType string         `json:"ballot_type"`
+	Method     string         `json:"method"`
+	VoteStart  *time.Time     `json:"vote_start,omitempty"`
+	VoteEnd    *time.Time     `json:"vote_end,omitempty"`
+	Budget     int            `json:"budget"`
+	BudgetUsed int            `json:"budget_used"`
+	Voters     int            `json:"voters"`
+	Ballots    int            `json:"ballots"`
+	Channels   map[string]int `json:"channels"`
+	Winners    []Project      `json:"winners"`
+	Projects   []Project      `json:"projects"`
+	Districts  []Group        `json:"districts"`
+	Categories []Group        `json:"categories"`
+	Days       []Day          `json:"participation"`
+}
+
+// Stats is what the database knows beyond the tally: distinct voters in
+// total and per project, and daily counts of votes or ballots cast.
+type Stats struct {
+	Voters        int
+	ProjectVoters map[int][]int
+	Channels      map[string]int
+	Daily         []models.DayCount
+}
+
+// New assembles the summary. projects should already be limited to the
+// ones on the ballot.
+func New(cycle models.BudgetCycle, projects []models.Project, result tally.Result, stats Stats) Summary {
+	s := Summary{
+		CycleID:    cycle.ID,
+		Title:      cycle.Title,
+		BallotType: cycle.BallotType,
+		Method:     result.Method,
+		VoteStart:  cycle.VoteStart,
+		VoteEnd:    cycle.VoteEnd,
+		Budget:     result.Budget,
+		BudgetUsed: result.BudgetUsed,
+		Voters:     stats.Voters,
+		Ballots:    result.Ballots,
+		Channels:   stats.Channels,
+	}
+
+	districts := map[string]*Group{}
+	categories := map[string]*Group{}
+	districtVoters := map[string]map[int]bool{}
+	categoryVoters := map[string]map[int]bool{}
+	add := func(groups map[string]*Group, voters map[string]map[int]bool, name string, p Project) {
+		g, ok := groups[name]
+		if !ok {
+			g = &Group{Name: name}
+			groups[name] = g
+			voters[name] = map[int]bool{}
+		}
+		g.Projects++
+		g.Score += p.Score
+		if p.Winner {
+			g.Winners++
+			g.Funded += p.Budget
+		}
+		for _, userID := range stats.ProjectVoters[p.ID] {
+			voters[name][userID] = true
+		}
+	}
+
+	for _, p := range projects {
+		rp := Project{
+			ID:       p.ID,
+			Title:    p.Title,
+			Category: p.Category,
+			District: p.District,
+			Budget:   p.Budget,
+			Score:    result.Scores[p.ID],
+			Winner:   result.IsWinner(p.ID),
+		}
+		s.Projects = append(s.Projects, rp)
+		add(districts, districtVoters, rp.District, rp)
+		add(categories, categoryVoters, rp.Category, rp)
+	}
+
+	sort.SliceStable(s.Projects, func(i, j int) bool {
+		return s.Projects[i].Score > s.Projects[j].Score
+	})
+	for _, id := range result.Winners {
+		for _, p := range s.Projects {
+			if p.ID == id {
+				s.Winners = append(s.Winners, p)
+			}
+		}
+	}
+
+	s.Districts = groups(districts, districtVoters)
+	s.Categories = groups(categories, categoryVoters)
+	s.Days = fillDays(stats.Daily)
+
+	return s
+}
+
+func groups(m map[string]*Group, voters map[string]map[int]bool) []Group {
+	var out []Group
+	for name, g := range m {
+		g.Voters = len(voters[name])
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Voters != out[j].Voters {
+			return out[i].Voters > out[j].Voters
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// fillDays adds zero days between the first and last active day so the
+// chart's time axis is even.
+func fillDays(daily []models.DayCount) []Day {
+	if len(daily) == 0 {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, d := range daily {
+		counts[d.Date.Format("2006-01-02")] += d.Count
+	}
+
+	var days []Day
+	first := daily[0].Date
+	last := daily[len(daily)-1].Date
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, Day{Date: d, Count: counts[d.Format("2006-01-02")]})
+	}
+	return days
+}
+
+// ScoreUnit names what a project's score counts.
+func (s Summary) ScoreUnit() string {
+	if s.BallotType == models.BallotRanked {
+		return "баллов"
+	}
+	return "голосов"
+}
+
+// Published reports whether the cycle's results may be shown to the
+// public: voting must have ended.
+func Published(cycle models.BudgetCycle, now time.Time) bool {
+	return cycle.VoteEnd != nil && now.After(*cycle.VoteEnd)
+}
+
+// TotalScore sums the support of all projects.
+func (s Summary) TotalScore() int {
+	total := 0
+	for _, p := range s.Projects {
+		total += p.Score
+	}
+	return total
+}
+
+// WriteCSV writes one row per project, winners marked. Titles come from
+// citizens, so cells are escaped against formula injection.
+func (s Summary) WriteCSV(w io.Writer) error {
+	out := csvexport.NewWriter(w)
+	out.Write([]string{"cycle_id", "project_id", "title", "category", "district", "budget", "score", "winner"})
+	for _, p := range s.Projects {
+		out.Write([]string{
+			strconv.Itoa(s.CycleID),
+			strconv.Itoa(p.ID),
+			p.Title,
+			p.Category,
+			p.District,
+			strconv.Itoa(p.Budget),
+			strconv.Itoa(p.Score),
+			strconv.FormatBool(p.Winner),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+type Object struct {
+	Key      string
+	Modified time.Time
+}
+
+// Storage keeps files by key. Keys are slash-separated and equal to the
+// paths the application has always stored: "uploads/12/photo_1.jpg" for
+// public photos and "private/verification/5/document.jpg" for identity
+// documents, so switching backends needs no database change.
+//
+// Put must never leave a partly written file visible under key.
+type Storage interface {
+	Put(key string, data []byte) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// List returns every object under prefix.
+	List(prefix string) ([]Object, error)
+}
+
+// Signer is implemented by backends that can hand out short-lived direct
+// links, so private files need not pass through the server.
+type Signer interface {
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// Getter is implemented by backends that answer range and conditional
+// requests themselves. Get passes header on and returns 206, 304, 412 and
+// 416 responses as they are.
+type Getter interface {
+	Get(key string, header http.Header) (*http.Response, error)
+}
+
+// forwardedHeaders are the client headers a Getter receives.
+var forwardedHeaders = []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"}
+
+// relayedHeaders are copied from a Getter's response to the client.
+var relayedHeaders = []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// seekableFile is what the local backend's Open returns; http.ServeContent
+// answers range and conditional requests for it.
+type seekableFile interface {
+	io.ReadSeeker
+	Stat() (fs.FileInfo, error)
+}
+
+// New picks the backend named by STORAGE_BACKEND; local is the default.
+func New() (Storage, error) {
+	return Open(os.Getenv("STORAGE_BACKEND"))
+}
+
+// Open builds the named backend from its environment variables.
+//
+//	local: STORAGE_DIR (default ".")
+//	s3:    S3_ENDPOINT, S3_REGION (default us-east-1), S3_BUCKET,
+//	       S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_PATH_STYLE (default true)
+func Open(backend string) (Storage, error) {
+	switch backend {
+	case "", "local":
+		root := os.Getenv("STORAGE_DIR")
+		if root == "" {
+			root = "."
+		}
+		return &Local{Root: root}, nil
+	case "s3":
+		s := &S3{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") != "false",
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		if s.Endpoint == "" || s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+			return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 backend")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// Key turns a stored path ("/uploads/1/a.jpg" or "private/...") into a
+// storage key, refusing anything that would leave the two top-level
+// directories.
+func Key(p string) (string, error) {
+	key := path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))[1:]
+	if !strings.HasPrefix(key, "uploads/") && !strings.HasPrefix(key, PrivateDir+"/") {
+		return "", fmt.Errorf("путь %s вне каталогов хранилища", p)
+	}
+	return key, nil
+}
+
+// Serve streams the file at key to the client. Range requests and the
+// If-* headers are honoured, so browsers can revalidate cached photos and
+// resume downloads: a Getter backend receives the client's headers and
+// its answer is passed on; local files go through http.ServeContent with
+// an ETag made from their size and modification time.
+func Serve(w http.ResponseWriter, r *http.Request, s Storage, key string, cacheControl string) {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Del("Pragma")
+	w.Header().Del("Expires")
+
+	if g, ok := s.(Getter); ok {
+		header := http.Header{}
+		for _, name := range forwardedHeaders {
+			for _, v := range r.Header.Values(name) {
+				header.Add(name, v)
+			}
+		}
+		resp, err := g.Get(key, header)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		defer resp.Body.Close()
+		for _, name := range relayedHeaders {
+			for _, v := range resp.Header.Values(name) {
+				w.Header().Add(name, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		// The bodies of 412 and 416 are S3 error documents, not the file.
+		if r.Method != http.MethodHead && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) {
+			io.Copy(w, resp.Body)
+		}
+		return
+	}
+
+	file, err := s.Open(key)
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+	defer file.Close()
+
+	if f, ok := file.(seekableFile); ok {
+		if info, err := f.Stat(); err == nil {
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+			http.ServeContent(w, r, key, info.ModTime(), f)
+			return
+		}
+	}
+	io.Copy(w, file)
+}
+
+func storageError(w http.ResponseWriter, err error) {
+	w.Header().Set("Cache-Control", "no-store")
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Файл не найден", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Ошибка хранилища", http.StatusBadGateway)
+}
+
+// PublicHandler serves /uploads/* from the backend, so every server
+// instance sees the same files whichever one received the upload.
+func PublicHandler(s Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := Key(r.URL.Path)
+		if err != nil || !strings.HasPrefix(key, "uploads/") || strings.HasPrefix(path.Base(key), TempPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		Serve(w, r, s, key, "public, max-age=3600")
+	})
+}
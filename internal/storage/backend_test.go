@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(s Storage, key string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/"+key, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	Serve(w, r, s, key, "public, max-age=3600")
+	return w
+}
+
+func TestServeLocalRangeAndConditional(t *testing.T) {
+	s := &Local{Root: t.TempDir()}
+	if err := s.Put("uploads/1/photo.jpg", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, "uploads/1/photo.jpg", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" || etag == "" {
+		t.Fatalf("plain GET: %d %q etag=%q", w.Code, w.Body.String(), etag)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Header().Get("Last-Modified") == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("missing Last-Modified or Accept-Ranges: %v", w.Header())
+	}
+
+	w = serve(s, "uploads/1/photo.jpg", http.Header{"Range": {"bytes=2-4"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" || w.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("range: %d %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+
+	w = serve(s, "uploads/1/photo.jpg", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(s, "uploads/1/missing.jpg", nil)
+	if w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("missing file: %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestServeS3ForwardsHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		if r.URL.Path != "/bucket/uploads/1/photo.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", "Wed, 01 May 2026 10:00:00 GMT")
+		w.Header().Set("X-Amz-Request-Id", "internal")
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Header.Get("Range") == "bytes=0-3" {
+			w.Header().Set("Content-Range", "bytes 0-3/10")
+			w.Header().Set("Content-Length", "4")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, "0123")
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			io.WriteString(w, "<Error><Code>InvalidRange</Code></Error>")
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer upstream.Close()
+
+	s := &S3{Endpoint: upstream.URL, Region: "us-east-1", Bucket: "bucket", AccessKey: "a", SecretKey: "b", PathStyle: true}
+
+	w := serve(s, "uploads/1/photo.jpg", http.Header{"Range": {"bytes=0-3"}, "Cookie": {"session=secret"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+		t.Errorf("range: %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Range") != "bytes 0-3/10" || w.Header().Get("ETag") != `"abc"` {
+		t.Errorf("headers not relayed: %v", w.Header())
+	}
+	if w.Header().Get("X-Amz-Request-Id") != "" {
+		t.Error("S3 internal header relayed")
+	}
+	if got.Get("Cookie") != "" {
+		t.Error("client cookie forwarded to S3")
+	}
+	if !strings.Contains(got.Get("Authorization"), "SignedHeaders=host;range;") {
+		t.Errorf("Range not signed: %s", got.Get("Authorization"))
+	}
+
+	w = serve(s, "uploads/1/photo.jpg", http.Header{"If-None-Match": {`"abc"`}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(s, "uploads/1/photo.jpg", http.Header{"Range": {"bytes=50-60"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Body.Len() != 0 {
+		t.Errorf("bad range: %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(s, "uploads/1/missing.jpg", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing object: %d", w.Code)
+	}
+}
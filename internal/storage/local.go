@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps files in directories under Root, which is how the platform
+// has always stored uploads. It only works for a single server instance
+// or a shared filesystem.
+type Local struct {
+	Root string
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file in the target directory and renames it
+// into place, so readers see either the old file or the complete new one.
+// Private files are readable by the owner only.
+func (l *Local) Put(key string, data []byte) error {
+	dirMode, fileMode := os.FileMode(0755), os.FileMode(0644)
+	if strings.HasPrefix(key, PrivateDir+"/") {
+		dirMode, fileMode = 0700, 0600
+	}
+
+	p := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), dirMode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), TempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Open(key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete also removes directories the file leaves empty, up to the
+// top-level uploads or private directory.
+func (l *Local) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for dir := path.Dir(key); strings.Contains(dir, "/"); dir = path.Dir(dir) {
+		if os.Remove(l.path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.path(prefix), func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(rel), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestS3Integration runs the S3 backend against a real service. It is
+// skipped unless S3_TEST_ENDPOINT is set, e.g. for a local MinIO:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	mc mb local/petro-test
+//	S3_TEST_ENDPOINT=http://localhost:9000 S3_TEST_BUCKET=petro-test \
+//	S3_TEST_ACCESS_KEY_ID=minioadmin S3_TEST_SECRET_ACCESS_KEY=minioadmin \
+//	go test ./internal/storage -run S3Integration
+//
+// The bucket must exist; the test only touches keys under a fresh prefix.
+func TestS3Integration(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+	s := &S3{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_TEST_REGION"),
+		Bucket:    os.Getenv("S3_TEST_BUCKET"),
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_ACCESS_KEY"),
+		PathStyle: os.Getenv("S3_TEST_PATH_STYLE") != "false",
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+
+	prefix := fmt.Sprintf("test/%d/", time.Now().UnixNano())
+	key := prefix + "фото 1.jpg"
+	data := []byte("0123456789")
+	t.Cleanup(func() { s.Delete(key) })
+
+	if err := s.Put(key, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("Open read %q, %v", got, err)
+	}
+
+	resp, err := s.Get(key, http.Header{"Range": {"bytes=2-4"}})
+	if err != nil {
+		t.Fatalf("Get range: %v", err)
+	}
+	got, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusPartialContent || string(got) != "234" || etag == "" {
+		t.Errorf("Get range: %d %q etag=%q", resp.StatusCode, got, etag)
+	}
+
+	resp, err = s.Get(key, http.Header{"If-None-Match": {etag}})
+	if err != nil {
+		t.Fatalf("Get If-None-Match: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Get If-None-Match: %d, want 304", resp.StatusCode)
+	}
+
+	objects, err := s.List(prefix)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != key || objects[0].Modified.IsZero() {
+		t.Errorf("List = %+v", objects)
+	}
+
+	signed, err := s.SignedURL(key, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatalf("presigned GET: %v", err)
+	}
+	got, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != string(data) {
+		t.Errorf("presigned GET: %d %q", resp.StatusCode, got)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Open(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete: %v, want ErrNotFound", err)
+	}
+}
@@ -1,17 +1,27 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
-	"os"
+	"path"
 	"path/filepath"
+	"petropavlovsk-budget/internal/imaging"
+	"petropavlovsk-budget/internal/models"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	MaxFileSize   = 5 * 1024 * 1024
 	MaxFilesCount = 3
+
+	// MaxProgressPhotos limits one upload of progress photos.
+	MaxProgressPhotos = 10
 )
 
 var allowedExtensions = map[string]bool{
@@ -20,49 +30,275 @@ var allowedExtensions = map[string]bool{
 	".png":  true,
 }
 
-func SaveProjectImages(projectID int, files []*multipart.FileHeader) ([]string, error) {
-	if len(files) > MaxFilesCount {
-		return nil, fmt.Errorf("максимум %d фотографий разрешено", MaxFilesCount)
+// documentSizes keeps identity documents at one size, large enough to
+// read; they are re-encoded only to check them and drop their metadata.
+var documentSizes = []models.ImageSize{{Name: "full", Width: 2400}}
+
+func checkFile(fileHeader *multipart.FileHeader) error {
+	if fileHeader.Size > MaxFileSize {
+		return fmt.Errorf("файл %s превышает максимальный размер 5MB", fileHeader.Filename)
+	}
+	if !allowedExtensions[strings.ToLower(filepath.Ext(fileHeader.Filename))] {
+		return fmt.Errorf("файл %s имеет недопустимый формат. Разрешены только JPG и PNG", fileHeader.Filename)
 	}
+	return nil
+}
 
-	uploadDir := fmt.Sprintf("uploads/%d", projectID)
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+// processFile runs an upload through the image pipeline, which checks its
+// content regardless of the extension.
+func processFile(fileHeader *multipart.FileHeader, sizes []models.ImageSize, withWebP bool) (*imaging.Result, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	res, err := imaging.Process(io.LimitReader(file, MaxFileSize), sizes, withWebP)
+	if err != nil {
+		return nil, fmt.Errorf("файл %s: %w", fileHeader.Filename, err)
+	}
+	return res, nil
+}
+
+// prepareImages checks and processes every upload before anything is
+// stored, so a bad file rejects the whole request without side effects.
+func prepareImages(files []*multipart.FileHeader, max int) ([]*imaging.Result, error) {
+	if len(files) > max {
+		return nil, fmt.Errorf("максимум %d фотографий за один раз", max)
+	}
+	for _, fileHeader := range files {
+		if err := checkFile(fileHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	var images []*imaging.Result
+	for _, fileHeader := range files {
+		res, err := processFile(fileHeader, models.ImageSizes, true)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, res)
+	}
+	return images, nil
+}
 
-	var savedPaths []string
+func randomName() (string, error) {
+	name := make([]byte, 8)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(name), nil
+}
 
-	for i, fileHeader := range files {
-		if fileHeader.Size > MaxFileSize {
-			return nil, fmt.Errorf("файл %s превышает максимальный размер 5MB", fileHeader.Filename)
+// storeImage writes every variant of res, with its WebP copy, next to
+// dir/base and returns the image stored under the public path of the
+// largest one.
+func storeImage(s Storage, dir, base string, res *imaging.Result) (models.Image, error) {
+	key := path.Join(dir, base+res.Ext)
+	written, err := putVariants(s, key, res)
+	if err != nil {
+		for _, w := range written {
+			s.Delete(w)
 		}
+		return models.Image{}, err
+	}
+	return res.Image("/" + key), nil
+}
 
-		ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
-		if !allowedExtensions[ext] {
-			return nil, fmt.Errorf("файл %s имеет недопустимый формат. Разрешены только JPG и PNG", fileHeader.Filename)
+// putVariants writes the variants of res and their WebP copies for the
+// image at key, and returns the keys written before any error.
+func putVariants(s Storage, key string, res *imaging.Result) ([]string, error) {
+	var written []string
+	for _, v := range res.Variants {
+		files := map[string][]byte{models.ImageVariantPath(key, v.Name): v.Data}
+		if v.WebP {
+			files[models.ImageWebPPath(key, v.Name)] = v.WebPData
+		}
+		for k, data := range files {
+			if err := s.Put(k, data); err != nil {
+				return written, err
+			}
+			written = append(written, k)
 		}
+	}
+	return written, nil
+}
 
-		file, err := fileHeader.Open()
+// PrepareProjectImages validates a project's photos. It runs before the
+// project is created so that a rejected file leaves nothing behind.
+func PrepareProjectImages(files []*multipart.FileHeader) ([]*imaging.Result, error) {
+	if len(files) > MaxFilesCount {
+		return nil, fmt.Errorf("максимум %d фотографий разрешено", MaxFilesCount)
+	}
+	return prepareImages(files, MaxFilesCount)
+}
+
+// StoreProjectImages writes prepared photos under uploads/{projectID}.
+// Names are random, so a photo added after another was removed never
+// reuses a name a browser may still have cached. On failure whatever was
+// written is removed again.
+func StoreProjectImages(s Storage, projectID int, images []*imaging.Result) ([]models.Image, error) {
+	uploadDir := fmt.Sprintf("uploads/%d", projectID)
+
+	var saved []models.Image
+
+	for _, res := range images {
+		base, err := randomName()
+		if err != nil {
+			return nil, err
+		}
+		img, err := storeImage(s, uploadDir, "photo_"+base, res)
 		if err != nil {
+			for _, done := range saved {
+				RemoveUpload(s, done.Path)
+			}
 			return nil, err
 		}
-		defer file.Close()
 
-		filename := fmt.Sprintf("photo_%d%s", i+1, ext)
-		filepath := filepath.Join(uploadDir, filename)
+		saved = append(saved, img)
+	}
+
+	return saved, nil
+}
 
-		dst, err := os.Create(filepath)
+// SaveProgressPhotos stores a set of implementation progress photos under
+// uploads/{projectID}/progress. Names are random so later sets never
+// overwrite earlier ones. Every file is checked before any is written.
+func SaveProgressPhotos(s Storage, projectID int, files []*multipart.FileHeader) ([]models.Image, error) {
+	images, err := prepareImages(files, MaxProgressPhotos)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadDir := fmt.Sprintf("uploads/%d/progress", projectID)
+
+	var saved []models.Image
+	for _, res := range images {
+		name, err := randomName()
 		if err != nil {
 			return nil, err
 		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
+		img, err := storeImage(s, uploadDir, name, res)
+		if err != nil {
+			for _, done := range saved {
+				RemoveUpload(s, done.Path)
+			}
 			return nil, err
 		}
+		saved = append(saved, img)
+	}
+
+	return saved, nil
+}
+
+// RemoveUpload deletes an image saved under uploads, with all its
+// variants and their WebP copies, given the public path returned when it
+// was saved. Anything outside uploads is refused.
+func RemoveUpload(s Storage, publicPath string) error {
+	key, err := Key(publicPath)
+	if err != nil || !strings.HasPrefix(key, "uploads/") {
+		return fmt.Errorf("путь %s вне каталога uploads", publicPath)
+	}
+	var errs []error
+	for _, size := range models.ImageSizes {
+		for _, variant := range []string{models.ImageVariantPath(key, size.Name), models.ImageWebPPath(key, size.Name)} {
+			if err := s.Delete(variant); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RebuildImage runs a stored image through the pipeline again, replacing
+// it with a clean copy and writing any missing variants and WebP copies.
+// The format must match the extension so that stored paths stay valid. It
+// returns the image with the variants' real sizes, for the database.
+func RebuildImage(s Storage, key string) (models.Image, error) {
+	file, err := s.Open(key)
+	if err != nil {
+		return models.Image{}, err
+	}
+	res, err := imaging.Process(file, models.ImageSizes, true)
+	file.Close()
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	ext := strings.ToLower(path.Ext(key))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	if ext != res.Ext {
+		return models.Image{}, fmt.Errorf("содержимое (%s) не совпадает с расширением", res.Format)
+	}
+
+	if _, err := putVariants(s, key, res); err != nil {
+		return models.Image{}, err
+	}
+	return res.Image("/" + key), nil
+}
+
+// TempPrefix starts the names of files still being written by the local
+// backend.
+const TempPrefix = ".tmp-"
+
+// CollectOrphans deletes uploads that no project owns any more: every
+// uploads/{id}/ directory whose id is not in projects, and temporary files
+// left by interrupted writes. Anything modified within minAge is kept, as
+// it may belong to a project whose transaction has not committed yet.
+// With dryRun nothing is deleted. It returns the affected keys.
+func CollectOrphans(s Storage, projects map[int]bool, minAge time.Duration, dryRun bool) ([]string, error) {
+	objects, err := s.List("uploads/")
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	var removed []string
+	for _, obj := range objects {
+		if obj.Modified.After(cutoff) {
+			continue
+		}
+
+		orphan := strings.HasPrefix(path.Base(obj.Key), TempPrefix)
+		parts := strings.SplitN(strings.TrimPrefix(obj.Key, "uploads/"), "/", 2)
+		if id, err := strconv.Atoi(parts[0]); err == nil && len(parts) == 2 && !projects[id] {
+			orphan = true
+		}
+		if !orphan {
+			continue
+		}
+
+		if !dryRun {
+			if err := s.Delete(obj.Key); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, obj.Key)
+	}
+	return removed, nil
+}
+
+// PrivateDir holds files that must never be served by the public /uploads
+// file server, such as identity documents.
+const PrivateDir = "private"
+
+func SaveVerificationDocument(s Storage, userID int, fileHeader *multipart.FileHeader) (string, error) {
+	if err := checkFile(fileHeader); err != nil {
+		return "", err
+	}
+
+	res, err := processFile(fileHeader, documentSizes, false)
+	if err != nil {
+		return "", err
+	}
 
-		savedPaths = append(savedPaths, "/"+filepath)
+	key := fmt.Sprintf("%s/verification/%d/document%s", PrivateDir, userID, res.Ext)
+	if err := s.Put(key, res.Variants[0].Data); err != nil {
+		return "", err
 	}
 
-	return savedPaths, nil
+	return key, nil
 }
@@ -0,0 +1,132 @@
+package tally
+
+import (
+	"fmt"
+	"math"
+	"petropavlovsk-budget/internal/models"
+	"sort"
+)
+
+// equalShares counts ranked ballots with the Method of Equal Shares. Every
+// voter starts with an equal part of the budget. A rank r on a ballot over n
+// candidates is worth n-r+1 points. In each round the project that its
+// supporters can pay for at the lowest price per point (rho) is funded:
+// each supporter pays min(share, rho × points). Projects left when no one
+// can afford anything more are funded greedily by total points from what is
+// left of the budget.
+func equalShares(result *Result, projects []models.Project, ballots []models.Ballot) {
+	n := len(projects)
+	index := map[int]int{}
+	for i, p := range projects {
+		index[p.ID] = i
+	}
+
+	// utility[v][i] is the points voter v gives project i.
+	utility := make([][]float64, len(ballots))
+	for v, b := range ballots {
+		utility[v] = make([]float64, n)
+		for _, e := range b.Entries {
+			i, ok := index[e.ProjectID]
+			if !ok || e.Value < 1 || e.Value > n {
+				continue
+			}
+			points := n - e.Value + 1
+			utility[v][i] = float64(points)
+			result.Scores[e.ProjectID] += points
+		}
+	}
+
+	if len(ballots) == 0 {
+		greedy(result, projects, result.Budget, "баллов")
+		return
+	}
+
+	share := make([]float64, len(ballots))
+	for v := range share {
+		share[v] = float64(result.Budget) / float64(len(ballots))
+	}
+
+	funded := make([]bool, n)
+	remaining := result.Budget
+	for {
+		best, bestRho := -1, math.Inf(1)
+		for i, p := range projects {
+			if funded[i] || p.Budget > remaining || result.Scores[p.ID] == 0 {
+				continue
+			}
+			rho, ok := affordable(float64(p.Budget), share, utility, i)
+			if !ok {
+				continue
+			}
+			if best == -1 || rho < bestRho-1e-9 || (math.Abs(rho-bestRho) <= 1e-9 && prefer(p, projects[best], result.Scores)) {
+				best, bestRho = i, rho
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		p := projects[best]
+		payers := 0
+		for v := range share {
+			if utility[v][best] == 0 {
+				continue
+			}
+			share[v] -= math.Min(share[v], bestRho*utility[v][best])
+			payers++
+		}
+		funded[best] = true
+		remaining -= p.Budget
+		result.Winners = append(result.Winners, p.ID)
+		result.Steps = append(result.Steps, Step{
+			ProjectID: p.ID,
+			Title:     p.Title,
+			Cost:      p.Budget,
+			Score:     result.Scores[p.ID],
+			Selected:  true,
+			Remaining: remaining,
+			Note:      fmt.Sprintf("равные доли: %.2f ₸ за балл, оплатили %d жителей", bestRho, payers),
+		})
+	}
+
+	var rest []models.Project
+	for i, p := range projects {
+		if !funded[i] {
+			rest = append(rest, p)
+		}
+	}
+	greedy(result, rest, remaining, "баллов (из остатка)")
+}
+
+// affordable returns the smallest rho such that the supporters of project i
+// together pay its cost, each paying min(share, rho × points).
+func affordable(cost float64, share []float64, utility [][]float64, i int) (float64, bool) {
+	type supporter struct{ share, points float64 }
+	var supporters []supporter
+	total, points := 0.0, 0.0
+	for v := range share {
+		if utility[v][i] > 0 {
+			supporters = append(supporters, supporter{share[v], utility[v][i]})
+			total += share[v]
+			points += utility[v][i]
+		}
+	}
+	if total < cost-1e-6 {
+		return 0, false
+	}
+
+	// Supporters who run out first pay their whole share; the rest split
+	// what is left of the cost in proportion to their points.
+	sort.Slice(supporters, func(a, b int) bool {
+		return supporters[a].share/supporters[a].points < supporters[b].share/supporters[b].points
+	})
+	for _, s := range supporters {
+		rho := cost / points
+		if s.share >= rho*s.points {
+			return rho, true
+		}
+		cost -= s.share
+		points -= s.points
+	}
+	return 0, false
+}
@@ -0,0 +1,142 @@
+// Package tally turns the ballots of a budget cycle into a list of winning
+// projects and a step-by-step explanation of how they were chosen.
+package tally
+
+import (
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+	"sort"
+)
+
+// Step records one decision of the counting procedure, in order.
+type Step struct {
+	ProjectID int
+	Title     string
+	Cost      int
+	Score     int
+	Selected  bool
+	Remaining int
+	Note      string
+}
+
+type Result struct {
+	BallotType string
+	Method     string
+	Budget     int
+	BudgetUsed int
+	Ballots    int
+	Scores     map[int]int
+	Winners    []int
+	Steps      []Step
+}
+
+// IsWinner reports whether the project was selected.
+func (r Result) IsWinner(projectID int) bool {
+	for _, id := range r.Winners {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// Count computes the result of a cycle. Only projects still in voting or
+// already selected take part. ballots is ignored for single-vote cycles,
+// where each project's VoteCount is its score.
+func Count(cycle models.BudgetCycle, projects []models.Project, ballots []models.Ballot) Result {
+	candidates := eligible(projects)
+	result := Result{
+		BallotType: cycle.BallotType,
+		Budget:     cycle.Budget,
+		Scores:     map[int]int{},
+		Ballots:    len(ballots),
+	}
+
+	switch cycle.BallotType {
+	case models.BallotKnapsack, models.BallotApproval:
+		result.Method = "Проекты рассматриваются по убыванию числа голосов; при равенстве — сначала более дешёвый. Проект финансируется, если его стоимость помещается в остаток бюджета."
+		for _, b := range ballots {
+			for _, e := range b.Entries {
+				result.Scores[e.ProjectID]++
+			}
+		}
+		greedy(&result, candidates, result.Budget, "голосов")
+
+	case models.BallotQuadratic:
+		result.Method = "Каждый житель распределяет кредиты; n голосов за проект стоят n² кредитов. Проекты рассматриваются по убыванию суммы голосов и финансируются, пока хватает бюджета."
+		for _, b := range ballots {
+			for _, e := range b.Entries {
+				result.Scores[e.ProjectID] += e.Value
+			}
+		}
+		greedy(&result, candidates, result.Budget, "голосов")
+
+	case models.BallotRanked:
+		result.Method = "Метод равных долей: бюджет делится поровну между жителями, сдавшими бюллетень. На каждом шаге финансируется проект, который его сторонники могут оплатить с наименьшей ценой за балл (место 1 из n даёт n баллов, место 2 — n−1 и т. д.). Когда доли исчерпаны, остаток распределяется по убыванию суммы баллов."
+		equalShares(&result, candidates, ballots)
+
+	default:
+		result.Method = "Проекты рассматриваются по убыванию числа голосов; при равенстве — сначала более дешёвый. Проект финансируется, если его стоимость помещается в остаток бюджета."
+		result.Ballots = 0
+		for _, p := range candidates {
+			result.Scores[p.ID] = p.VoteCount
+			result.Ballots += p.VoteCount
+		}
+		greedy(&result, candidates, result.Budget, "голосов")
+	}
+
+	return result
+}
+
+func eligible(projects []models.Project) []models.Project {
+	var out []models.Project
+	for _, p := range projects {
+		if p.Tallied() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// prefer reports whether a ranks before b: higher score first, then the
+// cheaper project, then the one submitted first.
+func prefer(a, b models.Project, scores map[int]int) bool {
+	if scores[a.ID] != scores[b.ID] {
+		return scores[a.ID] > scores[b.ID]
+	}
+	if a.Budget != b.Budget {
+		return a.Budget < b.Budget
+	}
+	return a.ID < b.ID
+}
+
+func byScore(projects []models.Project, scores map[int]int) []models.Project {
+	sorted := append([]models.Project(nil), projects...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return prefer(sorted[i], sorted[j], scores)
+	})
+	return sorted
+}
+
+// greedy funds projects in score order out of remaining, skipping any that
+// no longer fit.
+func greedy(result *Result, projects []models.Project, remaining int, unit string) {
+	for _, p := range byScore(projects, result.Scores) {
+		score := result.Scores[p.ID]
+		step := Step{ProjectID: p.ID, Title: p.Title, Cost: p.Budget, Score: score}
+		switch {
+		case score == 0:
+			step.Note = "нет поддержки"
+		case p.Budget > remaining:
+			step.Note = fmt.Sprintf("не помещается в остаток бюджета (%d ₸)", remaining)
+		default:
+			remaining -= p.Budget
+			step.Selected = true
+			step.Note = fmt.Sprintf("%d %s, профинансирован", score, unit)
+			result.Winners = append(result.Winners, p.ID)
+		}
+		step.Remaining = remaining
+		result.Steps = append(result.Steps, step)
+	}
+	result.BudgetUsed = result.Budget - remaining
+}
@@ -0,0 +1,163 @@
+package tally
+
+import (
+	"petropavlovsk-budget/internal/models"
+	"reflect"
+	"testing"
+)
+
+func project(id, budget, votes int) models.Project {
+	return models.Project{ID: id, Budget: budget, VoteCount: votes, Status: "voting"}
+}
+
+func ballot(entries ...models.BallotEntry) models.Ballot {
+	return models.Ballot{Entries: entries}
+}
+
+func pick(projectID, value int) models.BallotEntry {
+	return models.BallotEntry{ProjectID: projectID, Value: value}
+}
+
+func TestCountSingle(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotSingle, Budget: 1000}
+	withdrawn := project(4, 100, 50)
+	withdrawn.Status = "moderation"
+	projects := []models.Project{
+		project(1, 700, 10),
+		project(2, 500, 8),
+		project(3, 300, 8),
+		withdrawn,
+	}
+
+	result := Count(cycle, projects, nil)
+
+	// 3 ties with 2 on votes and is cheaper, so it goes first; 2 then
+	// no longer fits.
+	if want := []int{1, 3}; !reflect.DeepEqual(result.Winners, want) {
+		t.Errorf("Winners = %v, want %v", result.Winners, want)
+	}
+	if result.BudgetUsed != 1000 {
+		t.Errorf("BudgetUsed = %d, want 1000", result.BudgetUsed)
+	}
+	if result.Ballots != 26 {
+		t.Errorf("Ballots = %d, want 26", result.Ballots)
+	}
+	if len(result.Steps) != 3 {
+		t.Errorf("got %d steps, want 3: projects outside voting must not count", len(result.Steps))
+	}
+}
+
+func TestCountKnapsack(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotKnapsack, Budget: 600}
+	projects := []models.Project{project(1, 400, 0), project(2, 300, 0), project(3, 200, 0), project(4, 100, 0)}
+	ballots := []models.Ballot{
+		ballot(pick(1, 1), pick(3, 1)),
+		ballot(pick(1, 1), pick(2, 1)),
+		ballot(pick(2, 1), pick(3, 1)),
+		ballot(pick(1, 1)),
+	}
+
+	result := Count(cycle, projects, ballots)
+
+	if want := map[int]int{1: 3, 2: 2, 3: 2}; !reflect.DeepEqual(result.Scores, want) {
+		t.Errorf("Scores = %v, want %v", result.Scores, want)
+	}
+	if want := []int{1, 3}; !reflect.DeepEqual(result.Winners, want) {
+		t.Errorf("Winners = %v, want %v", result.Winners, want)
+	}
+	last := result.Steps[len(result.Steps)-1]
+	if last.ProjectID != 4 || last.Selected || last.Note != "нет поддержки" {
+		t.Errorf("unsupported project step = %+v", last)
+	}
+}
+
+func TestCountQuadratic(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotQuadratic, Budget: 500, Credits: 100}
+	projects := []models.Project{project(1, 300, 0), project(2, 300, 0), project(3, 200, 0)}
+	ballots := []models.Ballot{
+		ballot(pick(1, 9), pick(3, 4)),
+		ballot(pick(2, 5), pick(3, 5)),
+		ballot(pick(2, 7)),
+	}
+
+	result := Count(cycle, projects, ballots)
+
+	if want := map[int]int{1: 9, 2: 12, 3: 9}; !reflect.DeepEqual(result.Scores, want) {
+		t.Errorf("Scores = %v, want %v", result.Scores, want)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(result.Winners, want) {
+		t.Errorf("Winners = %v, want %v", result.Winners, want)
+	}
+}
+
+func TestCountEqualShares(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotRanked, Budget: 300}
+	projects := []models.Project{project(1, 200, 0), project(2, 100, 0), project(3, 100, 0)}
+	ballots := []models.Ballot{
+		ballot(pick(1, 1), pick(2, 2)),
+		ballot(pick(1, 1), pick(3, 2)),
+		ballot(pick(3, 1)),
+	}
+
+	result := Count(cycle, projects, ballots)
+
+	// Project 1 has the most points, but its two supporters spend part
+	// of their shares on 2 and 3, which are cheaper per point. Counting
+	// by points alone would fund 1 and 3 instead.
+	if want := map[int]int{1: 6, 2: 2, 3: 5}; !reflect.DeepEqual(result.Scores, want) {
+		t.Errorf("Scores = %v, want %v", result.Scores, want)
+	}
+	if want := []int{3, 2}; !reflect.DeepEqual(result.Winners, want) {
+		t.Errorf("Winners = %v, want %v", result.Winners, want)
+	}
+	if result.BudgetUsed != 200 {
+		t.Errorf("BudgetUsed = %d, want 200", result.BudgetUsed)
+	}
+}
+
+func TestCountEqualSharesWithoutBallots(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotRanked, Budget: 300}
+	projects := []models.Project{project(1, 200, 0)}
+
+	result := Count(cycle, projects, nil)
+
+	if len(result.Winners) != 0 {
+		t.Errorf("Winners = %v, want none", result.Winners)
+	}
+}
+
+func TestCountIgnoresInvalidRanks(t *testing.T) {
+	cycle := models.BudgetCycle{BallotType: models.BallotRanked, Budget: 100}
+	projects := []models.Project{project(1, 100, 0), project(2, 100, 0)}
+	ballots := []models.Ballot{ballot(pick(1, 5), pick(2, 1), pick(99, 1))}
+
+	result := Count(cycle, projects, ballots)
+
+	if want := map[int]int{2: 2}; !reflect.DeepEqual(result.Scores, want) {
+		t.Errorf("Scores = %v, want %v", result.Scores, want)
+	}
+	if !result.IsWinner(2) || result.IsWinner(1) {
+		t.Errorf("Winners = %v, want [2]", result.Winners)
+	}
+}
+
+func TestCountKeepsProjectsAfterVoting(t *testing.T) {
+	// A recount after the winners were started or finished must give the
+	// same result as on the day voting closed.
+	cycle := models.BudgetCycle{BallotType: models.BallotSingle, Budget: 1000}
+	var projects []models.Project
+	for i, status := range []string{"voting", "selected", "in_progress", "done", "rejected", "moderation"} {
+		p := project(i+1, 100, 10-i)
+		p.Status = status
+		projects = append(projects, p)
+	}
+
+	result := Count(cycle, projects, nil)
+
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(result.Winners, want) {
+		t.Errorf("Winners = %v, want %v", result.Winners, want)
+	}
+	if result.Ballots != 34 {
+		t.Errorf("Ballots = %d, want 34", result.Ballots)
+	}
+}
@@ -0,0 +1,97 @@
+package tally
+
+import (
+	"errors"
+	"petropavlovsk-budget/internal/models"
+)
+
+var (
+	ErrOverBudget     = errors.New("ballot exceeds cycle budget")
+	ErrTooManyPicks   = errors.New("ballot selects more projects than allowed")
+	ErrInvalidRanking = errors.New("ballot ranks must be 1..n without gaps")
+	ErrOverCredits    = errors.New("ballot spends more credits than allowed")
+	ErrInvalidEntry   = errors.New("ballot entry is invalid")
+)
+
+// QuadraticCost is the number of credits n votes for one project cost.
+func QuadraticCost(votes int) int {
+	return votes * votes
+}
+
+// MaxQuadraticVotes is the most votes one project can get from a ballot
+// with the given credits: the integer square root of credits.
+func MaxQuadraticVotes(credits int) int {
+	if credits < 1 {
+		return 0
+	}
+	n := 0
+	for lo, hi := 1, credits; lo <= hi; {
+		mid := lo + (hi-lo)/2
+		if mid <= credits/mid {
+			n, lo = mid, mid+1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return n
+}
+
+// Validate checks a ballot against the rules of its cycle. costs maps each
+// entry's project to its budget.
+func Validate(cycle models.BudgetCycle, entries []models.BallotEntry, costs map[int]int) error {
+	seen := map[int]bool{}
+	for _, e := range entries {
+		if seen[e.ProjectID] || e.Value < 1 {
+			return ErrInvalidEntry
+		}
+		seen[e.ProjectID] = true
+	}
+
+	switch cycle.BallotType {
+	case models.BallotKnapsack:
+		total := 0
+		for _, e := range entries {
+			total += costs[e.ProjectID]
+		}
+		if total > cycle.Budget {
+			return ErrOverBudget
+		}
+
+	case models.BallotApproval:
+		if cycle.MaxPicks > 0 && len(entries) > cycle.MaxPicks {
+			return ErrTooManyPicks
+		}
+
+	case models.BallotRanked:
+		if cycle.MaxPicks > 0 && len(entries) > cycle.MaxPicks {
+			return ErrTooManyPicks
+		}
+		ranks := map[int]bool{}
+		for _, e := range entries {
+			if e.Value > len(entries) || ranks[e.Value] {
+				return ErrInvalidRanking
+			}
+			ranks[e.Value] = true
+		}
+
+	case models.BallotQuadratic:
+		// Capping each entry first keeps QuadraticCost and the running
+		// total from overflowing on absurd values.
+		maxVotes := MaxQuadraticVotes(cycle.Credits)
+		spent := 0
+		for _, e := range entries {
+			if e.Value > maxVotes {
+				return ErrOverCredits
+			}
+			spent += QuadraticCost(e.Value)
+			if spent > cycle.Credits {
+				return ErrOverCredits
+			}
+		}
+
+	default:
+		return ErrInvalidEntry
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package tally
+
+import (
+	"errors"
+	"math"
+	"petropavlovsk-budget/internal/models"
+	"testing"
+)
+
+func entries(values ...int) []models.BallotEntry {
+	var out []models.BallotEntry
+	for i, v := range values {
+		out = append(out, models.BallotEntry{ProjectID: i + 1, Value: v})
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	costs := map[int]int{1: 400, 2: 300, 3: 500}
+	knapsack := models.BudgetCycle{BallotType: models.BallotKnapsack, Budget: 800}
+	approval := models.BudgetCycle{BallotType: models.BallotApproval, MaxPicks: 2}
+	ranked := models.BudgetCycle{BallotType: models.BallotRanked, MaxPicks: 3}
+	quadratic := models.BudgetCycle{BallotType: models.BallotQuadratic, Credits: 100}
+
+	tests := []struct {
+		name    string
+		cycle   models.BudgetCycle
+		entries []models.BallotEntry
+		want    error
+	}{
+		{"knapsack within budget", knapsack, entries(1, 1), nil},
+		{"knapsack over budget", knapsack, entries(1, 1, 1), ErrOverBudget},
+		{"duplicate project", knapsack, []models.BallotEntry{{ProjectID: 1, Value: 1}, {ProjectID: 1, Value: 1}}, ErrInvalidEntry},
+		{"zero value", approval, entries(0), ErrInvalidEntry},
+		{"approval within picks", approval, entries(1, 1), nil},
+		{"approval too many picks", approval, entries(1, 1, 1), ErrTooManyPicks},
+		{"ranked complete", ranked, entries(2, 1, 3), nil},
+		{"ranked gap", ranked, entries(1, 3), ErrInvalidRanking},
+		{"ranked duplicate rank", ranked, entries(1, 1), ErrInvalidRanking},
+		{"ranked too many picks", ranked, entries(1, 2, 3, 4), ErrTooManyPicks},
+		{"quadratic exact credits", quadratic, entries(6, 8), nil},
+		{"quadratic over credits", quadratic, entries(6, 9), ErrOverCredits},
+		{"quadratic single entry over cap", quadratic, entries(11), ErrOverCredits},
+		{"quadratic overflowing value", quadratic, entries(math.MaxInt32), ErrOverCredits},
+		{"quadratic max int value", quadratic, entries(math.MaxInt), ErrOverCredits},
+		{"unknown ballot type", models.BudgetCycle{BallotType: "plurality"}, entries(1), ErrInvalidEntry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.cycle, tt.entries, costs)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxQuadraticVotes(t *testing.T) {
+	tests := []struct{ credits, want int }{
+		{-5, 0}, {0, 0}, {1, 1}, {3, 1}, {4, 2}, {99, 9}, {100, 10},
+		{math.MaxInt32, 46340}, {math.MaxInt64, 3037000499},
+	}
+	for _, tt := range tests {
+		if got := MaxQuadraticVotes(tt.credits); got != tt.want {
+			t.Errorf("MaxQuadraticVotes(%d) = %d, want %d", tt.credits, got, tt.want)
+		}
+	}
+}
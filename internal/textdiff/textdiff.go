@@ -0,0 +1,240 @@
+// Package textdiff compares two texts word by word, for showing what an
+// edit changed.
+package textdiff
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// Op is a run of text that both versions share, or that only the new
+// (Insert) or only the old (Delete) version has.
+type Op struct {
+	Kind string
+	Text string
+}
+
+// maxEdits bounds the work spent on very different texts; past it the
+// whole old text is shown as deleted and the new one as inserted.
+const maxEdits = 1000
+
+// Words diffs a and b at word granularity. Whitespace and punctuation are
+// tokens of their own, so a changed word does not drag its neighbours
+// into the change.
+func Words(a, b string) []Op {
+	if a == b {
+		if a == "" {
+			return nil
+		}
+		return []Op{{Equal, a}}
+	}
+	x, y := tokenize(a), tokenize(b)
+
+	// Common ends are cheap to strip and keep the search below small.
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+
+	var ops []Op
+	for _, t := range x[:pre] {
+		ops = appendOp(ops, Equal, t)
+	}
+	ops = append(ops, myers(x[pre:len(x)-suf], y[pre:len(y)-suf])...)
+	for _, t := range x[len(x)-suf:] {
+		ops = appendOp(ops, Equal, t)
+	}
+	return merge(absorbSpaces(merge(ops)))
+}
+
+// Changed reports whether ops contain any insertion or deletion.
+func Changed(ops []Op) bool {
+	for _, op := range ops {
+		if op.Kind != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWord(runes[i]):
+			for j < len(runes) && isWord(runes[j]) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, string(runes[i:j]))
+		i = j
+	}
+	return tokens
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// myers finds a shortest edit script from x to y with Myers' O(ND)
+// algorithm. Round d only reaches diagonals -d..d, so only that part of
+// the furthest points is kept for tracing the path back.
+func myers(x, y []string) []Op {
+	n, m := len(x), len(y)
+	max := n + m
+	if max > 0 && (n == 0 || m == 0) {
+		return replaceAll(x, y)
+	}
+	if max == 0 {
+		return nil
+	}
+	limit := max
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				i = v[offset+k+1]
+			} else {
+				i = v[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return replaceAll(x, y)
+	}
+
+	// Walk back from (n, m). trace[d] holds the furthest points on
+	// diagonals -d-1..d+1 before round d, which tell where round d's path
+	// came from.
+	var rev []Op
+	i, j := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d]
+		k := i - j
+		pk := k - 1
+		if k == -d || (k != d && prev[k-1+d+1] < prev[k+1+d+1]) {
+			pk = k + 1
+		}
+		pi := prev[pk+d+1]
+		pj := pi - pk
+		for i > pi && j > pj {
+			i--
+			j--
+			rev = append(rev, Op{Equal, x[i]})
+		}
+		if d == 0 {
+			break
+		}
+		if i == pi {
+			rev = append(rev, Op{Insert, y[pj]})
+		} else {
+			rev = append(rev, Op{Delete, x[pi]})
+		}
+		i, j = pi, pj
+	}
+
+	var ops []Op
+	for n := len(rev) - 1; n >= 0; n-- {
+		ops = appendOp(ops, rev[n].Kind, rev[n].Text)
+	}
+	return ops
+}
+
+func replaceAll(x, y []string) []Op {
+	var ops []Op
+	if len(x) > 0 {
+		ops = append(ops, Op{Delete, strings.Join(x, "")})
+	}
+	if len(y) > 0 {
+		ops = append(ops, Op{Insert, strings.Join(y, "")})
+	}
+	return ops
+}
+
+func appendOp(ops []Op, kind, text string) []Op {
+	if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+		ops[len(ops)-1].Text += text
+		return ops
+	}
+	return append(ops, Op{kind, text})
+}
+
+// absorbSpaces folds whitespace kept between two changes into both sides
+// of the change, so "a b" → "c d" reads as one replacement rather than
+// two separate ones.
+func absorbSpaces(ops []Op) []Op {
+	var out []Op
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+		if op.Kind == Equal && strings.TrimSpace(op.Text) == "" && i > 0 && i+1 < len(ops) &&
+			ops[i-1].Kind != Equal && ops[i+1].Kind != Equal {
+			out = append(out, Op{Delete, op.Text}, Op{Insert, op.Text})
+			continue
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+// merge joins neighbouring runs of the same kind and orders each changed
+// stretch as all deletions followed by all insertions.
+func merge(ops []Op) []Op {
+	var out []Op
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == Equal {
+			out = appendOp(out, Equal, ops[i].Text)
+			i++
+			continue
+		}
+		var del, ins strings.Builder
+		for ; i < len(ops) && ops[i].Kind != Equal; i++ {
+			if ops[i].Kind == Delete {
+				del.WriteString(ops[i].Text)
+			} else {
+				ins.WriteString(ops[i].Text)
+			}
+		}
+		if del.Len() > 0 {
+			out = append(out, Op{Delete, del.String()})
+		}
+		if ins.Len() > 0 {
+			out = append(out, Op{Insert, ins.String()})
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package textdiff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sides rebuilds the old and the new text from ops.
+func sides(ops []Op) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.Kind != Insert {
+			a.WriteString(op.Text)
+		}
+		if op.Kind != Delete {
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", nil},
+		{"identical", "Новая площадка", "Новая площадка", []Op{{Equal, "Новая площадка"}}},
+		{"from empty", "", "Сквер", []Op{{Insert, "Сквер"}}},
+		{"to empty", "Сквер", "", []Op{{Delete, "Сквер"}}},
+		{
+			"one word replaced",
+			"Детская площадка во дворе",
+			"Спортивная площадка во дворе",
+			[]Op{{Delete, "Детская"}, {Insert, "Спортивная"}, {Equal, " площадка во дворе"}},
+		},
+		{
+			"word inserted",
+			"Площадка во дворе",
+			"Площадка во большом дворе",
+			[]Op{{Equal, "Площадка во "}, {Insert, "большом "}, {Equal, "дворе"}},
+		},
+		{
+			"punctuation is its own token",
+			"Бюджет 500000.",
+			"Бюджет 500000!",
+			[]Op{{Equal, "Бюджет 500000"}, {Delete, "."}, {Insert, "!"}},
+		},
+		{
+			"adjacent words read as one replacement",
+			"сквер у школы",
+			"парк на площади",
+			[]Op{{Delete, "сквер у школы"}, {Insert, "парк на площади"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+			if Changed(got) != (tt.a != tt.b) {
+				t.Errorf("Changed = %v for %q → %q", Changed(got), tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestWordsRebuildsBothSides(t *testing.T) {
+	words := []string{"сквер", "парк", "школа", "двор", " ", " ", "  ", ",", ".", "\n", "2026", "фонтан"}
+	rng := rand.New(rand.NewSource(1))
+	text := func() string {
+		var b strings.Builder
+		for n := rng.Intn(40); n > 0; n-- {
+			b.WriteString(words[rng.Intn(len(words))])
+		}
+		return b.String()
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := text(), text()
+		ops := Words(a, b)
+		if gotA, gotB := sides(ops); gotA != a || gotB != b {
+			t.Fatalf("Words(%q, %q) = %q rebuilds %q → %q", a, b, ops, gotA, gotB)
+		}
+		for j, op := range ops {
+			if op.Text == "" {
+				t.Fatalf("Words(%q, %q) has an empty op at %d: %q", a, b, j, ops)
+			}
+			if j > 0 && ops[j-1].Kind == op.Kind {
+				t.Fatalf("Words(%q, %q) has two %s ops in a row: %q", a, b, op.Kind, ops)
+			}
+			if j > 0 && ops[j-1].Kind == Insert && op.Kind == Delete {
+				t.Fatalf("Words(%q, %q) puts an insertion before a deletion: %q", a, b, ops)
+			}
+		}
+	}
+}
+
+func TestMyersGivesUpOnUnrelatedTexts(t *testing.T) {
+	// Past maxEdits the texts are shown as replaced wholesale instead of
+	// word by word.
+	var a, b strings.Builder
+	for i := 0; i < maxEdits; i++ {
+		a.WriteString("сквер ")
+		b.WriteString("парк ")
+	}
+	ops := myers(tokenize(a.String()), tokenize(b.String()))
+	if want := []Op{{Delete, a.String()}, {Insert, b.String()}}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %d ops, want a single replacement", len(ops))
+	}
+
+	// Below the limit the shared spaces are found.
+	ops = myers(tokenize("сквер сквер"), tokenize("парк парк"))
+	if len(ops) != 5 {
+		t.Errorf("myers = %q, want the two words replaced separately", ops)
+	}
+}
@@ -0,0 +1,93 @@
+package votelog
+
+import (
+	"petropavlovsk-budget/internal/models"
+	"petropavlovsk-budget/internal/tally"
+)
+
+// Export is the public, anonymized snapshot of a cycle: its rules, the
+// projects on the ballot, the full log and the result the server
+// published. cmd/verify-tally recomputes Result from Entries alone.
+type Export struct {
+	CycleID    int             `json:"cycle_id"`
+	Title      string          `json:"title"`
+	Budget     int             `json:"budget"`
+	BallotType string          `json:"ballot_type"`
+	MaxPicks   int             `json:"max_picks,omitempty"`
+	Credits    int             `json:"credits,omitempty"`
+	RootHash   string          `json:"root_hash,omitempty"`
+	Projects   []ExportProject `json:"projects"`
+	Entries    []Entry         `json:"entries"`
+	Result     ExportResult    `json:"result"`
+}
+
+type ExportProject struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Budget int    `json:"budget"`
+	Status string `json:"status"`
+}
+
+type ExportResult struct {
+	Scores  map[int]int `json:"scores"`
+	Winners []int       `json:"winners"`
+}
+
+// NewExport assembles an export from server-side data.
+func NewExport(cycle models.BudgetCycle, projects []models.Project, entries []Entry, result tally.Result) Export {
+	x := Export{
+		CycleID:    cycle.ID,
+		Title:      cycle.Title,
+		Budget:     cycle.Budget,
+		BallotType: cycle.BallotType,
+		MaxPicks:   cycle.MaxPicks,
+		Credits:    cycle.Credits,
+		RootHash:   cycle.RootHash,
+		Entries:    entries,
+		Result:     ExportResult{Scores: result.Scores, Winners: result.Winners},
+	}
+	for _, p := range projects {
+		x.Projects = append(x.Projects, ExportProject{ID: p.ID, Title: p.Title, Budget: p.Budget, Status: p.Status})
+	}
+	if x.Entries == nil {
+		x.Entries = []Entry{}
+	}
+	return x
+}
+
+// Recount computes the cycle result from the log only. For single-vote
+// cycles each effective ballot is one vote for its project.
+func (x Export) Recount() tally.Result {
+	cycle := models.BudgetCycle{
+		ID:         x.CycleID,
+		Title:      x.Title,
+		Budget:     x.Budget,
+		BallotType: x.BallotType,
+		MaxPicks:   x.MaxPicks,
+		Credits:    x.Credits,
+	}
+	ballots := Effective(x.Entries)
+
+	votes := map[int]int{}
+	if x.BallotType == models.BallotSingle {
+		for _, b := range ballots {
+			for _, e := range b.Entries {
+				votes[e.ProjectID]++
+			}
+		}
+		ballots = nil
+	}
+
+	var projects []models.Project
+	for _, p := range x.Projects {
+		projects = append(projects, models.Project{
+			ID:        p.ID,
+			Title:     p.Title,
+			Budget:    p.Budget,
+			Status:    p.Status,
+			VoteCount: votes[p.ID],
+		})
+	}
+
+	return tally.Count(cycle, projects, ballots)
+}
@@ -0,0 +1,166 @@
+// Package votelog implements the tamper-evident record of votes. Every
+// ballot, and every later change to whether it counts, is appended to a
+// per-cycle chain in which each entry commits to the hash of the previous
+// one. Publishing the last hash (the root) fixes the whole history: any
+// edit, insertion or deletion before it changes the root.
+//
+// The log is anonymous. An entry carries a random receipt code known only
+// to the voter and the projects chosen, never the user, IP or time.
+package votelog
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"petropavlovsk-budget/internal/models"
+	"strings"
+)
+
+const (
+	// KindCast records a new ballot.
+	KindCast = "cast"
+	// KindVoid excludes the ballot with the same receipt from the count,
+	// e.g. when a vote is quarantined.
+	KindVoid = "void"
+	// KindRestore makes a voided ballot count again.
+	KindRestore = "restore"
+)
+
+// GenesisHash is the previous hash of the first entry of every chain.
+var GenesisHash = strings.Repeat("0", 64)
+
+type Entry struct {
+	Seq      int                  `json:"seq"`
+	CycleID  int                  `json:"cycle_id"`
+	Kind     string               `json:"kind"`
+	Receipt  string               `json:"receipt"`
+	Entries  []models.BallotEntry `json:"entries,omitempty"`
+	PrevHash string               `json:"prev_hash"`
+	Hash     string               `json:"hash"`
+}
+
+// Payload is the exact byte string that is hashed for an entry. It is
+// line-based rather than JSON so that independent implementations do not
+// have to agree on JSON canonicalisation.
+func (e Entry) Payload() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%d\n%d\n%s\n%s\n", e.PrevHash, e.Seq, e.CycleID, e.Kind, e.Receipt)
+	for i, be := range e.Entries {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d:%d", be.ProjectID, be.Value)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// ComputeHash returns the hex SHA-256 of the entry's payload.
+func (e Entry) ComputeHash() string {
+	sum := sha256.Sum256(e.Payload())
+	return hex.EncodeToString(sum[:])
+}
+
+// Next builds the entry that follows prev (nil for the first entry of a
+// chain) and fills in its hash.
+func Next(prev *Entry, cycleID int, kind, receipt string, entries []models.BallotEntry) Entry {
+	e := Entry{
+		Seq:      1,
+		CycleID:  cycleID,
+		Kind:     kind,
+		Receipt:  receipt,
+		Entries:  entries,
+		PrevHash: GenesisHash,
+	}
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	e.Hash = e.ComputeHash()
+	return e
+}
+
+// Verify checks that entries form an unbroken chain starting from the
+// genesis hash and, if root is not empty, that the chain ends exactly at
+// root.
+func Verify(entries []Entry, root string) error {
+	prev := GenesisHash
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			return fmt.Errorf("entry %d: expected seq %d, got %d", i, i+1, e.Seq)
+		}
+		if e.PrevHash != prev {
+			return fmt.Errorf("entry %d: previous hash does not match the chain", e.Seq)
+		}
+		if got := e.ComputeHash(); got != e.Hash {
+			return fmt.Errorf("entry %d: hash mismatch (stored %s, computed %s)", e.Seq, e.Hash, got)
+		}
+		prev = e.Hash
+	}
+
+	if root != "" {
+		if len(entries) == 0 {
+			return fmt.Errorf("log is empty but root %s was published", root)
+		}
+		if prev != root {
+			return fmt.Errorf("chain ends at %s, published root is %s", prev, root)
+		}
+	}
+	return nil
+}
+
+// Effective replays the log and returns the ballots that count, in the
+// order they were cast.
+func Effective(entries []Entry) []models.Ballot {
+	active := map[string]bool{}
+	index := map[string]int{}
+	var cast []Entry
+
+	for _, e := range entries {
+		switch e.Kind {
+		case KindCast:
+			index[e.Receipt] = len(cast)
+			cast = append(cast, e)
+			active[e.Receipt] = true
+		case KindVoid:
+			active[e.Receipt] = false
+		case KindRestore:
+			if _, ok := index[e.Receipt]; ok {
+				active[e.Receipt] = true
+			}
+		}
+	}
+
+	var ballots []models.Ballot
+	for _, e := range cast {
+		if active[e.Receipt] {
+			ballots = append(ballots, models.Ballot{CycleID: e.CycleID, Entries: e.Entries})
+		}
+	}
+	return ballots
+}
+
+var receiptEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewReceipt returns a random 16-character code, grouped in fours for
+// reading aloud or copying by hand.
+func NewReceipt() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := receiptEncoding.EncodeToString(buf)
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// NormalizeReceipt upper-cases a code typed by a voter and restores the
+// dashes if they were left out.
+func NormalizeReceipt(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
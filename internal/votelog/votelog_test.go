@@ -0,0 +1,177 @@
+package votelog
+
+import (
+	"petropavlovsk-budget/internal/models"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func pick(projectID, value int) models.BallotEntry {
+	return models.BallotEntry{ProjectID: projectID, Value: value}
+}
+
+// chain builds a log of cycle 7 from (kind, receipt, entries) steps.
+func chain(steps ...Entry) []Entry {
+	var log []Entry
+	for _, s := range steps {
+		var prev *Entry
+		if len(log) > 0 {
+			prev = &log[len(log)-1]
+		}
+		log = append(log, Next(prev, 7, s.Kind, s.Receipt, s.Entries))
+	}
+	return log
+}
+
+func sampleLog() []Entry {
+	return chain(
+		Entry{Kind: KindCast, Receipt: "AAAA-AAAA-AAAA-AAAA", Entries: []models.BallotEntry{pick(1, 1), pick(2, 1)}},
+		Entry{Kind: KindCast, Receipt: "BBBB-BBBB-BBBB-BBBB", Entries: []models.BallotEntry{pick(2, 1)}},
+		Entry{Kind: KindCast, Receipt: "CCCC-CCCC-CCCC-CCCC", Entries: []models.BallotEntry{pick(3, 1)}},
+		Entry{Kind: KindVoid, Receipt: "BBBB-BBBB-BBBB-BBBB"},
+	)
+}
+
+func TestPayloadFormat(t *testing.T) {
+	// cmd/verify-tally and third-party verifiers hash exactly these
+	// bytes; changing them breaks every published root.
+	e := Next(nil, 7, KindCast, "AAAA-AAAA-AAAA-AAAA", []models.BallotEntry{pick(1, 1), pick(2, 3)})
+	want := GenesisHash + "\n1\n7\ncast\nAAAA-AAAA-AAAA-AAAA\n1:1,2:3\n"
+	if got := string(e.Payload()); got != want {
+		t.Errorf("Payload = %q, want %q", got, want)
+	}
+	if want := "4efb7aab952934c86fba4cd2545109593c17a366660b9668ef8b94ba88070dab"; e.Hash != want {
+		t.Errorf("Hash = %s, want %s", e.Hash, want)
+	}
+
+	void := Next(&e, 7, KindVoid, "AAAA-AAAA-AAAA-AAAA", nil)
+	if got, want := string(void.Payload()), e.Hash+"\n2\n7\nvoid\nAAAA-AAAA-AAAA-AAAA\n\n"; got != want {
+		t.Errorf("void Payload = %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	log := sampleLog()
+	root := log[len(log)-1].Hash
+
+	if err := Verify(log, root); err != nil {
+		t.Fatalf("untouched log: %v", err)
+	}
+	if err := Verify(log, ""); err != nil {
+		t.Fatalf("untouched log without root: %v", err)
+	}
+	if err := Verify(nil, ""); err != nil {
+		t.Fatalf("empty log: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func([]Entry) []Entry
+		root   string
+		errHas string
+	}{
+		{"changed vote", func(l []Entry) []Entry {
+			l[1].Entries = []models.BallotEntry{pick(3, 1)}
+			return l
+		}, root, "hash mismatch"},
+		{"changed vote with recomputed hash", func(l []Entry) []Entry {
+			l[1].Entries = []models.BallotEntry{pick(3, 1)}
+			l[1].Hash = l[1].ComputeHash()
+			return l
+		}, root, "previous hash"},
+		{"deleted entry", func(l []Entry) []Entry {
+			return append(l[:1], l[2:]...)
+		}, root, "seq"},
+		{"deleted void, rechained", func(l []Entry) []Entry {
+			return chain(l[:3]...)
+		}, root, "published root"},
+		{"swapped entries", func(l []Entry) []Entry {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, root, "seq"},
+		{"truncated", func(l []Entry) []Entry {
+			return l[:3]
+		}, root, "published root"},
+		{"root without log", func([]Entry) []Entry {
+			return nil
+		}, root, "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.tamper(sampleLog()), tt.root)
+			if err == nil || !strings.Contains(err.Error(), tt.errHas) {
+				t.Errorf("Verify = %v, want an error mentioning %q", err, tt.errHas)
+			}
+		})
+	}
+}
+
+func TestEffective(t *testing.T) {
+	// B stays void, C is voided and restored, and restoring a receipt
+	// that was never cast adds nothing.
+	log := chain(append(sampleLog(),
+		Entry{Kind: KindVoid, Receipt: "CCCC-CCCC-CCCC-CCCC"},
+		Entry{Kind: KindRestore, Receipt: "CCCC-CCCC-CCCC-CCCC"},
+		Entry{Kind: KindRestore, Receipt: "DDDD-DDDD-DDDD-DDDD"},
+	)...)
+
+	got := Effective(log)
+	want := []models.Ballot{
+		{CycleID: 7, Entries: []models.BallotEntry{pick(1, 1), pick(2, 1)}},
+		{CycleID: 7, Entries: []models.BallotEntry{pick(3, 1)}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Effective = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecountSingle(t *testing.T) {
+	x := Export{
+		CycleID:    7,
+		Budget:     1000,
+		BallotType: models.BallotSingle,
+		Projects: []ExportProject{
+			{ID: 1, Budget: 600, Status: "voting"},
+			{ID: 2, Budget: 600, Status: "voting"},
+			{ID: 3, Budget: 300, Status: "voting"},
+		},
+		Entries: sampleLog(),
+	}
+
+	result := x.Recount()
+	// Ballot B is void, so project 2 keeps only A's vote.
+	if want := map[int]int{1: 1, 2: 1, 3: 1}; !reflect.DeepEqual(result.Scores, want) {
+		t.Errorf("Scores = %v, want %v", result.Scores, want)
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	format := regexp.MustCompile(`^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := NewReceipt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("receipt %q has the wrong format", code)
+		}
+		if seen[code] {
+			t.Fatalf("receipt %q issued twice", code)
+		}
+		seen[code] = true
+	}
+
+	for in, want := range map[string]string{
+		"abcd efgh ijkl mnop": "ABCD-EFGH-IJKL-MNOP",
+		"ABCDEFGHIJKLMNOP":    "ABCD-EFGH-IJKL-MNOP",
+		"abcd-efgh-ijkl-mnop": "ABCD-EFGH-IJKL-MNOP",
+		"abc":                 "ABC",
+	} {
+		if got := NormalizeReceipt(in); got != want {
+			t.Errorf("NormalizeReceipt(%q) = %q, want %q", in, got, want)
+		}
+	}
+}